@@ -2,56 +2,212 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"harbor-cleaner/internal/cleaner"
 	"harbor-cleaner/internal/config"
 	"harbor-cleaner/internal/harbor"
 	"harbor-cleaner/internal/k8s"
+	"harbor-cleaner/internal/redact"
+	"harbor-cleaner/internal/storage"
 	"harbor-cleaner/internal/utils"
+	"harbor-cleaner/internal/webhook"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
 )
 
+const (
+	// exitInterrupted is the exit code used when a run is stopped by SIGINT/SIGTERM.
+	exitInterrupted = 130
+	// exitTimeLimit is the exit code used when a run stops early because max-run-duration elapsed.
+	exitTimeLimit = 124
+	// exitGuarded is the exit code used when k8s.strict left in-use repositories uncleaned.
+	exitGuarded = 1
+	// exitUnexpectedDeletions is the exit code used when a dry run's deletions fall outside
+	// expect-deletions.
+	exitUnexpectedDeletions = 3
+)
+
 // main function orchestrates the entire process
 func main() {
 	configPath := pflag.StringP("config", "c", "config.yaml", "Path to the configuration file.")
+	manifestFiles := pflag.StringArrayP("manifest-file", "m", nil, "Manifest file to write (scan) or read (clean). Repeat to merge several manifests in the clean stage.")
+	since := pflag.String("since", "", "Harbor strategy only: skip repositories without pushes after this RFC 3339 time, or \"last\" for the previous successful run.")
+	maxPerProject := pflag.Int("max-concurrency-per-project", 0, "Harbor strategy only: clean at most this many repositories of one project at a time (0 for no limit).")
+	resume := pflag.Bool("resume", false, "Harbor strategy and clean stage only: skip repositories completed by a previous run that was interrupted.")
+	continueOnError := pflag.Bool("continue-on-error", false, "Process the projects and repositories listed before a list request failed part-way instead of aborting.")
+	inventoryFile := pflag.String("inventory-file", "", "Write a snapshot of every artifact (CSV, or JSON for a .json path) before a run that can delete anything.")
+	printOnly := pflag.Bool("print", false, "Scan stage only: print the safe list to stdout instead of writing the manifest file.")
+	validate := pflag.Bool("validate", false, "Scan stage only: check that every environment and namespace can be read and report the images found, without writing the manifest. Exits 1 on any failure.")
+	force := pflag.Bool("force", false, "Clean stage only: clean even if the manifests list no images, which deletes every tagged artifact of the selected repositories.")
+	strict := pflag.Bool("strict", false, "Clean stage only: skip an in-use repository in which no artifact is in the safe list instead of deleting its contents, and exit 1 (same as k8s.strict).")
+	onlyRepos := pflag.StringArray("only-repos-matching", nil, "Only process repositories whose full name matches this pattern (* and ? allowed). Repeat for several patterns; replaces harbor.only-repos-matching.")
+	quiet := pflag.Bool("quiet", false, "Leave the per-artifact lines out of the log and only log per-repository summaries and the final report (same as log.level: warn).")
+	explainK8s := pflag.Bool("explain-k8s", false, "Clean stage only: log the manifest key looked up for every artifact and, when it is missing, the manifest entries that nearly match it (same as k8s.explain).")
+	groupByStatus := pflag.Bool("group-audit-by-status", false, "Group the audit report by status, deletions first, then by image, instead of by repository (same as audit-group-by-status).")
+	includeUntagged := pflag.Bool("include-untagged-in-count", false, "Harbor strategy only: decide untagged artifacts like tagged ones and delete those beyond keep-last, instead of skipping them; they take their place in keep-last either way (same as harbor.include-untagged-in-count).")
+	sortKey := pflag.String("sort-key", "", "Harbor strategy only: time artifacts are ranked by, \"push_time\" (default) or \"create_time\" to rank by image build time so retagging an old image doesn't make it look new (same as harbor.sort-key).")
+	limit := pflag.Int("limit", 0, "Stop after processing this many repositories in total, e.g. to try new settings on a few repositories first (0 for no limit).")
+	deleteArtifact := pflag.String("delete-artifact", "", "Delete this one artifact, project/repo:tag or project/repo@digest, after showing its tags and push time and asking for the first 12 characters of its digest, then exit.")
+	confirmDigest := pflag.String("confirm-digest", "", "With --delete-artifact: the first 12 characters of the artifact's digest, instead of being asked for them.")
+	yes := pflag.Bool("yes", false, "With --delete-artifact: delete without confirming the digest.")
+	diffManifest := pflag.String("diff-manifest", "", "Compare this previous manifest with the current one (-m) and print the changes to the safe list, then exit.")
 	pflag.Parse()
 
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("❌ Failed to load configuration: %v", err)
 	}
+	if len(*manifestFiles) == 0 {
+		*manifestFiles = []string{cfg.K8s.ManifestFile}
+	}
+	for i, path := range *manifestFiles {
+		(*manifestFiles)[i] = storage.Resolve(path, "safe-images-manifest.csv")
+	}
+	cfg.K8s.ManifestFile = (*manifestFiles)[0]
+	if pflag.Lookup("since").Changed {
+		cfg.Harbor.Since = *since
+	}
+	if pflag.Lookup("max-concurrency-per-project").Changed {
+		cfg.Harbor.MaxConcurrencyPerProject = *maxPerProject
+	}
+	if pflag.Lookup("continue-on-error").Changed {
+		cfg.ContinueOnError = *continueOnError
+	}
+	if pflag.Lookup("inventory-file").Changed {
+		cfg.InventoryExportFile = *inventoryFile
+	}
+	if pflag.Lookup("only-repos-matching").Changed {
+		if cfg.Harbor.BatchFile != "" {
+			log.Fatalf("❌ --only-repos-matching cannot be combined with harbor.batch-file")
+		}
+		cfg.Harbor.OnlyReposMatching = *onlyRepos
+	}
+	if *quiet {
+		cfg.LogLevel = "warn"
+	}
+	if *strict {
+		cfg.K8s.Strict = true
+	}
+	if *explainK8s {
+		cfg.K8s.Explain = true
+	}
+	if *groupByStatus {
+		cfg.AuditGroupByStatus = true
+	}
+	if *includeUntagged {
+		cfg.Harbor.IncludeUntaggedInCount = true
+	}
+	if *sortKey != "" {
+		cfg.Harbor.SortKey = *sortKey
+	}
+	if *limit < 0 {
+		log.Fatalf("❌ --limit must not be negative, got %d", *limit)
+	}
+	cfg.Harbor.RepoLimit = *limit
+	if *diffManifest != "" {
+		if err := printManifestDiff(storage.Resolve(*diffManifest, "safe-images-manifest.csv"), *manifestFiles); err != nil {
+			log.Fatalf("❌ Failed to compare manifests: %v", err)
+		}
+		return
+	}
+	runStart := time.Now()
 
 	// --- Logging setup ---
 	timestamp := time.Now().Format("20060102-150405")
-	logFileName := cfg.LogFile
-	if logFileName == "" {
-		logFileName = fmt.Sprintf("harbor-cleaner-%s-strategy-%s-stage-%s.log", timestamp, cfg.Strategy, cfg.K8s.Stage)
-	}
-	logFile, err := os.OpenFile(logFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	cfg.RunID = utils.NewRunID(runStart)
+	auditRun := utils.AuditRun{ID: cfg.RunID, Time: runStart, Strategy: cfg.Strategy}
+	defaultLogName := fmt.Sprintf("harbor-cleaner-%s-strategy-%s-stage-%s.log", timestamp, cfg.Strategy, cfg.K8s.Stage)
+	logFileName := outputPath(cfg.LogFile, defaultLogName)
+	logFile, closeLog, err := openLog(logFileName)
 	if err != nil {
 		log.Fatalf("❌ Failed to open log file: %v", err)
 	}
-	defer logFile.Close()
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(multiWriter)
+	defer closeLog()
+	// stdout stays human-readable; the file sink can have its own format.
+	fileWriter := logFile
+	if cfg.LogFileFormat == "json" {
+		fileWriter = utils.NewJSONLogWriter(logFile, cfg.RunID)
+	}
+	log.SetOutput(io.MultiWriter(os.Stdout, fileWriter))
 
 	// --- Script startup info ---
 	log.Println("🚀 Harbor Cleanup Script Started")
+	log.Printf("🆔 Run ID: %s", cfg.RunID)
 	log.Printf("⚖️  Using strategy: %s", cfg.Strategy)
+	if cfg.LogRetentionDays > 0 {
+		pruneRunLogs(logFileName, cfg.LogRetentionDays, runStart)
+	}
+	if len(cfg.Harbor.Batch) > 0 {
+		logBatch(cfg.Harbor.BatchFile, cfg.Harbor.Batch)
+	}
+	if cfg.Harbor.Since != "" {
+		cfg.Harbor.SinceTime, err = resolveSince(cfg.Harbor.Since, cfg.Harbor.LastRunFile)
+		if err != nil {
+			log.Fatalf("❌ Invalid 'since' value: %v", err)
+		}
+		if !cfg.Harbor.SinceTime.IsZero() {
+			log.Printf("⏱️  Only processing repositories with pushes since %s", cfg.Harbor.SinceTime.Format(time.RFC3339))
+		}
+	}
 	if cfg.Strategy == "k8s" {
 		log.Printf("  -> Stage: %s", cfg.K8s.Stage)
 	}
 	if cfg.DryRun {
 		log.Println("⚠️  Running in DRY-RUN mode.")
 	}
+	if cfg.AlertThreshold > 0 && !cfg.DryRun {
+		log.Printf("⚠️  alert-threshold is set but only applies to dry runs; this run deletes without alerting.")
+	}
+	if cfg.Harbor.Quarantine.Enabled {
+		log.Printf("🏷️  Quarantine mode: expired artifacts are labelled '%s-YYYYMMDD' and deleted after %d days.", cfg.Harbor.Quarantine.LabelPrefix, cfg.Harbor.Quarantine.GraceDays)
+	}
+	if *deleteArtifact != "" {
+		client, _ := newHarborClient(&cfg)
+		if err := cleaner.DeleteOne(client, &cfg, *deleteArtifact, *confirmDigest, *yes, os.Stdin); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return
+	}
 
-	var artifactsDeleted int
-	var auditData [][]string
+	// --- Signal handling ---
+	// The first SIGINT/SIGTERM cancels ctx so the strategies stop after the current artifact
+	// and the partial audit can still be written. A second signal terminates immediately.
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+	ctx := sigCtx
+	if cfg.MaxRunDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(sigCtx, cfg.MaxRunDuration)
+		defer cancel()
+		log.Printf("⏰ Run time limit: %s", cfg.MaxRunDuration)
+	}
+	if cfg.Strategy != "k8s" || cfg.K8s.Stage == "clean" {
+		findOwnImages(ctx, &cfg)
+	}
+
+	var result cleaner.Result
+	var checkpoint *cleaner.Checkpoint
+	// The strategy's Harbor client and the system info of its instance, for the summary and
+	// alerts; harborInfo is nil if the strategy doesn't use Harbor or it couldn't be read.
+	var client *harbor.HarborClient
+	var harborInfo *harbor.SystemInfo
 
 	// --- Strategy router ---
 	switch cfg.Strategy {
@@ -59,43 +215,69 @@ func main() {
 		switch cfg.K8s.Stage {
 		case "scan":
 			log.Println("--- K8s Stage: SCAN ---")
-			k8sSafeList, err := k8s.BuildK8sImageSafeList(&cfg.K8s)
+			if len(*manifestFiles) > 1 {
+				log.Fatalf("❌ The scan stage writes a single manifest, but %d manifest files were given.", len(*manifestFiles))
+			}
+			if *validate {
+				validateK8s(ctx, &cfg.K8s)
+				break
+			}
+			scan, err := k8s.BuildK8sImageSafeList(ctx, &cfg.K8s)
+			printEnvBreakdown(scan.Environments)
 			if err != nil {
 				log.Fatalf("❌ Failed to build k8s safe list: %v", err)
 			}
+			k8sSafeList := scan.Images
 			log.Printf("✅ Kubernetes safe list built. Found %d unique images in use.", len(k8sSafeList))
 
-			err = utils.WriteManifestToCSV(k8sSafeList, cfg.K8s.ManifestFile)
+			if *printOnly {
+				if err := utils.PrintSafeList(k8sSafeList, os.Stdout); err != nil {
+					log.Fatalf("❌ Failed to print safe list: %v", err)
+				}
+				log.Println("🖨️  Safe list printed; manifest file was not written.")
+				break
+			}
+
+			err = retryWrite("manifest", func() error { return utils.WriteManifestToCSV(k8sSafeList, cfg.K8s.ManifestFile, cfg.RunID) })
 			if err != nil {
+				log.Println("🆘 Dumping the safe list to stderr instead:")
+				utils.PrintSafeList(k8sSafeList, os.Stderr)
 				log.Fatalf("❌ Failed to write manifest to file: %v", err)
 			}
 			log.Printf("📝 Manifest successfully written to: %s", cfg.K8s.ManifestFile)
 
 		case "clean":
 			log.Println("--- K8s Stage: CLEAN ---")
-			safeImageSet, contextMap, err := utils.ReadManifestFromCSV(cfg.K8s.ManifestFile)
+			safeImageSet, contextMap, err := utils.ReadManifestFromCSV(*manifestFiles...)
 			if err != nil {
 				log.Fatalf("❌ Failed to read manifest file: %v", err)
 			}
-			log.Printf("✅ Successfully loaded %d images from the manifest file.", len(safeImageSet))
+			log.Printf("✅ Successfully loaded %d images from %d manifest file(s).", len(safeImageSet), len(*manifestFiles))
+			if len(safeImageSet) == 0 {
+				switch {
+				case *force:
+					log.Println("⚠️  The safe list is empty; every tagged artifact of the selected repositories will be deleted (--force).")
+				case cfg.DryRun:
+					log.Println("⚠️  The safe list is empty; every tagged artifact is reported for deletion. A real run would refuse to clean without --force.")
+				default:
+					log.Fatalf("❌ The safe list is empty, which would delete every tagged artifact. Check the manifest file(s) and the scan, or pass --force if this is intended.")
+				}
+			}
 
-			client, err := harbor.NewHarborClient(cfg.Harbor.URL, cfg.Harbor.User, cfg.Harbor.Password, cfg.Harbor.PageSize)
+			client, harborInfo = newHarborClient(&cfg)
+			projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+			checkpoint, err = cleaner.OpenCheckpoint(cfg.Harbor.CheckpointFile, &cfg, *resume)
 			if err != nil {
-				log.Fatalf("❌ Error initializing Harbor client: %v", err)
+				log.Fatalf("❌ Failed to open checkpoint: %v", err)
 			}
-			projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
-			artifactsDeleted, auditData = cleaner.RunKubernetesStrategy(client, cfg.DryRun, safeImageSet, contextMap, projectWhitelist)
+			exportArtifactInventory(ctx, client, &cfg, timestamp)
+			auditFilePath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("cleanup-audit-%s.csv", timestamp))
+			journal := openAuditJournal(&cfg, auditFilePath)
+			result = cleaner.RunKubernetesStrategy(ctx, client, &cfg, safeImageSet, contextMap, projectWhitelist, checkpoint, journal)
+			checkpoint.Flush()
 
 			// Write the final audit report
-			auditFilePath := cfg.K8s.AuditFile
-			if auditFilePath == "" {
-				auditFilePath = fmt.Sprintf("cleanup-audit-%s.csv", timestamp)
-			}
-			err = utils.WriteAuditReport(auditData, auditFilePath)
-			if err != nil {
-				log.Fatalf("❌ Failed to write audit report: %v", err)
-			}
-			log.Printf("📝 Final audit report successfully written to: %s", auditFilePath)
+			saveAuditReport(&result, &cfg, auditFilePath, journal, auditRun)
 
 		default:
 			log.Fatalf("❌ Invalid or missing '--k8s.stage'. Please specify 'scan' or 'clean' for the 'kubernetes' strategy.")
@@ -103,42 +285,595 @@ func main() {
 
 	case "harbor":
 		log.Println("--- Harbor Strategy --- ")
-		client, err := harbor.NewHarborClient(cfg.Harbor.URL, cfg.Harbor.User, cfg.Harbor.Password, cfg.Harbor.PageSize)
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		checkpoint, err = cleaner.OpenCheckpoint(cfg.Harbor.CheckpointFile, &cfg, *resume)
 		if err != nil {
-			log.Fatalf("❌ Error initializing Harbor client: %v", err)
+			log.Fatalf("❌ Failed to open checkpoint: %v", err)
 		}
+		exportArtifactInventory(ctx, client, &cfg, timestamp)
+		auditFilePath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("harbor-cleanup-audit-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		journal := openAuditJournal(&cfg, auditFilePath)
+		result = cleaner.RunHarborStrategy(ctx, client, &cfg, projectWhitelist, checkpoint, journal)
+		checkpoint.Flush()
+
+		// Write the final audit report
+		saveAuditReport(&result, &cfg, auditFilePath, journal, auditRun)
+
+	case "inventory":
+		log.Println("--- Inventory Strategy --- ")
+		inventory, err := utils.ReadInventory(cfg.Inventory.File)
+		if err != nil {
+			log.Fatalf("❌ Failed to read inventory file: %v", err)
+		}
+		log.Printf("✅ Successfully loaded %d images from the inventory file.", len(inventory))
+
+		client, harborInfo = newHarborClient(&cfg)
 		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
-		artifactsDeleted, auditData = cleaner.RunHarborStrategy(client, cfg.DryRun, cfg.Harbor.KeepLastN, cfg.Harbor.MaxSnapshots, projectWhitelist)
+		exportArtifactInventory(ctx, client, &cfg, timestamp)
+		auditFilePath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("inventory-cleanup-audit-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		journal := openAuditJournal(&cfg, auditFilePath)
+		result = cleaner.RunInventoryStrategy(ctx, client, &cfg, inventory, projectWhitelist, journal)
 
 		// Write the final audit report
-		auditFilePath := cfg.K8s.AuditFile // Reusing the k8s audit file flag for simplicity
-		if auditFilePath == "" {
-			auditFilePath = fmt.Sprintf("harbor-cleanup-audit-%s.csv", timestamp)
+		saveAuditReport(&result, &cfg, auditFilePath, journal, auditRun)
+
+	case "native-retention":
+		log.Println("--- Native Retention Strategy --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		if err := cleaner.EnsureNativeRetention(client, &cfg, projectWhitelist); err != nil {
+			log.Fatalf("❌ %v", err)
 		}
-		err = utils.WriteAuditReport(auditData, auditFilePath)
-		if err != nil {
-			log.Fatalf("❌ Failed to write audit report: %v", err)
+
+	case "duplicates":
+		log.Println("--- Duplicates Report --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		records := cleaner.FindDuplicateDigests(ctx, client, &cfg, projectWhitelist)
+
+		reportPath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("duplicates-report-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		if err := utils.WriteAuditReport(records, reportPath, false); err != nil {
+			log.Fatalf("❌ Failed to write duplicates report: %v", err)
+		}
+		log.Printf("📝 Duplicates report successfully written to: %s", reportPath)
+
+	case "age-report":
+		log.Println("--- Age Report --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		report := cleaner.BuildAgeReport(ctx, client, &cfg, projectWhitelist)
+
+		reportPath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("age-report-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		if err := utils.WriteAuditReport(report.Records(), reportPath, false); err != nil {
+			log.Fatalf("❌ Failed to write age report: %v", err)
+		}
+		log.Printf("📝 Age report successfully written to: %s", reportPath)
+		if cfg.MetricsFile != "" {
+			metricsPath := storage.Resolve(cfg.MetricsFile, "harbor-cleaner-metrics.prom")
+			if err := report.WriteAgeMetrics(metricsPath, cfg.Labels, cfg.RunID); err != nil {
+				log.Printf("⚠️  %v", err)
+			} else {
+				log.Printf("📈 Artifact age metrics written to: %s", metricsPath)
+			}
+		}
+
+	case "stale-report":
+		log.Println("--- Stale Report --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		records := cleaner.FindStaleArtifacts(ctx, client, &cfg, projectWhitelist)
+
+		reportPath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("stale-report-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		if err := utils.WriteAuditReport(records, reportPath, false); err != nil {
+			log.Fatalf("❌ Failed to write stale report: %v", err)
+		}
+		log.Printf("📝 Stale report successfully written to: %s", reportPath)
+
+	case "orphaned-tags":
+		log.Println("--- Orphaned Tags Strategy --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		result = cleaner.RunOrphanedTagsStrategy(ctx, client, &cfg, projectWhitelist)
+
+		// Write the final audit report
+		auditFilePath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("orphaned-tags-audit-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		saveAuditReport(&result, &cfg, auditFilePath, nil, auditRun)
+
+	case "surplus-tags":
+		log.Println("--- Surplus Tags Strategy --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		result = cleaner.RunSurplusTagsStrategy(ctx, client, &cfg, projectWhitelist)
+
+		// Write the final audit report
+		auditFilePath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("surplus-tags-audit-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		saveAuditReport(&result, &cfg, auditFilePath, nil, auditRun)
+
+	case "webhook":
+		log.Println("--- Webhook Strategy --- ")
+		client, harborInfo = newHarborClient(&cfg)
+		projectWhitelist := utils.ParseWhitelist(cfg.Harbor.ProjectWhitelist)
+		auditFilePath := outputPath(cfg.K8s.AuditFile, fmt.Sprintf("webhook-cleanup-audit-%s.csv", timestamp)) // Reusing the k8s audit file flag for simplicity
+		log.Printf("📝 Audit records will be appended to: %s", auditFilePath)
+		if err := webhook.NewServer(client, &cfg, projectWhitelist, auditFilePath).Run(ctx); err != nil {
+			log.Fatalf("❌ Webhook server failed: %v", err)
 		}
-		log.Printf("📝 Final audit report successfully written to: %s", auditFilePath)
 
 	default:
 		log.Fatalf("❌ Unknown strategy '%s'.", cfg.Strategy)
 	}
 
 	// --- Final summary ---
-	if cfg.Strategy != "k8s" || cfg.K8s.Stage != "scan" {
-		log.Println("\n\n==================================================")
-		log.Println("📊 Cleanup Summary")
-		log.Println("==================================================")
-		// ... summary logic ...
-		log.Printf("  Artifacts Processed:  %d", len(auditData)-1) // -1 for header
-		actionWord := "Deleted"
-		if cfg.DryRun {
-			actionWord = "To Be Deleted"
+	// The webhook server treats a signal as its normal shutdown, not as an interruption.
+	interrupted := ctx.Err() != nil && cfg.Strategy != "webhook"
+	timeLimited := interrupted && sigCtx.Err() == nil
+	if result.Audit != nil {
+		printSummary(result, harborInfo, cleaner.DeletionsByPattern(result.Audit, cfg.ImpactPatterns), cfg.SlowestRepos, cfg.DryRun, interrupted, timeLimited)
+	}
+	if cfg.AlertThreshold > 0 && cfg.DryRun && result.Audit != nil {
+		checkAlertThreshold(&cfg, result, harborInfo)
+	}
+	// A partial run can't be held to the bounds of a complete one.
+	unexpected := false
+	if cfg.ExpectDeletions.Enabled() && cfg.DryRun && result.Audit != nil && !interrupted && !result.Limited {
+		unexpected = !checkExpectedDeletions(&cfg, result)
+	}
+	if cfg.MetricsFile != "" && len(result.Timings) > 0 {
+		metricsPath := storage.Resolve(cfg.MetricsFile, "harbor-cleaner-metrics.prom")
+		if err := cleaner.WriteDurationMetrics(result.Timings, metricsPath, cfg.Labels, cfg.RunID); err != nil {
+			log.Printf("⚠️  %v", err)
+		} else {
+			log.Printf("📈 Repository duration metrics written to: %s", metricsPath)
+		}
+	}
+
+	if len(cfg.PostRunCommand.Command) > 0 && result.Audit != nil && (!cfg.DryRun || cfg.PostRunCommand.OnDryRun) {
+		outcome := "complete"
+		switch {
+		case timeLimited:
+			outcome = "time-limit"
+		case interrupted:
+			outcome = "interrupted"
+		case len(result.Guarded) > 0:
+			outcome = "guarded"
+		case unexpected:
+			outcome = "unexpected-deletions"
+		case result.Limited:
+			outcome = "limited"
 		}
-		log.Printf("  Artifacts %-12s: %d", actionWord, artifactsDeleted)
-		log.Println("==================================================")
+		runPostRunCommand(&cfg, result, outcome)
 	}
 
+	if len(result.Guarded) > 0 {
+		log.Printf("\n❌ %d in-use repositories were not cleaned because none of their artifacts is in the safe list: %s",
+			len(result.Guarded), strings.Join(result.Guarded, ", "))
+		log.Println("   Check that the manifest's image references match the Harbor URL and tags.")
+	}
+	if timeLimited {
+		log.Println("\n⏰ Harbor Cleanup Script stopped early due to time limit.")
+		closeLog()
+		os.Exit(exitTimeLimit)
+	}
+	if interrupted {
+		log.Println("\n⛔ Harbor Cleanup Script Interrupted.")
+		closeLog()
+		os.Exit(exitInterrupted)
+	}
+	if len(result.Guarded) > 0 {
+		closeLog()
+		os.Exit(exitGuarded)
+	}
+	if unexpected {
+		closeLog()
+		os.Exit(exitUnexpectedDeletions)
+	}
+	if result.Limited {
+		// Keep the checkpoint for --resume and don't record an incomplete run for since: last.
+		log.Printf("\n✋ Harbor Cleanup Script stopped after %d repositories (--limit); the run is incomplete.", result.ReposProcessed)
+		return
+	}
+	if err := checkpoint.Remove(); err != nil {
+		log.Printf("⚠️  Could not remove checkpoint: %v", err)
+	}
+	if cfg.Strategy == "harbor" && !cfg.DryRun {
+		if err := utils.WriteLastRun(cfg.Harbor.LastRunFile, runStart); err != nil {
+			log.Printf("⚠️  Could not record last run time: %v", err)
+		}
+	}
 	log.Println("\n🎉 Harbor Cleanup Script Finished.")
 }
+
+// openLog opens the log file and returns a function that closes it. A log file in object
+// storage is written to a local temporary file and uploaded when it is closed; closing is
+// safe to repeat.
+func openLog(path string) (io.Writer, func(), error) {
+	if !storage.IsRemote(path) {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, func() { file.Close() }, nil
+	}
+
+	file, err := os.CreateTemp("", "harbor-cleaner-*.log")
+	if err != nil {
+		return nil, nil, err
+	}
+	var once sync.Once
+	return file, func() {
+		once.Do(func() {
+			file.Close()
+			data, err := os.ReadFile(file.Name())
+			if err == nil {
+				err = storage.WriteFile(path, data)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ Failed to upload log file to %s: %v\n", path, err)
+				return
+			}
+			os.Remove(file.Name())
+		})
+	}, nil
+}
+
+// outputPath returns the configured output file, or defaultName when none is configured
+// or the setting is an object storage prefix.
+func outputPath(configured, defaultName string) string {
+	if configured == "" {
+		return defaultName
+	}
+	return storage.Resolve(configured, defaultName)
+}
+
+// pruneRunLogs deletes the default-named log files next to logFileName that are older than
+// retentionDays. Failing to do so is only a warning.
+func pruneRunLogs(logFileName string, retentionDays int, now time.Time) {
+	if storage.IsRemote(logFileName) {
+		log.Println("⚠️  log.retention-days only applies to local log files; old remote logs are kept.")
+		return
+	}
+	deleted, err := utils.PruneRunLogs(filepath.Dir(logFileName), time.Duration(retentionDays)*24*time.Hour, now)
+	if err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	if len(deleted) > 0 {
+		log.Printf("🧹 Deleted %d log files older than %d days.", len(deleted), retentionDays)
+	}
+}
+
+// logBatch lists the repositories of the batch file, the only ones the run will touch, and
+// the keep-last of those that set one.
+func logBatch(path string, entries []config.BatchEntry) {
+	log.Printf("📋 Batch file %s lists %d repositories:", path, len(entries))
+	for _, e := range entries {
+		if e.Keep != nil {
+			log.Printf("    - %s (keep %d)", e.Name(), *e.Keep)
+		} else {
+			log.Printf("    - %s", e.Name())
+		}
+	}
+}
+
+// resolveSince turns the 'since' setting into a timestamp. "last" reads the previous
+// successful run from lastRunFile; if there is none yet, everything is processed.
+func resolveSince(value, lastRunFile string) (time.Time, error) {
+	if value != "last" {
+		return time.Parse(time.RFC3339, value)
+	}
+	t, err := utils.ReadLastRun(lastRunFile)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("⏱️  No previous run recorded in %s, processing all repositories.", lastRunFile)
+		return time.Time{}, nil
+	}
+	return t, err
+}
+
+// printManifestDiff prints how the safe list changed from oldPath to the new manifests and
+// warns when images dropped out of it, which often means the scan was incomplete.
+func printManifestDiff(oldPath string, newPaths []string) error {
+	diff, err := utils.DiffManifests(oldPath, newPaths...)
+	if err != nil {
+		return err
+	}
+	if err := utils.PrintManifestDiff(diff, os.Stdout); err != nil {
+		return err
+	}
+	log.Printf("🔍 Safe list changes: %d added, %d removed, %d with changed contexts.", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	if len(diff.Removed) > 0 {
+		log.Printf("⚠️  %d of %d previously safe images (%.1f%%) are no longer in the safe list. Make sure the scan was complete before running the clean stage.",
+			len(diff.Removed), diff.OldTotal, 100*float64(len(diff.Removed))/float64(diff.OldTotal))
+	}
+	return nil
+}
+
+// validateK8s reports what the scan stage would find in each environment and exits with
+// status 1 if any environment or namespace could not be read.
+func validateK8s(ctx context.Context, cfg *config.K8sConfig) {
+	results, err := k8s.ValidateK8sAccess(ctx, cfg)
+	printEnvBreakdown(results)
+	failed := err != nil
+	for _, r := range results {
+		failed = failed || len(r.Denied)+len(r.Errors) > 0
+	}
+	if failed {
+		log.Fatalf("❌ Kubernetes validation failed; the manifest was not written.")
+	}
+	log.Println("✅ All environments validated; the manifest was not written.")
+}
+
+// printEnvBreakdown logs what the scan found in each environment.
+func printEnvBreakdown(results []k8s.EnvScanResult) {
+	if len(results) == 0 {
+		return
+	}
+	log.Println("📊 Environment Summary:")
+	for _, r := range results {
+		status := "✅"
+		if len(r.Denied)+len(r.Errors) > 0 {
+			status = "❌"
+		}
+		log.Printf("  %s %s: %d namespaces, %d workloads (%d skipped), %d images, %d denied, %d errors", status, r.Name, r.Namespaces, r.Workloads, r.Skipped, r.Images, len(r.Denied), len(r.Errors))
+	}
+}
+
+// checkAlertThreshold alerts when a dry run found more delete candidates than
+// alert-threshold, listing the repositories with the most candidates. info is the Harbor
+// instance of the run, nil if unknown.
+func checkAlertThreshold(cfg *config.Config, result cleaner.Result, info *harbor.SystemInfo) {
+	if result.Deleted <= cfg.AlertThreshold {
+		log.Printf("✅ %d delete candidates, within the alert threshold of %d.", result.Deleted, cfg.AlertThreshold)
+		return
+	}
+	alert := utils.Alert{
+		Text:       fmt.Sprintf("harbor-cleaner dry run (%s strategy) found %d delete candidates, above the alert threshold of %d.", cfg.Strategy, result.Deleted, cfg.AlertThreshold),
+		Strategy:   cfg.Strategy,
+		RunID:      cfg.RunID,
+		Candidates: result.Deleted,
+		Threshold:  cfg.AlertThreshold,
+		Labels:     cfg.Labels,
+	}
+	if info != nil {
+		alert.HarborVersion = info.HarborVersion
+	}
+	for i, g := range cleaner.DeletionsByRepository(result.Audit) {
+		if i == 10 {
+			break
+		}
+		alert.TopRepositories = append(alert.TopRepositories, utils.AlertCount{Repository: g.Name, Candidates: g.Count})
+	}
+	log.Printf("🚨 %s", alert.Text)
+	if cfg.AlertWebhookURL == "" {
+		return
+	}
+	if err := utils.PostAlert(cfg.AlertWebhookURL, alert); err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	log.Println("📣 Alert sent to the alert webhook.")
+}
+
+// checkExpectedDeletions reports whether the deletions of a dry run are within
+// expect-deletions, logging the total and repositories that are not.
+func checkExpectedDeletions(cfg *config.Config, result cleaner.Result) bool {
+	problems := cleaner.UnexpectedDeletions(result, cfg.ExpectDeletions)
+	if len(problems) == 0 {
+		log.Printf("✅ %d delete candidates, within the expected deletions.", result.Deleted)
+		return true
+	}
+	log.Printf("\n❌ Deletions outside the expected bounds (expect-deletions):")
+	for _, p := range problems {
+		log.Printf("   - %s", p)
+	}
+	return false
+}
+
+// findOwnImages records the cleaner's own images in cfg so they are never deleted. Not
+// finding them is only a warning, as the cleaner often runs outside the registry it cleans.
+func findOwnImages(ctx context.Context, cfg *config.Config) {
+	images, err := k8s.OwnImages(ctx)
+	if err != nil {
+		log.Printf("⚠️  Could not determine the cleaner's own image, so it is not protected specially: %v", err)
+		return
+	}
+	if len(images) > 0 {
+		log.Printf("🛡️  Protecting the cleaner's own image: %s", strings.Join(images, ", "))
+	}
+	cfg.Harbor.OwnImages = images
+}
+
+// describeHarbor summarizes the Harbor version and storage usage in one line.
+func describeHarbor(info *harbor.SystemInfo) string {
+	desc := "Harbor " + info.HarborVersion
+	for _, volume := range info.Storage {
+		desc += fmt.Sprintf(", storage %s free of %s", utils.FormatBytes(int64(volume.Free)), utils.FormatBytes(int64(volume.Total)))
+	}
+	return desc
+}
+
+// newHarborClient creates the Harbor client of the run, with the adaptive rate limiter if
+// enabled, and aborts the run if the client can't be created. It also returns the Harbor
+// system info, or nil if it couldn't be read.
+func newHarborClient(cfg *config.Config) (*harbor.HarborClient, *harbor.SystemInfo) {
+	client, err := harbor.NewHarborClient(cfg.Harbor.URL, cfg.Harbor.User, cfg.Harbor.Password, cfg.Harbor.PageSize, cfg.Harbor.Timeout())
+	if err != nil {
+		log.Fatalf("❌ Error initializing Harbor client: %v", err)
+	}
+	if err := client.Ping(); err != nil {
+		log.Fatalf("❌ Harbor at %s is not reachable: %v", redact.URL(cfg.Harbor.URL), err)
+	}
+	var info *harbor.SystemInfo
+	if system, err := client.GetSystemInfo(); err != nil {
+		log.Printf("⚠️  Could not read Harbor system info: %v", err)
+	} else {
+		info = &system
+		log.Printf("🏷️  Connected to %s", describeHarbor(info))
+	}
+	client.LimitConcurrentDeletes(cfg.Harbor.MaxConcurrentDeletes)
+	if rate := cfg.Harbor.AdaptiveRate; rate.Enabled {
+		client.Limiter = harbor.NewAdaptiveLimiter(rate.Min, rate.Max)
+		client.Retries = rate.Retries
+		log.Printf("🚦 Adaptive rate limit enabled: starting at %.1f requests/s (minimum %.1f).", client.Limiter.Rate(), rate.Min)
+	}
+	if guard := cfg.Harbor.LatencyGuard; guard.Enabled {
+		pause := time.Duration(guard.PauseSeconds) * time.Second
+		client.Latency = harbor.NewLatencyGuard(guard.Window, guard.Factor, pause)
+		log.Printf("🐌 Latency guard enabled: deletions pause for %s when the average of %d responses exceeds %.1fx the baseline.", pause, guard.Window, guard.Factor)
+	}
+	return client, info
+}
+
+// reportWriteAttempts is how often a report is written before giving up.
+const reportWriteAttempts = 3
+
+// retryWrite calls write until it succeeds, at most reportWriteAttempts times with a growing
+// pause in between, and returns the last error.
+func retryWrite(what string, write func() error) error {
+	var err error
+	for attempt := 1; attempt <= reportWriteAttempts; attempt++ {
+		if err = write(); err == nil || attempt == reportWriteAttempts {
+			break
+		}
+		delay := time.Duration(attempt) * time.Second
+		log.Printf("⚠️  Failed to write %s (attempt %d/%d), retrying in %s: %v", what, attempt, reportWriteAttempts, delay, err)
+		time.Sleep(delay)
+	}
+	return err
+}
+
+// openAuditJournal opens the journal for the audit report at path. The run goes on
+// without one if it cannot be created.
+func openAuditJournal(cfg *config.Config, path string) *cleaner.AuditJournal {
+	journal, err := cleaner.OpenAuditJournal(path, cfg.AuditColumns, cfg.RunID)
+	if err != nil {
+		log.Printf("⚠️  %v; continuing without a journal.", err)
+		return nil
+	}
+	return journal
+}
+
+// saveAuditReport writes the final audit report, and with audit-per-project the report of
+// each project, and removes the journal. If a report cannot be written, the records are
+// dumped to stderr as a last resort and the journal is kept. With audit-db set, the
+// records are also appended to the audit database.
+func saveAuditReport(result *cleaner.Result, cfg *config.Config, path string, journal *cleaner.AuditJournal, run utils.AuditRun) {
+	result.RunID = run.ID
+	records := result.AuditRecords(cfg.AuditColumns, cfg.AuditGroupByStatus)
+	if cfg.AuditPerProject != "only" {
+		writeAuditReport(records, path, cfg.K8s.AuditAppend, journal)
+		log.Printf("📝 Final audit report successfully written to: %s", path)
+	}
+	if cfg.AuditPerProject != "" {
+		projects, byProject := result.AuditRecordsByProject(cfg.AuditColumns, cfg.AuditGroupByStatus)
+		for _, project := range projects {
+			projectPath := storage.Sibling(path, fmt.Sprintf("audit-%s-%s.csv", project, run.Time.Format("20060102-150405")))
+			writeAuditReport(byProject[project], projectPath, cfg.K8s.AuditAppend, journal)
+			log.Printf("📝 Audit report of project %s written to: %s (%d records)", project, projectPath, len(byProject[project])-1)
+		}
+	}
+	journal.Remove()
+
+	if cfg.AuditDB == "" {
+		return
+	}
+	records = result.AuditRecords(utils.AuditDBColumns, false)
+	if err := retryWrite("audit database", func() error { return utils.AppendAuditDB(cfg.AuditDB, run, records) }); err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	log.Printf("🗄️  %d audit records appended to: %s", len(records)-1, cfg.AuditDB)
+}
+
+// writeAuditReport writes one audit report with retryWrite. If it fails, the records are
+// dumped to stderr, the journal is kept and the run exits.
+func writeAuditReport(records [][]string, path string, appendMode bool, journal *cleaner.AuditJournal) {
+	if err := retryWrite("audit report", func() error { return utils.WriteAuditReport(records, path, appendMode) }); err != nil {
+		log.Println("🆘 Dumping the audit records to stderr instead:")
+		csv.NewWriter(os.Stderr).WriteAll(records)
+		if journal != nil {
+			log.Printf("📒 The audit journal is kept at: %s", journal.Path())
+		}
+		log.Fatalf("❌ Failed to write audit report: %v", err)
+	}
+}
+
+// runPostRunCommand runs post-run-command with the run summary in HARBOR_CLEANER_*
+// environment variables. A failure is logged and doesn't change the exit code.
+func runPostRunCommand(cfg *config.Config, result cleaner.Result, outcome string) {
+	env := []string{
+		"HARBOR_CLEANER_RUN_ID=" + cfg.RunID,
+		"HARBOR_CLEANER_STRATEGY=" + cfg.Strategy,
+		"HARBOR_CLEANER_DRY_RUN=" + strconv.FormatBool(cfg.DryRun),
+		"HARBOR_CLEANER_OUTCOME=" + outcome,
+		"HARBOR_CLEANER_DELETED=" + strconv.Itoa(result.Deleted),
+		"HARBOR_CLEANER_FAILED=" + strconv.Itoa(result.Failed),
+		"HARBOR_CLEANER_KEPT=" + strconv.Itoa(result.Kept),
+		"HARBOR_CLEANER_RECLAIMED_BYTES=" + strconv.FormatInt(result.Reclaimed(), 10),
+		"HARBOR_CLEANER_REPOS_PROCESSED=" + strconv.Itoa(result.ReposProcessed),
+		"HARBOR_CLEANER_REPOS_TOTAL=" + strconv.Itoa(result.ReposTotal),
+	}
+	log.Printf("🪝 Running post-run command %s", cfg.PostRunCommand.Command[0])
+	timeout := time.Duration(cfg.PostRunCommand.TimeoutSeconds) * time.Second
+	if err := utils.RunPostRunCommand(cfg.PostRunCommand.Command, env, timeout); err != nil {
+		log.Printf("⚠️  %v", err)
+		return
+	}
+	log.Println("✅ Post-run command finished.")
+}
+
+// exportArtifactInventory writes the inventory-export-file snapshot, if configured, and aborts
+// the run if it can't, so no destructive run starts without its recovery record.
+func exportArtifactInventory(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, timestamp string) {
+	if cfg.InventoryExportFile == "" {
+		return
+	}
+	path := storage.Resolve(cfg.InventoryExportFile, fmt.Sprintf("artifact-inventory-%s.csv", timestamp))
+	if err := cleaner.ExportArtifactInventory(ctx, client, cfg, path); err != nil {
+		log.Fatalf("❌ Failed to export the artifact inventory: %v", err)
+	}
+}
+
+// printSummary logs the final (or, when stopped early, partial) cleanup summary.
+// Deletions are broken down by tag category so it is easy to spot rules hitting the wrong tags,
+// and the slowest repositories and projects are listed to show where the time went. info
+// names the Harbor instance cleaned, if known.
+func printSummary(result cleaner.Result, info *harbor.SystemInfo, impact []cleaner.ImpactGroup, slowestN int, dryRun, interrupted, timeLimited bool) {
+	log.Println("\n\n==================================================")
+	switch {
+	case timeLimited:
+		log.Printf("📊 Cleanup Summary (PARTIAL - stopped early due to time limit, processed %d/%d repos)", result.ReposProcessed, result.ReposTotal)
+	case interrupted:
+		log.Printf("📊 Cleanup Summary (PARTIAL - run was interrupted, processed %d/%d repos)", result.ReposProcessed, result.ReposTotal)
+	case result.Limited:
+		log.Printf("📊 Cleanup Summary (PARTIAL - limited by --limit, processed %d/%d repos)", result.ReposProcessed, result.ReposTotal)
+	default:
+		log.Println("📊 Cleanup Summary")
+	}
+	log.Println("==================================================")
+	if info != nil {
+		log.Printf("  Target:                 %s", describeHarbor(info))
+	}
+	log.Printf("  Repositories Processed: %d/%d", result.ReposProcessed, result.ReposTotal)
+	log.Printf("  Artifacts Processed:    %d", result.Processed())
+	actionWord := "Deleted"
+	if dryRun {
+		actionWord = "To Be Deleted"
+	}
+	log.Printf("    %-20s: %d", actionWord, result.Deleted)
+	for _, g := range impact {
+		log.Printf("      %-18s: %d", g.Name, g.Count)
+	}
+	log.Printf("    %-20s: %d", "Failed", result.Failed)
+	log.Printf("    %-20s: %d", "Kept", result.Kept)
+	if slowestN > 0 && len(result.Timings) > 0 {
+		log.Println("  🐢 Slowest Repositories:")
+		for _, t := range result.SlowestRepos(slowestN) {
+			log.Printf("    %-40s %s", t.Repo, t.Duration.Round(time.Millisecond))
+		}
+		log.Println("  🐢 Slowest Projects:")
+		for _, t := range result.SlowestProjects(slowestN) {
+			log.Printf("    %-40s %s", t.Project, t.Duration.Round(time.Millisecond))
+		}
+	}
+	log.Println("==================================================")
+}
@@ -0,0 +1,199 @@
+// File: age_report.go
+package cleaner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/storage"
+	"harbor-cleaner/internal/utils"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AgeHistogram counts the artifacts of a project or repository and their size per age bucket.
+type AgeHistogram struct {
+	Counts []int
+	Sizes  []int64
+	SumAge float64 // Days, for the Prometheus histogram
+}
+
+func newAgeHistogram(buckets int) *AgeHistogram {
+	return &AgeHistogram{Counts: make([]int, buckets), Sizes: make([]int64, buckets)}
+}
+
+func (h *AgeHistogram) add(bucket int, size int64, ageDays float64) {
+	h.Counts[bucket]++
+	h.Sizes[bucket] += size
+	h.SumAge += ageDays
+}
+
+// Total returns the number of artifacts and their size across all buckets.
+func (h *AgeHistogram) Total() (int, int64) {
+	var count int
+	var size int64
+	for i := range h.Counts {
+		count += h.Counts[i]
+		size += h.Sizes[i]
+	}
+	return count, size
+}
+
+// AgeReport is the result of the age-report strategy. Histograms are kept per project and
+// per repository, in the order they were processed.
+type AgeReport struct {
+	BucketsDays []int
+	Projects    []string
+	Repos       []string
+	ByProject   map[string]*AgeHistogram
+	ByRepo      map[string]*AgeHistogram
+}
+
+// BucketNames returns the labels of the buckets, e.g. "<1d", "1-7d" and ">90d".
+func (r *AgeReport) BucketNames() []string {
+	names := make([]string, 0, len(r.BucketsDays)+1)
+	for i, days := range r.BucketsDays {
+		if i == 0 {
+			names = append(names, fmt.Sprintf("<%dd", days))
+		} else {
+			names = append(names, fmt.Sprintf("%d-%dd", r.BucketsDays[i-1], days))
+		}
+	}
+	return append(names, fmt.Sprintf(">%dd", r.BucketsDays[len(r.BucketsDays)-1]))
+}
+
+// bucket returns the index of the bucket for an age in days.
+func (r *AgeReport) bucket(ageDays float64) int {
+	for i, days := range r.BucketsDays {
+		if ageDays < float64(days) {
+			return i
+		}
+	}
+	return len(r.BucketsDays)
+}
+
+// BuildAgeReport buckets the age of every artifact of the scanned projects, tagged or not,
+// by the time harbor.sort-key ranks them by, and logs a table per project. It only reads
+// from Harbor. If ctx is cancelled the report covers the repositories finished so far.
+func BuildAgeReport(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}) *AgeReport {
+	report := &AgeReport{
+		BucketsDays: cfg.AgeReport.BucketsDays,
+		ByProject:   make(map[string]*AgeHistogram),
+		ByRepo:      make(map[string]*AgeHistogram),
+	}
+	buckets := len(report.BucketsDays) + 1
+	now := time.Now()
+
+	log.Printf("⚪️ Bucketing artifact ages by %s.", cfg.Harbor.SortKey)
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	for _, project := range projects {
+		log.Printf("  ▶️  Processing Project: %s", project.Name)
+		projectHist := newAgeHistogram(buckets)
+		for repo := range streamRepositories(client, project.Name) {
+			if !repoSelected(cfg.Harbor.OnlyReposMatching, repo.Name) {
+				continue
+			}
+			if stopped(ctx) {
+				report.addProject(project.Name, projectHist)
+				return report
+			}
+			repoHist := newAgeHistogram(buckets)
+			err := client.EachArtifactPage(project.Name, repo.Name, func(artifacts []harbor.Artifact) error {
+				for _, art := range artifacts {
+					t := art.PushTime
+					if cfg.Harbor.SortKey == "create_time" {
+						t = art.CreateTime()
+					}
+					ageDays := now.Sub(t).Hours() / 24
+					b := report.bucket(ageDays)
+					repoHist.add(b, art.Size, ageDays)
+					projectHist.add(b, art.Size, ageDays)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("    ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+				continue
+			}
+			report.Repos = append(report.Repos, repo.Name)
+			report.ByRepo[repo.Name] = repoHist
+		}
+		report.addProject(project.Name, projectHist)
+	}
+	return report
+}
+
+// addProject records a project's histogram and logs its table.
+func (r *AgeReport) addProject(name string, h *AgeHistogram) {
+	r.Projects = append(r.Projects, name)
+	r.ByProject[name] = h
+	count, size := h.Total()
+	log.Printf("    📅 Ages in project %s: %d artifacts, %s", name, count, utils.FormatBytes(size))
+	log.Printf("        %-10s %10s %12s", "Age", "Artifacts", "Size")
+	for i, bucket := range r.BucketNames() {
+		log.Printf("        %-10s %10d %12s", bucket, h.Counts[i], utils.FormatBytes(h.Sizes[i]))
+	}
+}
+
+// Records returns the report as CSV records, the first being the header: a row per
+// repository and bucket, followed by the project totals with "*" as the repository.
+func (r *AgeReport) Records() [][]string {
+	records := [][]string{{"Project", "Repository", "Age", "Artifacts", "Size Bytes"}}
+	rows := func(project, repo string, h *AgeHistogram) {
+		for i, bucket := range r.BucketNames() {
+			records = append(records, []string{project, repo, bucket, strconv.Itoa(h.Counts[i]), strconv.FormatInt(h.Sizes[i], 10)})
+		}
+	}
+	for _, repo := range r.Repos {
+		project, _, _ := strings.Cut(repo, "/")
+		rows(project, repo, r.ByRepo[repo])
+	}
+	for _, project := range r.Projects {
+		rows(project, "*", r.ByProject[project])
+	}
+	return records
+}
+
+// WriteAgeMetrics writes the project histograms in the Prometheus text exposition format:
+// harbor_cleaner_artifact_age_days, a histogram of artifact ages with the bucket bounds in
+// days, and harbor_cleaner_artifact_age_bytes, the size per bucket. Series carry the project
+// and the given extra labels, like WriteDurationMetrics.
+func (r *AgeReport) WriteAgeMetrics(path string, labels map[string]string, runID string) error {
+	extra := metricLabels(labels)
+	names := r.BucketNames()
+	var buf bytes.Buffer
+	const ages = "harbor_cleaner_artifact_age_days"
+	fmt.Fprintf(&buf, "# HELP %s Age of the artifacts in Harbor.\n", ages)
+	fmt.Fprintf(&buf, "# TYPE %s histogram\n", ages)
+	for _, project := range r.Projects {
+		h := r.ByProject[project]
+		cumulative := 0
+		for i, days := range r.BucketsDays {
+			cumulative += h.Counts[i]
+			fmt.Fprintf(&buf, "%s_bucket{project=%q%s,le=\"%d\"} %d\n", ages, project, extra, days, cumulative)
+		}
+		count, _ := h.Total()
+		fmt.Fprintf(&buf, "%s_bucket{project=%q%s,le=\"+Inf\"} %d\n", ages, project, extra, count)
+		fmt.Fprintf(&buf, "%s_sum{project=%q%s} %g\n", ages, project, extra, h.SumAge)
+		fmt.Fprintf(&buf, "%s_count{project=%q%s} %d\n", ages, project, extra, count)
+	}
+	const sizes = "harbor_cleaner_artifact_age_bytes"
+	fmt.Fprintf(&buf, "# HELP %s Size of the artifacts in Harbor per age bucket.\n", sizes)
+	fmt.Fprintf(&buf, "# TYPE %s gauge\n", sizes)
+	for _, project := range r.Projects {
+		for i, bucket := range names {
+			fmt.Fprintf(&buf, "%s{project=%q%s,age=%q} %d\n", sizes, project, extra, bucket, r.ByProject[project].Sizes[i])
+		}
+	}
+	fmt.Fprintln(&buf, "# HELP harbor_cleaner_run_info The run that wrote these metrics.")
+	fmt.Fprintln(&buf, "# TYPE harbor_cleaner_run_info gauge")
+	fmt.Fprintf(&buf, "harbor_cleaner_run_info{run_id=%q%s} 1\n", runID, extra)
+	if err := storage.WriteFile(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write metrics file %s: %w", path, err)
+	}
+	return nil
+}
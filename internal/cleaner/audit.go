@@ -0,0 +1,194 @@
+// File: audit.go
+package cleaner
+
+import (
+	"harbor-cleaner/internal/harbor"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditDetail keeps the artifact data behind an audit record that the strategies' own
+// columns leave out, so AuditRecords can produce any column.
+type auditDetail struct {
+	digest   string
+	tags     []string
+	size     int64
+	pushTime time.Time
+	pullTime time.Time
+}
+
+func newAuditDetail(art harbor.Artifact) auditDetail {
+	d := auditDetail{digest: art.Digest, size: art.Size, pushTime: art.PushTime, pullTime: art.PullTime}
+	for _, t := range art.Tags {
+		d.tags = append(d.tags, t.Name)
+	}
+	return d
+}
+
+// AuditRecords returns the audit report with the given columns (from config.AuditColumnNames)
+// in the given order, so every strategy can produce the same schema. With no columns it
+// returns the strategy's own columns. Records are sorted by sortAudit first, grouped by
+// status if groupByStatus is set.
+func (r *Result) AuditRecords(columns []string, groupByStatus bool) [][]string {
+	r.sortAudit(groupByStatus)
+	if len(columns) == 0 || len(r.Audit) == 0 {
+		return r.Audit
+	}
+	header := r.Audit[0]
+	records := [][]string{columns}
+	for i, row := range r.Audit[1:] {
+		var detail auditDetail
+		if i < len(r.details) {
+			detail = r.details[i]
+		}
+		records = append(records, auditRecord(columns, header, row, detail, r.RunID))
+	}
+	return records
+}
+
+// AuditRecordsByProject returns the records of AuditRecords split by the project of their
+// image, each with the header row, and the projects in the order they first appear.
+func (r *Result) AuditRecordsByProject(columns []string, groupByStatus bool) ([]string, map[string][][]string) {
+	records := r.AuditRecords(columns, groupByStatus)
+	byProject := make(map[string][][]string)
+	var projects []string
+	for i := 1; i < len(records); i++ {
+		project, _, _ := strings.Cut(auditRepoName(r.Audit[i][0]), "/")
+		if _, ok := byProject[project]; !ok {
+			projects = append(projects, project)
+			byProject[project] = [][]string{records[0]}
+		}
+		byProject[project] = append(byProject[project], records[i])
+	}
+	return projects, byProject
+}
+
+// auditRecord builds the record for one audit row with the given columns, where header
+// holds the strategy's own column names.
+func auditRecord(columns, header, row []string, detail auditDetail, runID string) []string {
+	columnIndex := func(name string) int {
+		for i, h := range header {
+			if h == name {
+				return i
+			}
+		}
+		return -1
+	}
+	record := make([]string, len(columns))
+	for j, column := range columns {
+		switch column {
+		case "image":
+			record[j] = row[0]
+		case "status":
+			record[j] = row[1]
+		case "notes":
+			record[j] = row[len(row)-1]
+		case "tags":
+			record[j] = strings.Join(detail.tags, ",")
+		case "digest":
+			record[j] = detail.digest
+		case "size":
+			record[j] = strconv.FormatInt(detail.size, 10)
+		case "pushTime":
+			record[j] = formatAuditTime(detail.pushTime)
+		case "pullTime":
+			record[j] = formatAuditTime(detail.pullTime)
+		case "envs":
+			record[j] = auditCell(row, columnIndex("Used In Environments"))
+		case "namespaces":
+			record[j] = auditCell(row, columnIndex("Used In Namespaces"))
+		case "runId":
+			record[j] = runID
+		}
+	}
+	return record
+}
+
+// auditCell returns row[i], or "" if the strategy has no such column.
+func auditCell(row []string, i int) string {
+	if i < 0 || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+func formatAuditTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sortAudit orders the audit records by repository (and so by project), then newest push
+// first, then tag, so runs over the same registry state write identical reports no matter
+// in which order the repositories were processed. With groupByStatus the records are
+// grouped by status instead, deletions first, then failures, then everything kept, and
+// ordered by image within each status.
+func (r *Result) sortAudit(groupByStatus bool) {
+	if len(r.Audit) < 2 || len(r.details) != len(r.Audit)-1 {
+		return
+	}
+	rows, details := r.Audit[1:], r.details
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if groupByStatus {
+			statusI, statusJ := rows[i][1], rows[j][1]
+			if rankI, rankJ := statusRank(statusI), statusRank(statusJ); rankI != rankJ {
+				return rankI < rankJ
+			}
+			if statusI != statusJ {
+				return statusI < statusJ
+			}
+			if rows[i][0] != rows[j][0] {
+				return rows[i][0] < rows[j][0]
+			}
+		}
+		repoI, tagI := splitImage(rows[i][0])
+		repoJ, tagJ := splitImage(rows[j][0])
+		if repoI != repoJ {
+			return repoI < repoJ
+		}
+		if !details[i].pushTime.Equal(details[j].pushTime) {
+			return details[i].pushTime.After(details[j].pushTime)
+		}
+		return tagI < tagJ
+	})
+
+	sortedRows := make([][]string, len(rows))
+	sortedDetails := make([]auditDetail, len(rows))
+	for k, i := range order {
+		sortedRows[k], sortedDetails[k] = rows[i], details[i]
+	}
+	copy(rows, sortedRows)
+	copy(details, sortedDetails)
+}
+
+// statusRank orders audit statuses for grouping: deletions, then failures, then the rest.
+func statusRank(status string) int {
+	switch status {
+	case "DELETED", "TO BE DELETED":
+		return 0
+	case "DELETE_FAILED", "QUARANTINE_FAILED":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// splitImage splits an audit image reference into its repository and tag.
+func splitImage(image string) (string, string) {
+	if repo, _, ok := strings.Cut(image, "@"); ok {
+		return repo, ""
+	}
+	tag := imageTag(image)
+	if tag == "" {
+		return image, ""
+	}
+	return image[:len(image)-len(tag)-1], tag
+}
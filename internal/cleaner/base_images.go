@@ -0,0 +1,312 @@
+// File: base_images.go
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"strings"
+	"sync"
+)
+
+// baseImages keeps the artifacts of base image repositories that artifacts kept by the run
+// are built on, for harbor.base-image-repos. Before cleaning starts, index reads the layers
+// of every artifact in the base image repositories in scope. Base image repositories are
+// cleaned after all others, by which time it is known which of those layers belong to
+// artifacts kept elsewhere. Artifacts kept in a base image repository are recorded too, so a
+// base image built on another is covered when its repository happens to be cleaned first.
+// Manifests are read once per digest, and kept artifacts are only read while some base
+// image is not yet known to be in use. It is safe for concurrent use.
+type baseImages struct {
+	client   *harbor.HarborClient
+	patterns []string
+	mu       sync.Mutex
+	// manifests caches the layers of each manifest digest read so far.
+	manifests map[string][]string
+	// indexed is set by index; until then kept artifacts are not recorded.
+	indexed bool
+	// wanted holds the layers of the indexed base images, and candidates their manifest
+	// digests. uncovered are the candidates whose layers are not all kept yet.
+	wanted     map[string]bool
+	candidates map[string]bool
+	uncovered  map[string][]string
+	layers     map[string]string // Wanted layer digest to the image of a kept artifact that has it
+	// incomplete is set when the layers of a kept artifact or of a base image could not be
+	// read, or a base image repository could not be listed, after which no base image is
+	// deleted.
+	incomplete bool
+}
+
+// newBaseImages returns nil when no base image repositories are configured.
+func newBaseImages(client *harbor.HarborClient, patterns []string) *baseImages {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return &baseImages{
+		client:     client,
+		patterns:   patterns,
+		manifests:  make(map[string][]string),
+		wanted:     make(map[string]bool),
+		candidates: make(map[string]bool),
+		uncovered:  make(map[string][]string),
+		layers:     make(map[string]string),
+	}
+}
+
+// isBase reports whether repoName is a base image repository.
+func (b *baseImages) isBase(repoName string) bool {
+	if b == nil {
+		return false
+	}
+	for _, pattern := range b.patterns {
+		if config.MatchWildcard(pattern, repoName) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayHoldBase reports whether a project may hold a base image repository, judged by the
+// project part of the patterns.
+func (b *baseImages) mayHoldBase(projectName string) bool {
+	for _, pattern := range b.patterns {
+		prefix, _, ok := strings.Cut(pattern, "/")
+		if !ok || config.MatchWildcard(prefix, projectName) {
+			return true
+		}
+	}
+	return false
+}
+
+// index reads the layers of the artifacts of the base image repositories among projects
+// that match onlyRepos. Without any, base image protection has nothing to do and no
+// manifest is read for the rest of the run.
+func (b *baseImages) index(ctx context.Context, projects []harbor.Project, onlyRepos []string) {
+	if b == nil {
+		return
+	}
+	log.Println("🧱 Indexing the layers of base images.")
+	repos := 0
+	for _, project := range projects {
+		if !b.mayHoldBase(project.Name) {
+			continue
+		}
+		err := b.client.EachRepositoryPage(project.Name, func(page []harbor.Repository) error {
+			for _, repo := range page {
+				if stopped(ctx) {
+					return errStopListing
+				}
+				if !b.isBase(repo.Name) || !repoSelected(onlyRepos, repo.Name) {
+					continue
+				}
+				repos++
+				b.indexRepository(project.Name, repo.Name)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errStopListing) {
+			log.Printf("    ⚠️  Could not list the repositories of project %s, no base image will be deleted: %v", project.Name, err)
+			b.incomplete = true
+		}
+	}
+	b.indexed = true
+	log.Printf("🧱 %d base image manifests in %d repositories.", len(b.candidates), repos)
+}
+
+// indexRepository adds the manifests of the artifacts of a base image repository to the
+// candidates.
+func (b *baseImages) indexRepository(projectName, repoName string) {
+	err := b.client.EachArtifactPage(projectName, repoName, func(artifacts []harbor.Artifact) error {
+		for _, art := range artifacts {
+			for _, digest := range manifestDigests(art) {
+				layers, err := b.manifestLayers(repoName, digest)
+				if err != nil {
+					log.Printf("    ⚠️  Could not read the layers of %s@%s, no base image will be deleted: %v", repoName, digest, err)
+					b.incomplete = true
+					continue
+				}
+				if len(layers) == 0 {
+					continue
+				}
+				b.candidates[digest] = true
+				b.uncovered[digest] = layers
+				for _, layer := range layers {
+					b.wanted[layer] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("    ⚠️  Could not list the artifacts of base image repository %s, no base image will be deleted: %v", repoName, err)
+		b.incomplete = true
+	}
+}
+
+// manifestLayers returns the layers of the manifest digest in repoName, read from the
+// registry once per digest.
+func (b *baseImages) manifestLayers(repoName, digest string) ([]string, error) {
+	b.mu.Lock()
+	layers, ok := b.manifests[digest]
+	b.mu.Unlock()
+	if ok {
+		return layers, nil
+	}
+	layers, err := b.client.ManifestLayers(repoName, digest)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.manifests[digest] = layers
+	b.mu.Unlock()
+	return layers, nil
+}
+
+// tracking reports whether kept artifacts still need to be read: index found base images
+// and some of them are not yet known to be in use.
+func (b *baseImages) tracking() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.indexed && len(b.uncovered) > 0
+}
+
+// keep records the layers of an artifact the run keeps, image being its name for audit
+// notes. The layers of an image index are those of its children. Nothing is read once every
+// base image is known to be in use, or when index found none.
+func (b *baseImages) keep(repoName string, art harbor.Artifact, image string) {
+	for _, digest := range manifestDigests(art) {
+		if !b.tracking() {
+			return
+		}
+		layers, err := b.manifestLayers(repoName, digest)
+		b.mu.Lock()
+		if err != nil {
+			log.Printf("        ⚠️  Could not read the layers of %s, no base image will be deleted: %v", image, err)
+			b.incomplete = true
+		}
+		added := false
+		for _, layer := range layers {
+			if _, ok := b.layers[layer]; !ok && b.wanted[layer] {
+				b.layers[layer] = image
+				added = true
+			}
+		}
+		if added {
+			for candidate, candidateLayers := range b.uncovered {
+				if b.coveredLocked(candidateLayers) {
+					delete(b.uncovered, candidate)
+				}
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// keepAll records the layers of artifacts a repository keeps without deciding on them, such
+// as untagged artifacts or those of other architectures.
+func (b *baseImages) keepAll(repoName string, artifacts []harbor.Artifact) {
+	for _, art := range artifacts {
+		if !b.tracking() {
+			return
+		}
+		b.keep(repoName, art, repoName+"@"+art.Digest)
+	}
+}
+
+// keepRepository records the layers of every artifact of a repository the run skips, being
+// unchanged since the last run or completed before --resume. Its artifacts are only listed
+// while some base image is not yet known to be in use.
+func (b *baseImages) keepRepository(projectName, repoName string) {
+	if !b.tracking() {
+		return
+	}
+	artifacts, err := b.client.ListArtifacts(projectName, repoName)
+	if err != nil {
+		b.unlisted(repoName, err)
+		return
+	}
+	b.keepAll(repoName, artifacts)
+}
+
+// unlisted notes that the artifacts of repoName could not be listed. As any of them may be
+// built on a base image, no base image is deleted.
+func (b *baseImages) unlisted(repoName string, err error) {
+	if !b.tracking() {
+		return
+	}
+	log.Printf("        ⚠️  Could not list the artifacts of %s, no base image will be deleted: %v", repoName, err)
+	b.mu.Lock()
+	b.incomplete = true
+	b.mu.Unlock()
+}
+
+// reason returns the audit note protecting art, an expired artifact, if it is in a base
+// image repository and every layer of it (or of one of its children) belongs to an artifact
+// the run keeps, or "" if it may be deleted. Artifacts whose layers can't be read, or that
+// were pushed after index ran, are protected; artifacts of other repositories never are.
+func (b *baseImages) reason(repoName string, art harbor.Artifact) string {
+	if !b.isBase(repoName) {
+		return ""
+	}
+	b.mu.Lock()
+	incomplete := b.incomplete
+	b.mu.Unlock()
+	if incomplete {
+		return "Skipped: protected (base image): not all layers of kept artifacts and base images could be read"
+	}
+	for _, digest := range manifestDigests(art) {
+		b.mu.Lock()
+		known := b.candidates[digest]
+		b.mu.Unlock()
+		layers, err := b.manifestLayers(repoName, digest)
+		if err != nil {
+			return "Skipped: protected (base image): could not read its layers: " + err.Error()
+		}
+		if !known && len(layers) > 0 {
+			return "Skipped: protected (base image): pushed after base images were indexed"
+		}
+		if image := b.usedBy(layers); image != "" {
+			return "Skipped: protected (base image): layers used by " + image
+		}
+	}
+	return ""
+}
+
+// usedBy returns the image of a kept artifact holding the last of layers if all of them
+// belong to kept artifacts, or "" if not or there are no layers.
+func (b *baseImages) usedBy(layers []string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(layers) == 0 || !b.coveredLocked(layers) {
+		return ""
+	}
+	return b.layers[layers[len(layers)-1]]
+}
+
+// coveredLocked reports whether all layers belong to kept artifacts. b.mu must be held.
+func (b *baseImages) coveredLocked(layers []string) bool {
+	for _, layer := range layers {
+		if _, ok := b.layers[layer]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// manifestDigests returns the digests of the manifests holding the layers of art: its
+// children for an image index, or its own digest.
+func manifestDigests(art harbor.Artifact) []string {
+	if len(art.References) == 0 {
+		return []string{art.Digest}
+	}
+	digests := make([]string, 0, len(art.References))
+	for _, ref := range art.References {
+		digests = append(digests, ref.ChildDigest)
+	}
+	return digests
+}
@@ -0,0 +1,119 @@
+package cleaner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+)
+
+// TestBaseImagesReadsManifestsOnce checks that base image protection reads each manifest
+// once, stops reading kept artifacts once every base image is known to be in use, and reads
+// nothing without a base image repository in scope.
+func TestBaseImagesReadsManifestsOnce(t *testing.T) {
+	base1 := taggedArtifact("sha256:b1", 48*time.Hour, "1")
+	base2 := taggedArtifact("sha256:b2", 24*time.Hour, "2")
+	app1 := taggedArtifact("sha256:a1", time.Hour, "1.0.0", "latest")
+	app2 := taggedArtifact("sha256:a2", 2*time.Hour, "0.9.0")
+	app3 := taggedArtifact("sha256:a3", 3*time.Hour, "0.8.0")
+	fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{
+		"app/base": {base1, base2},
+		"app/api":  {app1, app2, app3},
+	})
+	fake.layers["sha256:b1"] = []string{"l-os"}
+	fake.layers["sha256:b2"] = []string{"l-os", "l-jre"}
+	fake.layers["sha256:a1"] = []string{"l-os", "l-app1"}
+	fake.layers["sha256:a2"] = []string{"l-os", "l-jre", "l-app2"}
+	fake.layers["sha256:a3"] = []string{"l-os", "l-jre", "l-app3"}
+	projects := []harbor.Project{{Name: "app"}}
+
+	b := newBaseImages(client, []string{"app/base"})
+	b.index(context.Background(), projects, nil)
+	b.keep("app/api", app1, "app/api:1.0.0")
+	b.keep("app/api", app1, "app/api:latest")
+	b.keep("app/api", app2, "app/api:0.9.0")
+	b.keep("app/api", app3, "app/api:0.8.0")
+
+	for _, art := range []harbor.Artifact{base1, base2} {
+		if note := b.reason("app/base", art); !strings.HasPrefix(note, "Skipped: protected (base image): layers used by") {
+			t.Errorf("base image %s: note %q, want it protected", art.Digest, note)
+		}
+	}
+	want := map[string]int{"sha256:b1": 1, "sha256:b2": 1, "sha256:a1": 1, "sha256:a2": 1, "sha256:a3": 0}
+	for digest, n := range want {
+		if got := fake.manifestGetCount(digest); got != n {
+			t.Errorf("%d manifest requests for %s, want %d", got, digest, n)
+		}
+	}
+
+	// No base image repository in scope: nothing is read.
+	b = newBaseImages(client, []string{"base/*"})
+	b.index(context.Background(), projects, nil)
+	b.keep("app/api", app3, "app/api:0.8.0")
+	if got := fake.manifestGetCount("sha256:a3"); got != 0 {
+		t.Errorf("%d manifest requests for a kept artifact without base images in scope, want 0", got)
+	}
+}
+
+// TestBaseImagesKeptBySkippedRepositories checks that a base image is protected by the
+// artifacts of a repository the run skips, unchanged since the last run or completed before
+// --resume, and by untagged artifacts, which are never decided.
+func TestBaseImagesKeptBySkippedRepositories(t *testing.T) {
+	tests := []struct {
+		name  string
+		since bool
+		done  bool
+		api   harbor.Artifact
+	}{
+		{"since", true, false, taggedArtifact("sha256:a1", 72*time.Hour, "1.0.0")},
+		{"resume", false, true, taggedArtifact("sha256:a1", 72*time.Hour, "1.0.0")},
+		{"untagged", false, false, taggedArtifact("sha256:a1", 72*time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{
+				"app/base": {taggedArtifact("sha256:b2", 24*time.Hour, "2"), taggedArtifact("sha256:b1", 48*time.Hour, "1")},
+				"app/api":  {tt.api},
+			})
+			fake.layers["sha256:b1"] = []string{"l-os1"}
+			fake.layers["sha256:b2"] = []string{"l-os2"}
+			fake.layers["sha256:a1"] = []string{"l-os1", "l-app"}
+			cfg := &config.Config{Harbor: config.HarborConfig{
+				KeepLastN:      1,
+				SortKey:        "push_time",
+				BaseImageRepos: []string{"app/base"},
+			}}
+			if tt.since {
+				cfg.Harbor.SinceTime = time.Now().Add(-time.Hour)
+			}
+			run, err := newHarborRun(client, cfg)
+			if err != nil {
+				t.Fatalf("newHarborRun: %v", err)
+			}
+			if run.checkpoint, err = OpenCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), cfg, false); err != nil {
+				t.Fatalf("OpenCheckpoint: %v", err)
+			}
+			if tt.done {
+				run.checkpoint.completed["app/api"] = true
+			}
+			project := harbor.Project{Name: "app"}
+			run.base.index(context.Background(), []harbor.Project{project}, nil)
+			run.cleanRepository(context.Background(), project, harbor.Repository{Name: "app/api"}, &run.result)
+			cfg.Harbor.SinceTime = time.Time{}
+			run.cleanRepository(context.Background(), project, harbor.Repository{Name: "app/base"}, &run.result)
+
+			if got := fake.deleteCount("sha256:b1"); got != 0 {
+				t.Errorf("%d DELETE requests for a base image used by a skipped artifact, want 0", got)
+			}
+			for _, record := range run.result.Audit[1:] {
+				if strings.HasSuffix(record[0], "app/base:1") && record[1] != "SKIPPED" {
+					t.Errorf("base image %s is %s, want SKIPPED", record[0], record[1])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// File: checkpoint.go
+package cleaner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/storage"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The checkpoint is saved once this many repositories have been completed since the last
+// save, or this long after it, whichever comes first, and on Flush.
+const (
+	checkpointSaveEvery    = 20
+	checkpointSaveInterval = 30 * time.Second
+)
+
+// Checkpoint records the repositories a run has completed, so a run that dies partway can
+// be resumed without processing them again. A nil *Checkpoint disables checkpointing.
+// It is safe for concurrent use.
+type Checkpoint struct {
+	path       string
+	configHash string
+	mu         sync.Mutex
+	completed  map[string]bool
+	// pending counts the repositories completed since lastSave.
+	pending  int
+	lastSave time.Time
+}
+
+// checkpointFile is the on-disk form of a Checkpoint.
+type checkpointFile struct {
+	ConfigHash string   `json:"config_hash"`
+	Completed  []string `json:"completed"`
+}
+
+// OpenCheckpoint returns the checkpoint stored at path. With resume set, the repositories
+// completed by the previous run are loaded, unless the configuration has changed since;
+// otherwise the checkpoint starts empty.
+func OpenCheckpoint(path string, cfg *config.Config, resume bool) (*Checkpoint, error) {
+	// Settings that only control how the run is carried out don't invalidate the checkpoint.
+	// Secrets are left out, so the hash written next to the checkpoint reveals nothing and
+	// rotating a credential doesn't force a fresh start.
+	hashed := *cfg
+	hashed.MaxRunDuration, hashed.LogFile, hashed.LogLevel, hashed.LogFileFormat, hashed.RunID = 0, "", "", "", ""
+	hashed.LogRetentionDays, hashed.Harbor.RepoLimit = 0, 0
+	hashed.Harbor.Password, hashed.Webhook.Secret = "", ""
+	data, err := json.Marshal(hashed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash configuration: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	cp := &Checkpoint{path: path, configHash: hex.EncodeToString(sum[:]), completed: make(map[string]bool), lastSave: time.Now()}
+	if !resume {
+		return cp, nil
+	}
+
+	data, err = storage.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("⏯️  No checkpoint found in %s, starting from the beginning.", path)
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	if file.ConfigHash != cp.configHash {
+		log.Printf("⚠️  Configuration changed since checkpoint %s was written, starting from the beginning.", path)
+		return cp, nil
+	}
+	for _, name := range file.Completed {
+		cp.completed[name] = true
+	}
+	log.Printf("⏯️  Resuming from checkpoint: %d repositories already completed.", len(cp.completed))
+	return cp, nil
+}
+
+// Done reports whether the repository was completed before the run resumed.
+func (c *Checkpoint) Done(repoName string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[repoName]
+}
+
+// Complete marks the repository as completed. The checkpoint is saved every
+// checkpointSaveEvery repositories or checkpointSaveInterval, whichever comes first.
+func (c *Checkpoint) Complete(repoName string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[repoName] = true
+	c.pending++
+	if c.pending >= checkpointSaveEvery || time.Since(c.lastSave) >= checkpointSaveInterval {
+		c.save()
+	}
+}
+
+// Flush saves the repositories completed since the last save. Call it when the run stops,
+// however it stops, so --resume skips everything that was completed.
+func (c *Checkpoint) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending > 0 {
+		c.save()
+	}
+}
+
+// save writes the checkpoint. c.mu must be held.
+func (c *Checkpoint) save() {
+	c.pending, c.lastSave = 0, time.Now()
+	file := checkpointFile{ConfigHash: c.configHash}
+	for name := range c.completed {
+		file.Completed = append(file.Completed, name)
+	}
+	sort.Strings(file.Completed)
+	data, err := json.Marshal(file)
+	if err == nil {
+		err = storage.WriteFile(c.path, data)
+	}
+	if err != nil {
+		log.Printf("        ⚠️  Could not save checkpoint: %v", err)
+	}
+}
+
+// Remove deletes the checkpoint after a run has finished, so the next run starts fresh.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	if storage.IsRemote(c.path) {
+		// Objects cannot be deleted through the storage package, so store an empty checkpoint.
+		data, _ := json.Marshal(checkpointFile{ConfigHash: c.configHash})
+		return storage.WriteFile(c.path, data)
+	}
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint file: %w", err)
+	}
+	return nil
+}
@@ -2,29 +2,194 @@
 package cleaner
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"harbor-cleaner/internal/config"
 	"harbor-cleaner/internal/harbor"
 	"harbor-cleaner/internal/utils"
+	"iter"
 	"log"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 )
 
+// Result summarises a cleanup run. If the run was stopped early it covers only the
+// repositories processed so far.
+// Deleted, Failed and Kept always add up to the number of audit records.
+type Result struct {
+	Deleted        int           // Artifacts deleted, or that would be deleted in dry-run mode
+	Failed         int           // Artifacts whose deletion (or quarantine) failed
+	Kept           int           // Artifacts left in place for any reason
+	Audit          [][]string    // Audit records, starting with the header row
+	ReposProcessed int           // Repositories fully processed
+	ReposTotal     int           // Repositories in scope for the run
+	Timings        []RepoTiming  // Time spent on each processed repository
+	Guarded        []string      // In-use repositories left alone by k8s.strict
+	Limited        bool          // Stopped by --limit with repositories left
+	RunID          string        // For the runId audit column
+	details        []auditDetail // Artifact details of Audit[1:], for AuditRecords
+}
 
-// RunHarborStrategy implements the logic for cleaning artifacts based on retention rules.
-func RunHarborStrategy(client *harbor.HarborClient, dryRun bool, keepLastN, maxSnapshots int, projectWhitelist map[string]struct{}) (int, [][]string) {
-	var artifactsDeleted int
-	var auditRecords [][]string
+// Processed returns the number of artifacts with an audit record.
+func (r *Result) Processed() int {
+	return r.Deleted + r.Failed + r.Kept
+}
 
-	// Add CSV header for the audit report
-	auditRecords = append(auditRecords, []string{"Image", "Status", "Notes"})
+// merge adds the results of another run over different repositories to r.
+func (r *Result) merge(other Result) {
+	r.Deleted += other.Deleted
+	r.Failed += other.Failed
+	r.Kept += other.Kept
+	r.Audit = append(r.Audit, other.Audit...)
+	r.ReposProcessed += other.ReposProcessed
+	r.Timings = append(r.Timings, other.Timings...)
+	r.Guarded = append(r.Guarded, other.Guarded...)
+	r.details = append(r.details, other.details...)
+}
 
-	log.Println("⚪️ Starting cleanup based on Harbor retention strategy.")
-	projects, err := client.ListProjects()
+// joinNotes appends an optional extra note to an audit note.
+func joinNotes(notes, extra string) string {
+	if extra == "" {
+		return notes
+	}
+	return notes + "; " + extra
+}
+
+// limitReached reports whether limit repositories (0 for no limit) have been processed,
+// marking the result as limited if so. Call it only when another repository is due.
+func (r *Result) limitReached(limit int) bool {
+	if limit > 0 && r.ReposProcessed >= limit {
+		r.Limited = true
+		return true
+	}
+	return false
+}
+
+// pushedSince reports whether any of the artifacts was pushed after t.
+func pushedSince(artifacts []harbor.Artifact, t time.Time) bool {
+	for _, art := range artifacts {
+		if art.PushTime.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// count tallies an artifact's audit status into exactly one of the totals.
+func (r *Result) count(status string) {
+	switch status {
+	case "DELETED", "TO BE DELETED":
+		r.Deleted++
+	case "DELETE_FAILED", "QUARANTINE_FAILED":
+		r.Failed++
+	default:
+		r.Kept++
+	}
+}
+
+// globalGraceNote is the audit note of artifacts kept by grace-hours.
+const globalGraceNote = "Within global grace period"
+
+// globalGraceCutoff returns the push time after which grace-hours keeps every artifact, or
+// the zero time if grace-hours is not set.
+func globalGraceCutoff(cfg *config.Config) time.Time {
+	if cfg.GraceHours <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(cfg.GraceHours) * time.Hour)
+}
+
+// withinGlobalGrace reports whether art was pushed after cutoff, from globalGraceCutoff.
+func withinGlobalGrace(art harbor.Artifact, cutoff time.Time) bool {
+	return !cutoff.IsZero() && art.PushTime.After(cutoff)
+}
+
+// logDecision logs the retention decision for one artifact, unless per-artifact lines are
+// suppressed by log.level.
+func logDecision(cfg *config.Config, icon, status, image string) {
+	if !cfg.Quiet() {
+		log.Printf("        %s %s: %s", icon, status, image)
+	}
+}
+
+// deleteArtifact deletes an expired artifact and returns the audit status. Failures are
+// always logged; successes only when quiet is false.
+func deleteArtifact(client *harbor.HarborClient, projectName, repoName string, art harbor.Artifact, tagName string, quiet bool) string {
+	if err := client.DeleteArtifact(projectName, repoName, art.Digest); err != nil {
+		log.Printf("            ❌ FAILED to delete artifact %s: %v", tagName, err)
+		return "DELETE_FAILED"
+	}
+	if !quiet {
+		log.Printf("            ✅ Successfully deleted artifact %s.", tagName)
+	}
+	return "DELETED"
+}
+
+// stopped reports whether ctx was cancelled, logging why the run is stopping.
+func stopped(ctx context.Context) bool {
+	switch ctx.Err() {
+	case nil:
+		return false
+	case context.DeadlineExceeded:
+		log.Println("⏰ Run time limit reached, no further artifacts will be processed.")
+	default:
+		log.Println("⛔ Stop requested, no further artifacts will be processed.")
+	}
+	return true
+}
+
+// harborRun holds the state shared by all repositories processed in one harbor strategy run.
+type harborRun struct {
+	client  *harbor.HarborClient
+	cfg     *config.Config
+	policy  *retentionPolicy
+	q       *quarantine
+	protect *protection
+	base    *baseImages // Nil without harbor.base-image-repos
+	result  Result
+	// checkpoint records completed repositories; nil when checkpointing is disabled.
+	checkpoint *Checkpoint
+	// journal receives the audit records of each completed repository; nil when not journaling.
+	journal *AuditJournal
+}
+
+func newHarborRun(client *harbor.HarborClient, cfg *config.Config) (*harborRun, error) {
+	policy, err := newRetentionPolicy(&cfg.Harbor)
 	if err != nil {
+		return nil, err
+	}
+	return &harborRun{
+		client:  client,
+		cfg:     cfg,
+		policy:  policy,
+		q:       newQuarantine(client, cfg.Harbor.Quarantine, cfg.Quiet()),
+		protect: newProtection(client, &cfg.Harbor),
+		base:    newBaseImages(client, cfg.Harbor.BaseImageRepos),
+		// Add CSV header for the audit report
+		result: Result{Audit: [][]string{{"Image", "Status", "Notes"}}},
+	}, nil
+}
+
+// filterProjects lists all projects and drops those not in the whitelist or with fewer
+// than minRepos repositories. With continueOnError, the projects listed before a failed
+// page are still returned.
+func filterProjects(client *harbor.HarborClient, projectWhitelist map[string]struct{}, minRepos int, continueOnError bool) []harbor.Project {
+	projects, err := client.ListProjects()
+	var partial *harbor.PartialListError
+	switch {
+	case err == nil:
+	case continueOnError && errors.As(err, &partial):
+		log.Printf("⚠️  Project listing failed, continuing with the %d projects fetched before the failure: %v", len(projects), err)
+	case errors.As(err, &partial):
+		log.Fatalf("❌ Failed to list projects: %v (use --continue-on-error to process the %d projects fetched before the failure)", err, partial.Fetched)
+	default:
 		log.Fatalf("❌ Failed to list projects: %v", err)
 	}
 
+	var selected []harbor.Project
 	for _, project := range projects {
 		if projectWhitelist != nil {
 			if _, ok := projectWhitelist[project.Name]; !ok {
@@ -32,90 +197,309 @@ func RunHarborStrategy(client *harbor.HarborClient, dryRun bool, keepLastN, maxS
 				continue
 			}
 		}
+		if project.RepoCount < minRepos {
+			log.Printf("    ⏭️  Skipping project %s (%d repositories, below min-repos-per-project %d).", project.Name, project.RepoCount, minRepos)
+			continue
+		}
+		selected = append(selected, project)
+	}
+	return selected
+}
+
+// listRepositories lists a project's repositories, logging any failure. With continueOnError,
+// the repositories listed before a failed page are returned; otherwise ok is false and the
+// project should be skipped.
+func listRepositories(client *harbor.HarborClient, projectName string, continueOnError bool) (repos []harbor.Repository, ok bool) {
+	repos, err := client.ListRepositories(projectName)
+	if err == nil {
+		return repos, true
+	}
+	var partial *harbor.PartialListError
+	if continueOnError && errors.As(err, &partial) {
+		log.Printf("    ⚠️  Repository listing for project %s failed, continuing with the %d repositories fetched before the failure: %v", projectName, len(repos), err)
+		return repos, true
+	}
+	log.Printf("    ❌ Failed to list repositories for project %s: %v", projectName, err)
+	return nil, false
+}
+
+// errStopListing ends a streamed listing early when the caller stops iterating.
+var errStopListing = errors.New("listing stopped")
+
+// streamRepositories yields a project's repositories page by page as Harbor returns them,
+// so processing starts with the first page and only one page is held in memory. Because
+// earlier repositories may already have been processed, a listing that fails part way is
+// logged and ends the project whether or not continueOnError is set.
+func streamRepositories(client *harbor.HarborClient, projectName string) iter.Seq[harbor.Repository] {
+	return func(yield func(harbor.Repository) bool) {
+		err := client.EachRepositoryPage(projectName, func(page []harbor.Repository) error {
+			for _, repo := range page {
+				if !yield(repo) {
+					return errStopListing
+				}
+			}
+			return nil
+		})
+		var partial *harbor.PartialListError
+		switch {
+		case err == nil, errors.Is(err, errStopListing):
+		case errors.As(err, &partial):
+			log.Printf("    ⚠️  Repository listing for project %s failed after %d repositories, the rest of the project is skipped: %v", projectName, partial.Fetched, err)
+		default:
+			log.Printf("    ❌ Failed to list repositories for project %s: %v", projectName, err)
+		}
+	}
+}
+
+// RunHarborStrategy implements the logic for cleaning artifacts based on retention rules.
+// If ctx is cancelled the current artifact is finished and the partial results are returned.
+// Repositories recorded in checkpoint are skipped and completed ones are added to it.
+// The audit records of each repository are written to journal as soon as it is done.
+func RunHarborStrategy(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}, checkpoint *Checkpoint, journal *AuditJournal) Result {
+	run, err := newHarborRun(client, cfg)
+	if err != nil {
+		log.Fatalf("❌ Invalid retention settings: %v", err)
+	}
+	run.checkpoint = checkpoint
+	run.journal = journal
+	journal.start(run.result.Audit[0])
+	run.protect.indexDigests(ctx, client, cfg)
+
+	log.Println("⚪️ Starting cleanup based on Harbor retention strategy.")
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	projects = skipProtectedProjects(client, cfg, projects)
+	projects = skipNativeRetentionProjects(client, cfg, projects)
+	for _, project := range projects {
+		run.result.ReposTotal += project.RepoCount
+	}
+	if matched := reportRepoFilter(client, cfg, projects); matched >= 0 {
+		run.result.ReposTotal = matched
+	}
+	run.base.index(ctx, projects, cfg.Harbor.OnlyReposMatching)
+
+	if cfg.Harbor.RepoConcurrency > 1 {
+		run.cleanConcurrently(ctx, projects)
+		return run.result
+	}
 
+	var baseRepos []repoJob // Cleaned last, see baseImages
+	for _, project := range projects {
 		log.Printf("  ▶️  Processing Project: %s", project.Name)
-		repos, err := client.ListRepositories(project.Name)
-		if err != nil {
-			log.Printf("    ❌ Failed to list repositories for project %s: %v", project.Name, err)
-			continue
+		repos := streamRepositories(client, project.Name)
+		if cfg.Harbor.SizeTiers.Enabled {
+			// Size tiers rank every repository of the project before any is cleaned.
+			list, ok := listRepositories(client, project.Name, cfg.ContinueOnError)
+			if !ok {
+				continue
+			}
+			run.policy.rankRepoSizes(client, project, list)
+			repos = slices.Values(list)
 		}
 
-		for _, repo := range repos {
-			log.Printf("    ▶️  Processing Repository: %s", repo.Name)
-			artifacts, err := client.ListArtifacts(project.Name, repo.Name)
-			if err != nil {
-				log.Printf("        ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+		for repo := range repos {
+			if !repoSelected(cfg.Harbor.OnlyReposMatching, repo.Name) {
+				continue
+			}
+			if run.base.isBase(repo.Name) {
+				baseRepos = append(baseRepos, repoJob{project: project, repo: repo})
 				continue
 			}
+			if run.result.limitReached(cfg.Harbor.RepoLimit) {
+				return run.result
+			}
+			if !run.cleanRepository(ctx, project, repo, &run.result) {
+				return run.result
+			}
+		}
+	}
 
-			// Sort artifacts by push time, newest first.
-			sort.Slice(artifacts, func(i, j int) bool {
-				return artifacts[i].PushTime.After(artifacts[j].PushTime)
-			})
+	if len(baseRepos) > 0 {
+		log.Printf("  ▶️  Processing %d base image repositories.", len(baseRepos))
+	}
+	for _, job := range baseRepos {
+		if run.result.limitReached(cfg.Harbor.RepoLimit) {
+			return run.result
+		}
+		if !run.cleanRepository(ctx, job.project, job.repo, &run.result) {
+			return run.result
+		}
+	}
+	return run.result
+}
 
-			keptSnapshots := 0
-			for i, art := range artifacts {
-				if len(art.Tags) == 0 {
-					continue // Skip artifacts without tags
-				}
-				tagName := art.Tags[0].Name
-				fullImageName := client.BaseURL + "/" + repo.Name + ":" + tagName
-				isSnapshot := strings.Contains(strings.ToUpper(tagName), "SNAPSHOT")
-
-				keep := false
-				if i < keepLastN {
-					if isSnapshot {
-						if keptSnapshots < maxSnapshots {
-							keep = true
-							keptSnapshots++
-						}
-					} else {
-						keep = true
-					}
-				}
+// CleanRepository applies the harbor strategy's retention rules to a single repository,
+// e.g. in response to a push webhook. A repository not matching only-repos-matching is
+// left alone.
+func CleanRepository(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectName, repoName string) (Result, error) {
+	run, err := newHarborRun(client, cfg)
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid retention settings: %w", err)
+	}
+	if !repoSelected(cfg.Harbor.OnlyReposMatching, repoName) {
+		log.Printf("    ⏭️  Skipping repository %s (not matching only-repos-matching).", repoName)
+		return run.result, nil
+	}
+	project, err := client.GetProject(projectName)
+	if err != nil {
+		return Result{}, err
+	}
+	if reason := protectedByMetadata(cfg, project); reason != "" {
+		log.Printf("    ⏭️  Skipping repository %s (%s).", repoName, reason)
+		return run.result, nil
+	}
+	if !cfg.Harbor.ProcessNativeRetentionProjects {
+		if managed, err := nativeRetentionActive(client, project); err != nil {
+			log.Printf("    ⚠️  Could not check the retention policy of project %s, processing it: %v", projectName, err)
+		} else if managed {
+			log.Printf("    ⏭️  Skipping repository %s (Managed by native Harbor retention).", repoName)
+			return run.result, nil
+		}
+	}
+	if run.base.isBase(repoName) {
+		// Whether its artifacts are in use is only known at the end of a full run.
+		log.Printf("    ⏭️  Skipping repository %s (base image repository).", repoName)
+		return run.result, nil
+	}
+	run.result.ReposTotal = 1
+	run.cleanRepository(ctx, project, harbor.Repository{Name: repoName}, &run.result)
+	return run.result, nil
+}
 
-				var status, notes string
-				if keep {
-					status = "KEPT"
-					notes = fmt.Sprintf("Kept as part of the newest %d artifacts (snapshot count: %d/%d)", keepLastN, keptSnapshots, maxSnapshots)
-					log.Printf("        🟢 %s: %s", status, fullImageName)
-				} else {
-					status = "DELETED"
-					if dryRun {
-						status = "TO BE DELETED"
-					}
-					notes = "Expired artifact"
-					log.Printf("        🔴 %s: %s", status, fullImageName)
-
-					if !dryRun {
-						err := client.DeleteArtifact(project.Name, repo.Name, art.Digest)
-						if err != nil {
-							log.Printf("            ❌ FAILED to delete artifact %s: %v", tagName, err)
-							status = "DELETE_FAILED"
-						} else {
-							log.Printf("            ✅ Successfully deleted artifact %s.", tagName)
-							artifactsDeleted++
-						}
-					} else {
-						artifactsDeleted++
-					}
-				}
-				auditRecords = append(auditRecords, []string{fullImageName, status, notes})
-			}
+// cleanRepository applies the retention rules to one repository, recording the results in result.
+// It returns false if ctx was cancelled and no further repositories should be processed.
+func (run *harborRun) cleanRepository(ctx context.Context, project harbor.Project, repo harbor.Repository, result *Result) bool {
+	client, cfg, dryRun := run.client, run.cfg, run.cfg.DryRun
+
+	if run.checkpoint.Done(repo.Name) {
+		log.Printf("    ⏭️  Skipping repository %s (completed before resume).", repo.Name)
+		run.base.keepRepository(project.Name, repo.Name)
+		result.ReposProcessed++
+		return true
+	}
+	log.Printf("    ▶️  Processing Repository: %s", repo.Name)
+	defer result.timeRepo(project.Name, repo.Name, time.Now())
+	if !cfg.Harbor.SinceTime.IsZero() && !repo.UpdateTime.IsZero() && repo.UpdateTime.Before(cfg.Harbor.SinceTime) {
+		log.Printf("        ⏭️  Skipping repository %s (unchanged since last run).", repo.Name)
+		run.base.keepRepository(project.Name, repo.Name)
+		result.ReposProcessed++
+		run.checkpoint.Complete(repo.Name)
+		return true
+	}
+	retention, ruleSource := run.policy.forRepo(repo.Name)
+	artifacts, err := client.ListArtifacts(project.Name, repo.Name)
+	if err != nil {
+		log.Printf("        ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+		run.base.unlisted(repo.Name, err)
+		return true
+	}
+
+	// Sort artifacts by sort-key, newest first.
+	sort.Slice(artifacts, func(i, j int) bool {
+		return run.policy.sortTime(artifacts[i]).After(run.policy.sortTime(artifacts[j]))
+	})
+	if cfg.Harbor.RepoSettings {
+		ruleSource = retention.applyRepoSettings(repo, artifacts, ruleSource)
+	}
+	retention.logRules(ruleSource)
+
+	referenced := run.protect.referencedDigests(artifacts)
+
+	if !cfg.Harbor.SinceTime.IsZero() && !pushedSince(artifacts, cfg.Harbor.SinceTime) {
+		log.Printf("        ⏭️  Skipping repository %s (unchanged since last run).", repo.Name)
+		run.base.keepAll(repo.Name, artifacts)
+		result.ReposProcessed++
+		run.checkpoint.Complete(repo.Name)
+		return true
+	}
+	artifacts, others := retention.inScope(artifacts)
+	run.base.keepAll(repo.Name, others)
+	retention.observe(artifacts)
+
+	graceCutoff := globalGraceCutoff(cfg)
+	deletes := newRepoDeletes(project.Name, repo.Name, cfg, run.journal, result, run.protect.digests)
+	for i, art := range artifacts {
+		if stopped(ctx) {
+			deletes.flush(ctx, client, result, cfg.Harbor.DeleteConcurrency)
+			return false
+		}
+		// Untagged artifacts take their position in keep-last either way, but are only
+		// decided, and referred to by digest, with include-untagged-in-count.
+		tagName, ref := "", art.Digest
+		fullImageName := client.BaseURL + "/" + repo.Name + "@" + art.Digest
+		if len(art.Tags) > 0 {
+			tagName = art.Tags[0].Name
+			ref = tagName
+			fullImageName = client.BaseURL + "/" + repo.Name + ":" + tagName
+		} else if !cfg.Harbor.IncludeUntaggedInCount {
+			run.base.keep(repo.Name, art, fullImageName)
+			continue // Skip artifacts without tags
+		}
+		keep, reason := retention.decide(i, art, tagName)
+
+		var status, notes string
+		if keep {
+			status = "KEPT"
+			notes = reason
+			logDecision(cfg, "🟢", status, fullImageName)
+			run.q.release(project, repo.Name, art, dryRun)
+		} else if withinGlobalGrace(art, graceCutoff) {
+			status = "KEPT"
+			notes = globalGraceNote
+			logDecision(cfg, "🟢", status, fullImageName)
+			run.q.release(project, repo.Name, art, dryRun)
+		} else if protected := run.base.reason(repo.Name, art); protected != "" {
+			status = "SKIPPED"
+			notes = protected
+			logDecision(cfg, "🔒", status, fullImageName)
+			run.q.release(project, repo.Name, art, dryRun)
+		} else if protected, note := run.protect.reason(project, repo.Name, art, referenced); protected != "" {
+			status = "SKIPPED"
+			notes = protected
+			logDecision(cfg, "🔒", status, fullImageName)
+			run.q.release(project, repo.Name, art, dryRun)
+		} else {
+			status, notes = run.q.expire(project, repo.Name, art, ref, dryRun, joinNotes(reason, note))
+			logDecision(cfg, "🔴", status, fullImageName)
+		}
+		if status == "KEPT" || status == "SKIPPED" {
+			run.base.keep(repo.Name, art, fullImageName)
 		}
+		deletes.record(result, []string{fullImageName, status, notes}, art, ref)
+	}
+	deletes.flush(ctx, client, result, cfg.Harbor.DeleteConcurrency)
+	result.ReposProcessed++
+	if deletes.completed(result) {
+		run.checkpoint.Complete(repo.Name)
 	}
-	return artifactsDeleted, auditRecords
+	return true
 }
 
-// RunKubernetesStrategy now returns the number of deleted artifacts and the audit records.
-func RunKubernetesStrategy(client *harbor.HarborClient, dryRun bool, safeImageSet map[string]struct{}, contextMap map[string][]utils.ImageContext, projectWhitelist map[string]struct{}) (int, [][]string) {
-	var artifactsDeleted int
-	var auditRecords [][]string
+// RunKubernetesStrategy cleans the repositories referenced by the manifest, deleting
+// artifacts that are not in the safe list.
+// If ctx is cancelled the current artifact is finished and the partial results are returned.
+// Repositories recorded in checkpoint are skipped and completed ones are added to it.
+// The audit records of each repository are written to journal as soon as it is done.
+func RunKubernetesStrategy(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, safeImageSet map[string]struct{}, contextMap map[string][]utils.ImageContext, projectWhitelist map[string]struct{}, checkpoint *Checkpoint, journal *AuditJournal) Result {
+	dryRun := cfg.DryRun
+	q := newQuarantine(client, cfg.Harbor.Quarantine, cfg.Quiet())
+	protect := newProtection(client, &cfg.Harbor)
+	protect.indexDigests(ctx, client, cfg)
+	var policy *retentionPolicy
+	if cfg.K8s.KeepRetained {
+		var err error
+		if policy, err = newRetentionPolicy(&cfg.Harbor); err != nil {
+			log.Fatalf("❌ Invalid retention settings: %v", err)
+		}
+	}
 
 	// Add CSV header for the audit report
-	auditRecords = append(auditRecords, []string{"Image", "Status", "Used In Environments", "Used In Namespaces", "Notes"})
+	result := Result{Audit: [][]string{{"Image", "Status", "Used In Environments", "Used In Namespaces", "Notes"}}}
+	journal.start(result.Audit[0])
 
 	log.Println("⚪️ Starting cleanup based on Kubernetes in-use images strategy.")
+	if policy != nil {
+		log.Println("📐 Also keeping the artifacts the harbor retention rules retain (k8s.keep-retained).")
+	}
 	inUseRepoNames := make(map[string]struct{})
 	harborDomain := strings.TrimPrefix(client.BaseURL, "https://")
 	harborDomain = strings.TrimPrefix(harborDomain, "http://")
@@ -125,86 +509,154 @@ func RunKubernetesStrategy(client *harbor.HarborClient, dryRun bool, safeImageSe
 			repoAndTag := strings.TrimPrefix(safeImage, harborDomain+"/")
 			if lastColon := strings.LastIndex(repoAndTag, ":"); lastColon != -1 {
 				repoName := repoAndTag[:lastColon]
-				inUseRepoNames[repoName] = struct{}{}
+				if repoSelected(cfg.Harbor.OnlyReposMatching, repoName) {
+					inUseRepoNames[repoName] = struct{}{}
+				}
 			}
 		}
 	}
-
-	projects, err := client.ListProjects()
-	if err != nil {
-		log.Fatalf("❌ Failed to list projects: %v", err)
+	result.ReposTotal = len(inUseRepoNames)
+	graceCutoff := globalGraceCutoff(cfg)
+	var index manifestIndex
+	if cfg.K8s.Explain {
+		index = newManifestIndex(safeImageSet)
+		log.Printf("🔎 Explaining manifest lookups: each artifact is looked up as %s/<repository>:<first tag>.", harborDomain)
 	}
 
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	projects = skipProtectedProjects(client, cfg, projects)
+	projects = skipNativeRetentionProjects(client, cfg, projects)
+	reportRepoFilter(client, cfg, projects)
 	for _, project := range projects {
-		if projectWhitelist != nil {
-			if _, ok := projectWhitelist[project.Name]; !ok {
-				log.Printf("    ⏭️  Skipping project %s (not in whitelist).", project.Name)
-				continue
-			}
-		}
-
 		log.Printf("  ▶️  Processing Project: %s", project.Name)
-		repos, err := client.ListRepositories(project.Name)
-		if err != nil {
-			log.Printf("    ❌ Failed to list repositories for project %s: %v", project.Name, err)
-			continue
-		}
-
-		for _, repo := range repos {
+		for repo := range streamRepositories(client, project.Name) {
 			if _, found := inUseRepoNames[repo.Name]; !found {
 				continue // Skip repos not managed by K8s
 			}
+			if result.limitReached(cfg.Harbor.RepoLimit) {
+				return result
+			}
+			if checkpoint.Done(repo.Name) {
+				log.Printf("    ⏭️  Skipping repository %s (completed before resume).", repo.Name)
+				result.ReposProcessed++
+				continue
+			}
 
 			log.Printf("    ▶️  Processing Repository: %s", repo.Name)
+			start := time.Now()
 			artifacts, err := client.ListArtifacts(project.Name, repo.Name)
 			if err != nil {
 				log.Printf("        ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
 				continue
 			}
 
-			for _, art := range artifacts {
+			var retention *repoRetention
+			if policy != nil {
+				var ruleSource string
+				retention, ruleSource = policy.forRepo(repo.Name)
+				// The rules count artifacts newest first, by sort-key.
+				sort.Slice(artifacts, func(i, j int) bool {
+					return policy.sortTime(artifacts[i]).After(policy.sortTime(artifacts[j]))
+				})
+				if cfg.Harbor.RepoSettings {
+					ruleSource = retention.applyRepoSettings(repo, artifacts, ruleSource)
+				}
+				retention.logRules(ruleSource)
+				retention.observe(artifacts)
+			}
+
+			referenced := protect.referencedDigests(artifacts)
+			deletes := newRepoDeletes(project.Name, repo.Name, cfg, journal, &result, protect.digests)
+			guarded := false
+			if !inSafeList(artifacts, harborDomain+"/"+repo.Name, safeImageSet) {
+				log.Printf("        ⚠️  Repository %s is in use, but none of its artifacts is in the safe list; the manifest may not match its tags.", repo.Name)
+				if cfg.K8s.Strict {
+					log.Printf("        ❌ Not cleaning %s (k8s.strict).", repo.Name)
+					result.Guarded = append(result.Guarded, repo.Name)
+					guarded = true
+				}
+			}
+			for i, art := range artifacts {
+				if stopped(ctx) {
+					deletes.flush(ctx, client, &result, cfg.Harbor.DeleteConcurrency)
+					return result
+				}
 				if len(art.Tags) == 0 {
 					continue
 				}
 				tagName := art.Tags[0].Name
 				fullImageName := harborDomain + "/" + repo.Name + ":" + tagName
-				
+
+				var status string
 				var auditRecord []string
 
-				if _, isSafe := safeImageSet[fullImageName]; isSafe {
+				_, isSafe := safeImageSet[fullImageName]
+				if index != nil {
+					index.explain(harborDomain, repo.Name, art, fullImageName, isSafe)
+				}
+				retained, reason := false, ""
+				if retention != nil {
+					retained, reason = retention.decide(i, art, tagName)
+				}
+				if guarded {
+					status = "SKIPPED"
+					logDecision(cfg, "🔒", status, fullImageName)
+					auditRecord = []string{fullImageName, status, "-", "-", "Skipped: in-use repository has no artifact in the safe list (strict)"}
+				} else if isSafe {
 					contexts := contextMap[fullImageName]
 					var envs, namespaces []string
 					for _, c := range contexts {
 						envs = append(envs, c.Env)
 						namespaces = append(namespaces, c.Namespace)
 					}
-					status := "KEPT"
-					log.Printf("        🟢 %s: %s", status, fullImageName)
+					status = "KEPT"
+					logDecision(cfg, "🟢", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
 					auditRecord = []string{fullImageName, status, strings.Join(envs, ","), strings.Join(namespaces, ","), "In use by Kubernetes"}
+				} else if withinGlobalGrace(art, graceCutoff) {
+					status = "KEPT"
+					logDecision(cfg, "🟢", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+					auditRecord = []string{fullImageName, status, "-", "-", globalGraceNote}
+				} else if retained {
+					status = "KEPT"
+					logDecision(cfg, "🟢", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+					auditRecord = []string{fullImageName, status, "-", "-", "Not in K8s manifest file; " + reason}
+				} else if protected, note := protect.reason(project, repo.Name, art, referenced); protected != "" {
+					status = "SKIPPED"
+					logDecision(cfg, "🔒", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+					auditRecord = []string{fullImageName, status, "-", "-", protected}
 				} else {
-					status := "DELETED"
-					if dryRun {
-						status = "TO BE DELETED"
-					}
-					log.Printf("        🔴 %s: %s", status, fullImageName)
-					
-					if !dryRun {
-						err := client.DeleteArtifact(project.Name, repo.Name, art.Digest)
-						if err != nil {
-							log.Printf("            ❌ FAILED to delete artifact %s: %v", tagName, err)
-							status = "DELETE_FAILED"
-						} else {
-							log.Printf("            ✅ Successfully deleted artifact %s.", tagName)
-							artifactsDeleted++
-						}
-					} else {
-						artifactsDeleted++
-					}
-					auditRecord = []string{fullImageName, status, "-", "-", "Not found in K8s manifest file"}
+					var notes string
+					status, notes = q.expire(project, repo.Name, art, tagName, dryRun, joinNotes(joinNotes("Not found in K8s manifest file", reason), note))
+					logDecision(cfg, "🔴", status, fullImageName)
+					auditRecord = []string{fullImageName, status, "-", "-", notes}
 				}
-				auditRecords = append(auditRecords, auditRecord)
+				deletes.record(&result, auditRecord, art, tagName)
+			}
+			deletes.flush(ctx, client, &result, cfg.Harbor.DeleteConcurrency)
+			result.ReposProcessed++
+			result.timeRepo(project.Name, repo.Name, start)
+			if deletes.completed(&result) {
+				checkpoint.Complete(repo.Name)
 			}
 		}
 	}
-	return artifactsDeleted, auditRecords
-}
\ No newline at end of file
+	return result
+}
+
+// inSafeList reports whether any tagged artifact of the repository (as image, without
+// tag) is in the safe list, judged the same way as the decisions of the clean stage.
+func inSafeList(artifacts []harbor.Artifact, image string, safeImageSet map[string]struct{}) bool {
+	for _, art := range artifacts {
+		if len(art.Tags) == 0 {
+			continue
+		}
+		if _, ok := safeImageSet[image+":"+art.Tags[0].Name]; ok {
+			return true
+		}
+	}
+	return false
+}
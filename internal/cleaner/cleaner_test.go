@@ -0,0 +1,155 @@
+package cleaner
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+)
+
+// TestResultTotalsReconcile cleans a repository with kept artifacts, successful deletes and
+// a failed delete, and checks that Deleted, Failed and Kept each match the audit records and
+// add up to Processed.
+func TestResultTotalsReconcile(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{"app/api": {
+			taggedArtifact("sha256:a1", 1*time.Hour, "1.4.0"),
+			taggedArtifact("sha256:a2", 2*time.Hour, "1.3.0"),
+			taggedArtifact("sha256:a3", 3*time.Hour, "1.2.0"),
+			taggedArtifact("sha256:a4", 4*time.Hour, "1.1.0"),
+			taggedArtifact("sha256:a5", 5*time.Hour, "1.0.0"),
+		}})
+		fake.failDeletes["sha256:a4"] = true
+		cfg := &config.Config{DryRun: dryRun, Harbor: config.HarborConfig{
+			KeepLastN:                      2,
+			SortKey:                        "push_time",
+			ProcessNativeRetentionProjects: true,
+		}}
+
+		result, err := CleanRepository(context.Background(), client, cfg, "app", "app/api")
+		if err != nil {
+			t.Fatalf("dry-run %v: CleanRepository: %v", dryRun, err)
+		}
+
+		statuses := make(map[string]int)
+		for _, record := range result.Audit[1:] {
+			statuses[record[1]]++
+		}
+		wantDeleted, wantFailed := 2, 1
+		deletedStatus := "DELETED"
+		if dryRun {
+			wantDeleted, wantFailed, deletedStatus = 3, 0, "TO BE DELETED"
+		}
+		if result.Deleted != wantDeleted || result.Failed != wantFailed || result.Kept != 2 {
+			t.Errorf("dry-run %v: deleted %d, failed %d, kept %d; want %d, %d, 2", dryRun, result.Deleted, result.Failed, result.Kept, wantDeleted, wantFailed)
+		}
+		if statuses[deletedStatus] != result.Deleted || statuses["DELETE_FAILED"] != result.Failed || statuses["KEPT"] != result.Kept {
+			t.Errorf("dry-run %v: totals don't match the audit statuses %v", dryRun, statuses)
+		}
+		if got, want := fake.deleteCount("sha256:a3"), map[bool]int{false: 1, true: 0}[dryRun]; got != want {
+			t.Errorf("dry-run %v: %d DELETE requests for an expired artifact, want %d", dryRun, got, want)
+		}
+		if result.Processed() != len(result.Audit)-1 || result.Processed() != 5 {
+			t.Errorf("dry-run %v: processed %d with %d audit records, want 5", dryRun, result.Processed(), len(result.Audit)-1)
+		}
+	}
+}
+
+// TestUntaggedKeepLastPositions checks that untagged artifacts take their place in keep-last
+// whether or not include-untagged-in-count is set, and are only decided when it is.
+func TestUntaggedKeepLastPositions(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		_, client := newFakeHarbor(t, map[string][]harbor.Artifact{"app/api": {
+			taggedArtifact("sha256:a1", 1*time.Hour, "1.2.0"),
+			taggedArtifact("sha256:u1", 2*time.Hour),
+			taggedArtifact("sha256:a2", 3*time.Hour, "1.1.0"),
+		}})
+		cfg := &config.Config{DryRun: true, Harbor: config.HarborConfig{
+			KeepLastN:                      2,
+			SortKey:                        "push_time",
+			IncludeUntaggedInCount:         include,
+			ProcessNativeRetentionProjects: true,
+		}}
+
+		result, err := CleanRepository(context.Background(), client, cfg, "app", "app/api")
+		if err != nil {
+			t.Fatalf("include %v: CleanRepository: %v", include, err)
+		}
+		statuses := make(map[string]string)
+		for _, record := range result.Audit[1:] {
+			ref := record[0][strings.LastIndex(record[0], ":")+1:]
+			if _, digest, ok := strings.Cut(record[0], "@"); ok {
+				ref = digest
+			}
+			statuses[ref] = record[1]
+		}
+		want := map[string]string{"1.2.0": "KEPT", "1.1.0": "TO BE DELETED"}
+		if include {
+			want["sha256:u1"] = "KEPT"
+		}
+		if len(statuses) != len(want) {
+			t.Errorf("include %v: audit statuses %v, want %v", include, statuses, want)
+		}
+		for ref, status := range want {
+			if statuses[ref] != status {
+				t.Errorf("include %v: %s is %q, want %q", include, ref, statuses[ref], status)
+			}
+		}
+	}
+}
+
+// TestCleanRepositoryHonorsRepoFilter checks that a repository cleaned on its own, as by the
+// webhook strategy, is left alone when it doesn't match only-repos-matching.
+func TestCleanRepositoryHonorsRepoFilter(t *testing.T) {
+	fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{"app/api": {
+		taggedArtifact("sha256:a1", 1*time.Hour, "1.1.0"),
+		taggedArtifact("sha256:a2", 2*time.Hour, "1.0.0"),
+	}})
+	cfg := &config.Config{Harbor: config.HarborConfig{
+		KeepLastN:                      1,
+		SortKey:                        "push_time",
+		OnlyReposMatching:              []string{"app/web"},
+		ProcessNativeRetentionProjects: true,
+	}}
+
+	result, err := CleanRepository(context.Background(), client, cfg, "app", "app/api")
+	if err != nil {
+		t.Fatalf("CleanRepository: %v", err)
+	}
+	if result.Processed() != 0 || fake.deleteCount("sha256:a2") != 0 {
+		t.Errorf("processed %d artifacts and sent %d DELETE requests in an excluded repository, want none", result.Processed(), fake.deleteCount("sha256:a2"))
+	}
+}
+
+// TestCheckpointOnlyCompletesCleanRepositories checks that a repository with a failed delete
+// is not recorded in the checkpoint, so --resume retries it, while one without is.
+func TestCheckpointOnlyCompletesCleanRepositories(t *testing.T) {
+	fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{
+		"app/api": {taggedArtifact("sha256:a2", 1*time.Hour, "1.1.0"), taggedArtifact("sha256:a1", 2*time.Hour, "1.0.0")},
+		"app/web": {taggedArtifact("sha256:w2", 1*time.Hour, "1.1.0"), taggedArtifact("sha256:w1", 2*time.Hour, "1.0.0")},
+	})
+	fake.failDeletes["sha256:a1"] = true
+	cfg := &config.Config{Harbor: config.HarborConfig{KeepLastN: 1, SortKey: "push_time"}}
+	run, err := newHarborRun(client, cfg)
+	if err != nil {
+		t.Fatalf("newHarborRun: %v", err)
+	}
+	if run.checkpoint, err = OpenCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), cfg, false); err != nil {
+		t.Fatalf("OpenCheckpoint: %v", err)
+	}
+	project := harbor.Project{Name: "app"}
+	for _, repo := range []string{"app/api", "app/web"} {
+		run.cleanRepository(context.Background(), project, harbor.Repository{Name: repo}, &run.result)
+	}
+
+	if run.checkpoint.Done("app/api") {
+		t.Errorf("app/api, with a failed delete, is recorded as completed")
+	}
+	if !run.checkpoint.Done("app/web") {
+		t.Errorf("app/web is not recorded as completed")
+	}
+}
@@ -0,0 +1,104 @@
+// File: delete_artifact.go
+package cleaner
+
+import (
+	"bufio"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/utils"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// confirmDigestLength is the number of digest characters, after the algorithm, an operator
+// gives to confirm a targeted delete.
+const confirmDigestLength = 12
+
+// DeleteOne deletes the single artifact image points to, "project/repo:tag" or
+// "project/repo@sha256:...", for an ad-hoc cleanup. The artifact's tags and push time are
+// logged first and, unless yes is set, the operator must confirm with the first
+// confirmDigestLength characters of its digest: those in confirm or, when it is empty, a
+// line read from in after a prompt. The artifact is deleted by digest, so a tag moved since
+// it was resolved is not affected. In dry-run mode nothing is deleted.
+func DeleteOne(client *harbor.HarborClient, cfg *config.Config, image, confirm string, yes bool, in io.Reader) error {
+	projectName, repoName, reference, err := parseArtifactRef(image)
+	if err != nil {
+		return err
+	}
+	art, err := client.GetArtifact(projectName, repoName, reference)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", image, err)
+	}
+	tags := make([]string, 0, len(art.Tags))
+	for _, tag := range art.Tags {
+		tags = append(tags, tag.Name)
+	}
+	tagList := "(untagged)"
+	if len(tags) > 0 {
+		tagList = strings.Join(tags, ", ")
+	}
+	log.Printf("🎯 %s resolves to %s@%s", image, repoName, art.Digest)
+	log.Printf("    Tags:   %s", tagList)
+	log.Printf("    Pushed: %s", art.PushTime.UTC().Format(time.RFC3339))
+	log.Printf("    Size:   %s", utils.FormatBytes(art.Size))
+	if len(tags) > 1 {
+		log.Printf("    ⚠️  Deleting the artifact removes all %d tags.", len(tags))
+	}
+
+	if !yes {
+		if confirm == "" {
+			log.Printf("❓ Type the first %d characters of the digest (after \"sha256:\") to confirm the delete:", confirmDigestLength)
+			line, err := bufio.NewReader(in).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("no confirmation given, %s was not deleted", image)
+			}
+			confirm = line
+		}
+		if !digestConfirmed(art.Digest, confirm) {
+			return fmt.Errorf("confirmation %q does not match digest %s, %s was not deleted", strings.TrimSpace(confirm), art.Digest, image)
+		}
+	}
+
+	if cfg.DryRun {
+		log.Printf("🟡 DRY-RUN: %s@%s would be deleted.", repoName, art.Digest)
+		return nil
+	}
+	if err := client.DeleteByReference(projectName, repoName, art.Digest); err != nil {
+		return fmt.Errorf("failed to delete %s@%s: %w", repoName, art.Digest, err)
+	}
+	log.Printf("🗑️  Deleted %s@%s (tags: %s).", repoName, art.Digest, tagList)
+	return nil
+}
+
+// parseArtifactRef splits "project/repo:tag" or "project/repo@digest" into the project, the
+// full repository name and the reference.
+func parseArtifactRef(image string) (string, string, string, error) {
+	repoName, reference, ok := strings.Cut(image, "@")
+	if !ok {
+		i := strings.LastIndex(image, ":")
+		if i <= strings.LastIndex(image, "/") {
+			return "", "", "", fmt.Errorf("%q names no tag or digest, expected project/repo:tag or project/repo@digest", image)
+		}
+		repoName, reference = image[:i], image[i+1:]
+	}
+	projectName, _, ok := strings.Cut(repoName, "/")
+	if !ok || projectName == "" || reference == "" {
+		return "", "", "", fmt.Errorf("invalid artifact %q, expected project/repo:tag or project/repo@digest", image)
+	}
+	return projectName, repoName, reference, nil
+}
+
+// digestConfirmed reports whether confirm, with or without the algorithm prefix, holds at
+// least the first confirmDigestLength characters of digest.
+func digestConfirmed(digest, confirm string) bool {
+	confirm = strings.TrimSpace(confirm)
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok {
+		hex = digest
+	}
+	confirm = strings.TrimPrefix(confirm, algorithm+":")
+	return len(confirm) >= confirmDigestLength && strings.HasPrefix(hex, confirm)
+}
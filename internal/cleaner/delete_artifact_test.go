@@ -0,0 +1,75 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+)
+
+// TestDeleteOneConfirmation checks that a targeted delete only happens once the digest is
+// confirmed, by flag, prompt or --yes, and then deletes by digest.
+func TestDeleteOneConfirmation(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name    string
+		confirm string
+		yes     bool
+		input   string
+		dryRun  bool
+		deletes int
+	}{
+		{"confirmed by flag", "0123456789ab", false, "", false, 1},
+		{"confirmed with algorithm", "sha256:0123456789abcdef", false, "", false, 1},
+		{"confirmed at prompt", "", false, "0123456789ab\n", false, 1},
+		{"yes", "", true, "", false, 1},
+		{"wrong digest", "0123456789aa", false, "", false, 0},
+		{"too short", "0123456", false, "", false, 0},
+		{"no answer", "", false, "", false, 0},
+		{"dry run", "0123456789ab", false, "", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{"app/api": {
+				taggedArtifact(digest, time.Hour, "1.0.0", "stable"),
+			}})
+			cfg := &config.Config{DryRun: tt.dryRun}
+			err := DeleteOne(client, cfg, "app/api:stable", tt.confirm, tt.yes, strings.NewReader(tt.input))
+			if tt.deletes == 0 && !tt.dryRun && err == nil {
+				t.Error("DeleteOne succeeded without a valid confirmation")
+			}
+			if tt.deletes > 0 && err != nil {
+				t.Errorf("DeleteOne: %v", err)
+			}
+			if got := fake.deleteCount(digest); got != tt.deletes {
+				t.Errorf("%d DELETE requests for the digest, want %d", got, tt.deletes)
+			}
+		})
+	}
+}
+
+// TestParseArtifactRef covers tag and digest references and references missing a project or tag.
+func TestParseArtifactRef(t *testing.T) {
+	tests := []struct {
+		image, project, repo, reference string
+	}{
+		{"library/nginx:1.25", "library", "library/nginx", "1.25"},
+		{"team/app/api@sha256:abc", "team", "team/app/api", "sha256:abc"},
+		{"nginx:1.25", "", "", ""},
+		{"library/nginx", "", "", ""},
+	}
+	for _, tt := range tests {
+		project, repo, reference, err := parseArtifactRef(tt.image)
+		if tt.project == "" {
+			if err == nil {
+				t.Errorf("parseArtifactRef(%q) accepted an invalid reference", tt.image)
+			}
+			continue
+		}
+		if err != nil || project != tt.project || repo != tt.repo || reference != tt.reference {
+			t.Errorf("parseArtifactRef(%q) = %q, %q, %q, %v; want %q, %q, %q", tt.image, project, repo, reference, err, tt.project, tt.repo, tt.reference)
+		}
+	}
+}
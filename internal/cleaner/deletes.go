@@ -0,0 +1,268 @@
+// File: deletes.go
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/utils"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pendingDelete is an expired artifact waiting for deletion, with the index of its audit record.
+type pendingDelete struct {
+	row     int
+	art     harbor.Artifact
+	tagName string
+}
+
+// repoDeletes collects the expired artifacts of one repository while the retention
+// decisions are made, so they can be deleted concurrently afterwards.
+type repoDeletes struct {
+	projectName string
+	repoName    string
+	dryRun      bool
+	verify      bool   // Re-fetch each artifact before deleting it, see verifyArtifact
+	order       string // delete-order; empty deletes in decision order
+	pending     []pendingDelete
+	journal     *AuditJournal
+	journaled   int // Audit records of result already written to journal
+	quiet       bool
+	// shared is the cross-repo-digests index, nil when disabled. The surviving copy of a
+	// shared digest is never deleted.
+	shared *sharedDigests
+	// Totals and audit records of result when the repository started, for its summary.
+	startDeleted, startFailed, startKept, startRow int
+}
+
+func newRepoDeletes(projectName, repoName string, cfg *config.Config, journal *AuditJournal, result *Result, shared *sharedDigests) *repoDeletes {
+	return &repoDeletes{
+		projectName:  projectName,
+		repoName:     repoName,
+		dryRun:       cfg.DryRun,
+		verify:       cfg.Harbor.VerifyBeforeDelete,
+		order:        cfg.Harbor.DeleteOrder,
+		journal:      journal,
+		journaled:    len(result.Audit),
+		quiet:        cfg.Quiet(),
+		shared:       shared,
+		startDeleted: result.Deleted,
+		startFailed:  result.Failed,
+		startKept:    result.Kept,
+		startRow:     len(result.Audit),
+	}
+}
+
+// record appends an artifact's audit record to result. Artifacts due for deletion are
+// queued and only counted once flush knows the outcome; in dry-run mode nothing is queued,
+// but the shared digest index is updated as a real run would.
+func (d *repoDeletes) record(result *Result, record []string, art harbor.Artifact, tagName string) {
+	result.Audit = append(result.Audit, record)
+	result.details = append(result.details, newAuditDetail(art))
+	if record[1] == "TO BE DELETED" {
+		if !d.dryRun {
+			d.pending = append(d.pending, pendingDelete{row: len(result.Audit) - 1, art: art, tagName: tagName})
+			return
+		}
+		if d.shared.reserve(d.repoName, art.Digest) {
+			d.shared.settle(d.repoName, art.Digest, true)
+		} else {
+			record[1] = "SKIPPED"
+			record[len(record)-1] += "; " + lastCopyNote
+		}
+	}
+	result.count(record[1])
+}
+
+// lastCopyNote is the audit note of a delete skipped by the shared digest index.
+const lastCopyNote = "skipped, newest copy of a digest shared with other repositories"
+
+// flush deletes the queued artifacts with at most concurrency deletions in flight and
+// records their final status. Artifacts not yet started when ctx is cancelled are skipped.
+// An artifact queued more than once, e.g. listed twice or reached through several tags, is
+// deleted once and every entry gets that outcome.
+// The records completed since the last flush are then written to the journal.
+func (d *repoDeletes) flush(ctx context.Context, client *harbor.HarborClient, result *Result, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sortPending(d.pending, d.order)
+	statuses := make([]string, len(d.pending))
+	notes := make([]string, len(d.pending))
+	// Index of the entry whose delete covers each entry, by digest.
+	first := make(map[string]int, len(d.pending))
+	covered := make([]int, len(d.pending))
+	duplicates := 0
+	for i, p := range d.pending {
+		if j, ok := first[p.art.Digest]; ok {
+			covered[i] = j
+			duplicates++
+			continue
+		}
+		first[p.art.Digest], covered[i] = i, i
+	}
+	if duplicates > 0 && !d.quiet {
+		log.Printf("        🔁 %d queued delete(s) in %s repeat a digest; each digest is deleted once.", duplicates, d.repoName)
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, p := range d.pending {
+		if covered[i] != i {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				statuses[i], notes[i] = "SKIPPED", "skipped, run stopped before deletion"
+				return
+			}
+			if d.verify {
+				if problem := verifyArtifact(client, d.projectName, d.repoName, p.art); problem != "" {
+					log.Printf("            ⚠️  Not deleting artifact %s: %s", p.tagName, problem)
+					statuses[i], notes[i] = "SKIPPED", "skipped, "+problem
+					return
+				}
+			}
+			if !d.shared.reserve(d.repoName, p.art.Digest) {
+				log.Printf("            🔒 Not deleting artifact %s: it is the newest copy of a shared digest", p.tagName)
+				statuses[i], notes[i] = "SKIPPED", lastCopyNote
+				return
+			}
+			statuses[i] = deleteArtifact(client, d.projectName, d.repoName, p.art, p.tagName, d.quiet)
+			d.shared.settle(d.repoName, p.art.Digest, statuses[i] == "DELETED")
+		}()
+	}
+	wg.Wait()
+	for i, j := range covered {
+		if j == i {
+			continue
+		}
+		statuses[i], notes[i] = statuses[j], notes[j]
+		if notes[i] == "" {
+			notes[i] = "same digest as " + d.pending[j].tagName + " (one delete call)"
+		}
+	}
+
+	stoppedEarly := 0
+	for i, p := range d.pending {
+		record := result.Audit[p.row]
+		record[1] = statuses[i]
+		if notes[i] != "" {
+			record[len(record)-1] += "; " + notes[i]
+		}
+		if notes[i] == "skipped, run stopped before deletion" {
+			stoppedEarly++
+		}
+		result.count(statuses[i])
+	}
+	if stoppedEarly > 0 {
+		log.Printf("        ⏭️  %d expired artifact(s) in %s were not deleted because the run stopped.", stoppedEarly, d.repoName)
+	}
+	d.pending = nil
+
+	offset := len(result.Audit) - len(result.details) // 1 when Audit starts with the header row
+	d.journal.append(result.Audit[d.journaled:], result.details[d.journaled-offset:])
+	d.journaled = len(result.Audit)
+
+	d.logSummary(result)
+}
+
+// completed reports whether the repository can be recorded in the checkpoint: none of its
+// deletes or quarantine labels failed, so --resume has nothing to retry in it.
+func (d *repoDeletes) completed(result *Result) bool {
+	if failed := result.Failed - d.startFailed; failed > 0 {
+		log.Printf("        ⚠️  %d failed delete(s) in %s; not marking it completed, so --resume retries it.", failed, d.repoName)
+		return false
+	}
+	return true
+}
+
+// logSummary logs the repository's tally, also in quiet mode, so long logs can be scanned
+// per repository. Reclaimed bytes count each deleted digest once; Harbor only frees the
+// space, less any layers shared with other artifacts, after garbage collection.
+func (d *repoDeletes) logSummary(result *Result) {
+	reclaimed := result.reclaimedSince(d.startRow)
+	deletedLabel, reclaimedLabel := "deleted", "reclaimed"
+	if d.dryRun {
+		deletedLabel, reclaimedLabel = "to be deleted", "would reclaim"
+	}
+	log.Printf("        📊 %s: kept %d, %s %d, failed %d, %s %s", d.repoName,
+		result.Kept-d.startKept, deletedLabel, result.Deleted-d.startDeleted, result.Failed-d.startFailed,
+		reclaimedLabel, utils.FormatBytes(reclaimed))
+}
+
+// reclaimedSince returns the size of the artifacts deleted, or to be deleted, in the audit
+// records from row on, counting each digest once.
+func (r *Result) reclaimedSince(row int) int64 {
+	offset := len(r.Audit) - len(r.details)
+	var reclaimed int64
+	seen := make(map[string]bool)
+	for ; row < len(r.Audit); row++ {
+		switch r.Audit[row][1] {
+		case "DELETED", "TO BE DELETED":
+			if detail := r.details[row-offset]; !seen[detail.digest] {
+				seen[detail.digest] = true
+				reclaimed += detail.size
+			}
+		}
+	}
+	return reclaimed
+}
+
+// Reclaimed returns the size of all artifacts deleted, or to be deleted in dry-run mode,
+// counting each digest once. Harbor frees the space after garbage collection.
+func (r *Result) Reclaimed() int64 {
+	return r.reclaimedSince(len(r.Audit) - len(r.details))
+}
+
+// sortPending orders the queued deletes by delete-order. It only changes the order the
+// deletes are issued in, which matters when a run is stopped part-way.
+func sortPending(pending []pendingDelete, order string) {
+	var less func(a, b harbor.Artifact) bool
+	switch order {
+	case "oldest-first":
+		less = func(a, b harbor.Artifact) bool { return a.PushTime.Before(b.PushTime) }
+	case "largest-first":
+		less = func(a, b harbor.Artifact) bool { return a.Size > b.Size }
+	case "least-recently-pulled":
+		// Never-pulled artifacts have a zero pull time and come first.
+		less = func(a, b harbor.Artifact) bool { return a.PullTime.Before(b.PullTime) }
+	default:
+		return
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return less(pending[i].art, pending[j].art) })
+}
+
+// verifyArtifact re-fetches an artifact by digest and checks that its digest and tags still
+// match the listing, so nothing that changed in between is deleted. It returns a description
+// of the mismatch, or "" if the artifact is unchanged.
+func verifyArtifact(client *harbor.HarborClient, projectName, repoName string, listed harbor.Artifact) string {
+	current, err := client.GetArtifact(projectName, repoName, listed.Digest)
+	if err != nil {
+		return fmt.Sprintf("could not re-fetch artifact for verification: %v", err)
+	}
+	if current.Digest != listed.Digest {
+		return fmt.Sprintf("digest changed since listing (%s, now %s)", listed.Digest, current.Digest)
+	}
+	if listedTags, currentTags := tagNames(listed), tagNames(current); listedTags != currentTags {
+		return fmt.Sprintf("tags changed since listing (%s, now %s)", listedTags, currentTags)
+	}
+	return ""
+}
+
+// tagNames returns the artifact's tag names, sorted and comma-separated.
+func tagNames(art harbor.Artifact) string {
+	names := make([]string, len(art.Tags))
+	for i, t := range art.Tags {
+		names[i] = t.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
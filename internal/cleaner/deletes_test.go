@@ -0,0 +1,44 @@
+package cleaner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+)
+
+// TestRepoDeletesOneCallPerDigest queues a digest reached through two tags and checks that
+// it is deleted with a single DELETE request while both tags are reported deleted.
+func TestRepoDeletesOneCallPerDigest(t *testing.T) {
+	shared := taggedArtifact("sha256:d1", time.Hour, "1.0.0", "latest")
+	other := taggedArtifact("sha256:d2", 2*time.Hour, "0.9.0")
+	fake, client := newFakeHarbor(t, map[string][]harbor.Artifact{"app/api": {shared, other}})
+
+	result := Result{Audit: [][]string{{"Image", "Status", "Notes"}}}
+	deletes := newRepoDeletes("app", "app/api", &config.Config{}, nil, &result, nil)
+	deletes.record(&result, []string{"app/api:1.0.0", "TO BE DELETED", ""}, shared, "1.0.0")
+	deletes.record(&result, []string{"app/api:latest", "TO BE DELETED", ""}, shared, "latest")
+	deletes.record(&result, []string{"app/api:0.9.0", "TO BE DELETED", ""}, other, "0.9.0")
+	deletes.flush(context.Background(), client, &result, 4)
+
+	if got := fake.deleteCount("sha256:d1"); got != 1 {
+		t.Errorf("%d DELETE requests for the shared digest, want 1", got)
+	}
+	if got := fake.deleteCount("sha256:d2"); got != 1 {
+		t.Errorf("%d DELETE requests for the other digest, want 1", got)
+	}
+	for _, record := range result.Audit[1:] {
+		if record[1] != "DELETED" {
+			t.Errorf("%s has status %s, want DELETED", record[0], record[1])
+		}
+	}
+	if note := result.Audit[2][2]; !strings.Contains(note, "same digest as 1.0.0") {
+		t.Errorf("note of the second tag = %q, want it to name the tag whose delete covered it", note)
+	}
+	if result.Deleted != 3 || result.Failed != 0 {
+		t.Errorf("deleted %d, failed %d; want 3 and 0", result.Deleted, result.Failed)
+	}
+}
@@ -0,0 +1,103 @@
+// File: duplicates.go
+package cleaner
+
+import (
+	"context"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// digestLocations is every repository an artifact digest was found in.
+type digestLocations struct {
+	size      int64
+	projects  map[string]struct{}
+	locations []string // "repo:tag" or "repo@digest" for untagged artifacts
+	repos     map[string]struct{}
+}
+
+// FindDuplicateDigests indexes the artifacts of all projects by digest and reports the
+// digests present in more than one repository, largest potential saving first. Projects
+// with fewer than min-repos-per-project repositories are skipped. It only reads from Harbor.
+// The first record is the header.
+func FindDuplicateDigests(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}) [][]string {
+	log.Println("⚪️ Indexing artifacts by digest to find cross-repository duplicates.")
+	index := make(map[string]*digestLocations)
+
+	for _, project := range filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError) {
+		log.Printf("  ▶️  Processing Project: %s", project.Name)
+		repos, ok := listRepositories(client, project.Name, cfg.ContinueOnError)
+		if !ok {
+			continue
+		}
+		for _, repo := range repos {
+			if stopped(ctx) {
+				return duplicateRecords(index)
+			}
+			artifacts, err := client.ListArtifacts(project.Name, repo.Name)
+			if err != nil {
+				log.Printf("    ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+				continue
+			}
+			for _, art := range artifacts {
+				entry, ok := index[art.Digest]
+				if !ok {
+					entry = &digestLocations{size: art.Size, projects: make(map[string]struct{}), repos: make(map[string]struct{})}
+					index[art.Digest] = entry
+				}
+				entry.projects[project.Name] = struct{}{}
+				entry.repos[repo.Name] = struct{}{}
+				if len(art.Tags) == 0 {
+					entry.locations = append(entry.locations, repo.Name+"@"+art.Digest)
+				}
+				for _, tag := range art.Tags {
+					entry.locations = append(entry.locations, repo.Name+":"+tag.Name)
+				}
+			}
+		}
+	}
+	return duplicateRecords(index)
+}
+
+// duplicateRecords turns the digest index into report records for digests in several repositories.
+func duplicateRecords(index map[string]*digestLocations) [][]string {
+	type duplicate struct {
+		digest string
+		entry  *digestLocations
+		wasted int64
+	}
+	var duplicates []duplicate
+	for digest, entry := range index {
+		if len(entry.repos) > 1 {
+			duplicates = append(duplicates, duplicate{digest, entry, entry.size * int64(len(entry.repos)-1)})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].wasted != duplicates[j].wasted {
+			return duplicates[i].wasted > duplicates[j].wasted
+		}
+		return duplicates[i].digest < duplicates[j].digest
+	})
+
+	records := [][]string{{"Digest", "Size Bytes", "Repositories", "Projects", "Locations"}}
+	for _, d := range duplicates {
+		projects := make([]string, 0, len(d.entry.projects))
+		for p := range d.entry.projects {
+			projects = append(projects, p)
+		}
+		sort.Strings(projects)
+		sort.Strings(d.entry.locations)
+		records = append(records, []string{
+			d.digest,
+			strconv.FormatInt(d.entry.size, 10),
+			strconv.Itoa(len(d.entry.repos)),
+			strings.Join(projects, ","),
+			strings.Join(d.entry.locations, ","),
+		})
+	}
+	log.Printf("🔁 Found %d digests present in more than one repository.", len(duplicates))
+	return records
+}
@@ -0,0 +1,65 @@
+// File: expectations.go
+package cleaner
+
+import (
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"slices"
+	"strings"
+)
+
+// UnexpectedDeletions checks the deletions of a run against expect-deletions and describes
+// the total and each repository outside its bounds. Repositories are those the run
+// processed, so a repository with no deletions is checked against its minimum too.
+func UnexpectedDeletions(result Result, bounds config.ExpectDeletionsConfig) []string {
+	var problems []string
+	if !within(result.Deleted, bounds.Min, bounds.Max) {
+		problems = append(problems, fmt.Sprintf("total: %d deletions, expected %s", result.Deleted, describeBounds(bounds.Min, bounds.Max)))
+	}
+
+	counts := make(map[string]int)
+	for _, t := range result.Timings {
+		counts[t.Repo] = 0
+	}
+	for _, g := range DeletionsByRepository(result.Audit) {
+		counts[auditRepoName(g.Name)] += g.Count
+	}
+	repos := make([]string, 0, len(counts))
+	for repo := range counts {
+		repos = append(repos, repo)
+	}
+	slices.Sort(repos)
+	for _, repo := range repos {
+		for _, b := range bounds.Repos {
+			if !config.MatchWildcard(b.Pattern, repo) {
+				continue
+			}
+			if n := counts[repo]; !within(n, b.Min, b.Max) {
+				problems = append(problems, fmt.Sprintf("%s: %d deletions, expected %s (pattern '%s')", repo, n, describeBounds(b.Min, b.Max), b.Pattern))
+			}
+			break
+		}
+	}
+	return problems
+}
+
+func within(n, min int, max *int) bool {
+	return n >= min && (max == nil || n <= *max)
+}
+
+func describeBounds(min int, max *int) string {
+	if max == nil {
+		return fmt.Sprintf("at least %d", min)
+	}
+	return fmt.Sprintf("%d to %d", min, *max)
+}
+
+// auditRepoName returns the repository of an audit image without the registry, e.g.
+// "app/api" for "https://harbor.example.com/app/api".
+func auditRepoName(image string) string {
+	if _, rest, ok := strings.Cut(image, "://"); ok {
+		image = rest
+	}
+	_, repo, _ := strings.Cut(image, "/")
+	return repo
+}
@@ -0,0 +1,122 @@
+// File: explain.go
+package cleaner
+
+import (
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"slices"
+	"strings"
+)
+
+// imageRef is a manifest image reference split into its parts.
+type imageRef struct {
+	host   string // Registry host, with port; empty for Docker Hub
+	path   string // Repository path, e.g. "library/nginx"
+	tag    string
+	digest string
+}
+
+// parseImageRef splits an image reference such as "harbor.local:8443/library/nginx:1.25"
+// or "harbor.local/library/nginx@sha256:...". The first path component is the host if it
+// contains "." or ":" or is "localhost", as for docker pull.
+func parseImageRef(image string) imageRef {
+	var ref imageRef
+	image, ref.digest, _ = strings.Cut(image, "@")
+	if slash := strings.LastIndex(image, "/"); strings.LastIndex(image, ":") > slash {
+		colon := strings.LastIndex(image, ":")
+		image, ref.tag = image[:colon], image[colon+1:]
+	}
+	if first, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.host, image = first, rest
+	}
+	ref.path = image
+	return ref
+}
+
+// manifestIndex groups the manifest's images by repository path, ignoring the registry
+// host, tag and digest, so --explain-k8s can show the entries that nearly match an artifact.
+type manifestIndex map[string][]string
+
+func newManifestIndex(safeImageSet map[string]struct{}) manifestIndex {
+	index := make(manifestIndex)
+	for image := range safeImageSet {
+		path := parseImageRef(image).path
+		index[path] = append(index[path], image)
+	}
+	return index
+}
+
+// explain logs the manifest key looked up for a tagged artifact and, when it was not found,
+// the manifest entries for the same repository that refer to this artifact in a form the
+// clean stage does not match, such as another registry host or port, a digest or a tag
+// other than the artifact's first.
+func (index manifestIndex) explain(harborDomain, repoName string, art harbor.Artifact, key string, found bool) {
+	if found {
+		log.Printf("            🔎 %s: looked up %q, found in the manifest", shortDigest(art.Digest), key)
+		return
+	}
+	log.Printf("            🔎 %s: looked up %q, not in the manifest", shortDigest(art.Digest), key)
+
+	var tags []string
+	for _, tag := range art.Tags {
+		tags = append(tags, tag.Name)
+	}
+	if len(tags) > 1 {
+		log.Printf("            🔎 Tags of the artifact: %s (only the first is looked up)", strings.Join(tags, ", "))
+	}
+	candidates := index[repoName]
+	if len(candidates) == 0 {
+		log.Printf("            🔎 The manifest has no entry for repository %s under any registry.", repoName)
+		return
+	}
+	near := 0
+	for _, image := range candidates {
+		ref := parseImageRef(image)
+		var reasons []string
+		if ref.host != harborDomain {
+			reasons = append(reasons, "registry "+quoteOrNone(ref.host)+" instead of "+quoteOrNone(harborDomain))
+		}
+		if ref.digest != "" {
+			if ref.digest == art.Digest {
+				reasons = append(reasons, "refers to this artifact by digest, but only tags are looked up")
+			} else {
+				continue // Another artifact
+			}
+		}
+		if ref.tag != "" && ref.digest == "" {
+			switch {
+			case !slices.Contains(tags, ref.tag):
+				continue // Another artifact
+			case ref.tag != tags[0]:
+				reasons = append(reasons, "tag "+ref.tag+" is not the artifact's first tag")
+			}
+		}
+		if ref.tag == "" && ref.digest == "" {
+			if !slices.Contains(tags, "latest") {
+				continue // Another artifact
+			}
+			reasons = append(reasons, "has no tag, which means latest, but tags are looked up as written")
+		}
+		log.Printf("            🔎 Near match %q: %s", image, strings.Join(reasons, "; "))
+		near++
+	}
+	if near == 0 {
+		log.Printf("            🔎 The manifest lists %d other references of %s, none for this artifact.", len(candidates), repoName)
+	}
+}
+
+// shortDigest returns the first 12 hex characters of a digest, as shown by docker.
+func shortDigest(digest string) string {
+	_, hex, _ := strings.Cut(digest, ":")
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return digest
+}
+
+func quoteOrNone(host string) string {
+	if host == "" {
+		return "(none)"
+	}
+	return `"` + host + `"`
+}
@@ -0,0 +1,102 @@
+// File: export.go
+package cleaner
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/storage"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inventoryEntry is one tag (or untagged artifact) in an artifact inventory export.
+type inventoryEntry struct {
+	Project    string    `json:"project"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"` // Empty for untagged artifacts
+	Digest     string    `json:"digest"`
+	PushTime   time.Time `json:"push_time"`
+	Size       int64     `json:"size"`
+}
+
+// ExportArtifactInventory writes every artifact of every project, one entry per tag, to path
+// before a run changes anything. Paths ending in ".json" get a JSON array, anything else CSV.
+// path may be a local file or an s3:// or gs:// object URL. The export covers the whole
+// registry regardless of the project whitelist, and fails rather than writing a partial
+// snapshot if an artifact list can't be read.
+func ExportArtifactInventory(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, path string) error {
+	log.Printf("🗃️  Exporting the artifact inventory to %s", path)
+	var entries []inventoryEntry
+	for _, project := range filterProjects(client, nil, 0, cfg.ContinueOnError) {
+		repos, ok := listRepositories(client, project.Name, cfg.ContinueOnError)
+		if !ok {
+			return fmt.Errorf("failed to list repositories for project %s", project.Name)
+		}
+		for _, repo := range repos {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			artifacts, err := client.ListArtifacts(project.Name, repo.Name)
+			if err != nil {
+				return fmt.Errorf("failed to list artifacts for repo %s: %w", repo.Name, err)
+			}
+			for _, art := range artifacts {
+				entry := inventoryEntry{Project: project.Name, Repository: repo.Name, Digest: art.Digest, PushTime: art.PushTime, Size: art.Size}
+				if len(art.Tags) == 0 {
+					entries = append(entries, entry)
+				}
+				for _, tag := range art.Tags {
+					entry.Tag = tag.Name
+					entries = append(entries, entry)
+				}
+			}
+		}
+	}
+
+	if err := writeInventory(entries, path); err != nil {
+		return err
+	}
+	log.Printf("🗃️  Exported %d inventory entries.", len(entries))
+	return nil
+}
+
+// writeInventory writes the entries as JSON or CSV depending on the file extension.
+func writeInventory(entries []inventoryEntry, path string) (err error) {
+	file, err := storage.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create inventory file: %w", err)
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to save inventory file: %w", cerr)
+		}
+	}()
+
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		if entries == nil {
+			entries = []inventoryEntry{}
+		}
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write([]string{"project", "repository", "tag", "digest", "push_time", "size"}); err != nil {
+		return fmt.Errorf("failed to write inventory header: %w", err)
+	}
+	for _, e := range entries {
+		record := []string{e.Project, e.Repository, e.Tag, e.Digest, e.PushTime.UTC().Format(time.RFC3339), strconv.FormatInt(e.Size, 10)}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write inventory record: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,154 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/harbor"
+)
+
+// fakeHarbor serves the Harbor API calls of a repository cleanup for project "app", whose
+// repositories are listed in artifacts by full name, and artifact lookups by tag or digest.
+// Deletes are counted per digest and fail for the digests in failDeletes. The registry
+// serves the manifests in layers, counting the requests per digest.
+type fakeHarbor struct {
+	mu           sync.Mutex
+	artifacts    map[string][]harbor.Artifact
+	failDeletes  map[string]bool
+	deletes      map[string]int
+	layers       map[string][]string
+	manifestGets map[string]int
+}
+
+// newFakeHarbor starts a fakeHarbor and returns a client for it.
+func newFakeHarbor(t *testing.T, artifacts map[string][]harbor.Artifact) (*fakeHarbor, *harbor.HarborClient) {
+	t.Helper()
+	f := &fakeHarbor{
+		artifacts:    artifacts,
+		failDeletes:  make(map[string]bool),
+		deletes:      make(map[string]int),
+		layers:       make(map[string][]string),
+		manifestGets: make(map[string]int),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	client, err := harbor.NewHarborClient(server.URL, "admin", "secret", 10, 5*time.Second)
+	if err != nil {
+		t.Fatalf("NewHarborClient: %v", err)
+	}
+	return f, client
+}
+
+func (f *fakeHarbor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, digest, ok := strings.Cut(r.URL.Path, "/manifests/"); ok && strings.HasPrefix(r.URL.Path, "/v2/") {
+		f.manifestGets[digest]++
+		var manifest struct {
+			Layers []map[string]string `json:"layers"`
+		}
+		for _, layer := range f.layers[digest] {
+			manifest.Layers = append(manifest.Layers, map[string]string{"digest": layer})
+		}
+		json.NewEncoder(w).Encode(manifest)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v2.0")
+	reply := func(v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case path == "/projects/app" && r.Method == http.MethodGet:
+		reply(harbor.Project{ProjectID: 1, Name: "app"})
+		return
+	case path == "/projects/app/immutabletagrules":
+		reply([]any{})
+		return
+	case path == "/projects/app/repositories" && r.Method == http.MethodGet:
+		var repos []harbor.Repository
+		if r.URL.Query().Get("page") == "1" {
+			for name := range f.artifacts {
+				repos = append(repos, harbor.Repository{Name: name})
+			}
+		}
+		reply(repos)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(path, "/projects/app/repositories/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	escaped, artifactPath, _ := strings.Cut(rest, "/artifacts")
+	repo, err := url.PathUnescape(escaped)
+	if err == nil {
+		repo, err = url.PathUnescape(repo)
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	repo = "app/" + repo
+	switch {
+	case artifactPath == "" && r.Method == http.MethodGet:
+		if r.URL.Query().Get("page") != "1" {
+			reply([]harbor.Artifact{})
+			return
+		}
+		reply(f.artifacts[repo])
+	case strings.HasPrefix(artifactPath, "/") && r.Method == http.MethodGet:
+		reference, _ := url.PathUnescape(strings.TrimPrefix(artifactPath, "/"))
+		for _, art := range f.artifacts[repo] {
+			if art.Digest == reference {
+				reply(art)
+				return
+			}
+			for _, tag := range art.Tags {
+				if tag.Name == reference {
+					reply(art)
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	case strings.HasPrefix(artifactPath, "/") && r.Method == http.MethodDelete:
+		digest, _ := url.PathUnescape(strings.TrimPrefix(artifactPath, "/"))
+		f.deletes[digest]++
+		if f.failDeletes[digest] {
+			http.Error(w, `{"errors":[{"message":"storage error"}]}`, http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// deleteCount returns the number of DELETE requests received for digest.
+func (f *fakeHarbor) deleteCount(digest string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deletes[digest]
+}
+
+// manifestGetCount returns the number of manifest requests received for digest.
+func (f *fakeHarbor) manifestGetCount(digest string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.manifestGets[digest]
+}
+
+// taggedArtifact returns an artifact with the given tags, pushed age ago.
+func taggedArtifact(digest string, age time.Duration, tags ...string) harbor.Artifact {
+	art := harbor.Artifact{Digest: digest, PushTime: time.Now().Add(-age), Size: 1000}
+	for _, tag := range tags {
+		art.Tags = append(art.Tags, harbor.Tag{Name: tag, PushTime: art.PushTime})
+	}
+	return art
+}
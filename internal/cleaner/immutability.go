@@ -0,0 +1,45 @@
+// File: immutability.go
+package cleaner
+
+import (
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"sync"
+)
+
+// immutability predicts which artifacts Harbor will refuse to delete because one of their
+// tags matches an active immutable tag rule. Rules are fetched once per project.
+// It is safe for concurrent use.
+type immutability struct {
+	client *harbor.HarborClient
+	mu     sync.Mutex
+	rules  map[string][]harbor.ImmutableRule
+}
+
+func newImmutability(client *harbor.HarborClient) *immutability {
+	return &immutability{client: client, rules: make(map[string][]harbor.ImmutableRule)}
+}
+
+// protects reports whether any tag of the artifact is immutable by a project rule.
+func (im *immutability) protects(projectName, repoName string, art harbor.Artifact) bool {
+	im.mu.Lock()
+	rules, ok := im.rules[projectName]
+	if !ok {
+		var err error
+		rules, err = im.client.ListImmutableRules(projectName)
+		if err != nil {
+			log.Printf("    ⚠️  Could not fetch immutable tag rules for project %s, assuming none: %v", projectName, err)
+		}
+		im.rules[projectName] = rules
+	}
+	im.mu.Unlock()
+
+	for _, rule := range rules {
+		for _, tag := range art.Tags {
+			if rule.Matches(projectName, repoName, tag.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// File: impact.go
+package cleaner
+
+import (
+	"harbor-cleaner/internal/config"
+	"sort"
+	"strings"
+)
+
+// ImpactGroup is the number of deleted artifacts whose tag falls in one category.
+type ImpactGroup struct {
+	Name  string
+	Count int
+}
+
+// DeletionsByPattern groups the deleted (or, in dry-run mode, to be deleted) artifacts of
+// an audit by the first pattern their tag matches. Groups are returned in pattern order,
+// followed by "other" for tags matching no pattern; empty groups are omitted.
+func DeletionsByPattern(audit [][]string, patterns []config.ImpactPattern) []ImpactGroup {
+	counts := make(map[string]int)
+	for i, record := range audit {
+		if i == 0 || len(record) < 2 || (record[1] != "DELETED" && record[1] != "TO BE DELETED") {
+			continue
+		}
+		counts[impactCategory(imageTag(record[0]), patterns)]++
+	}
+
+	var groups []ImpactGroup
+	for _, p := range append(patterns, config.ImpactPattern{Name: "other"}) {
+		if n, ok := counts[p.Name]; ok {
+			groups = append(groups, ImpactGroup{Name: p.Name, Count: n})
+			delete(counts, p.Name)
+		}
+	}
+	return groups
+}
+
+// impactCategory returns the name of the first pattern matching tag, or "other".
+func impactCategory(tag string, patterns []config.ImpactPattern) string {
+	for _, p := range patterns {
+		if config.MatchWildcard(p.Pattern, tag) {
+			return p.Name
+		}
+	}
+	return "other"
+}
+
+// imageTag returns the tag of an audit image reference such as "harbor.example.com/app/api:1.2.0",
+// or "" for one without a tag or by digest.
+func imageTag(image string) string {
+	if strings.Contains(image, "@") {
+		return ""
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || i < strings.LastIndex(image, "/") {
+		return ""
+	}
+	return image[i+1:]
+}
+
+// DeletionsByRepository counts the deleted (or, in dry-run mode, to be deleted) artifacts
+// of an audit per repository, most deletions first.
+func DeletionsByRepository(audit [][]string) []ImpactGroup {
+	counts := make(map[string]int)
+	for i, record := range audit {
+		if i == 0 || len(record) < 2 || (record[1] != "DELETED" && record[1] != "TO BE DELETED") {
+			continue
+		}
+		repo, _ := splitImage(record[0])
+		counts[repo]++
+	}
+
+	groups := make([]ImpactGroup, 0, len(counts))
+	for repo, n := range counts {
+		groups = append(groups, ImpactGroup{Name: repo, Count: n})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
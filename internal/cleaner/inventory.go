@@ -0,0 +1,113 @@
+// File: inventory.go
+package cleaner
+
+import (
+	"context"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"strings"
+	"time"
+)
+
+// RunInventoryStrategy deletes every tagged artifact that is not listed in the desired-state
+// inventory. Like the Kubernetes strategy, only repositories mentioned in the inventory are
+// touched, and artifacts pushed within the grace period are always kept.
+// If ctx is cancelled the current artifact is finished and the partial results are returned.
+// The audit records of each repository are written to journal as soon as it is done.
+func RunInventoryStrategy(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, inventory map[string]struct{}, projectWhitelist map[string]struct{}, journal *AuditJournal) Result {
+	dryRun := cfg.DryRun
+	q := newQuarantine(client, cfg.Harbor.Quarantine, cfg.Quiet())
+	protect := newProtection(client, &cfg.Harbor)
+	protect.indexDigests(ctx, client, cfg)
+	graceCutoff := time.Now().Add(-time.Duration(cfg.Inventory.GraceHours) * time.Hour)
+	globalCutoff := globalGraceCutoff(cfg)
+
+	// Add CSV header for the audit report
+	result := Result{Audit: [][]string{{"Image", "Status", "Notes"}}}
+	journal.start(result.Audit[0])
+
+	log.Println("⚪️ Starting cleanup based on desired-state inventory.")
+	inventoryRepos := make(map[string]struct{})
+	for image := range inventory {
+		if lastColon := strings.LastIndex(image, ":"); lastColon != -1 && repoSelected(cfg.Harbor.OnlyReposMatching, image[:lastColon]) {
+			inventoryRepos[image[:lastColon]] = struct{}{}
+		}
+	}
+	result.ReposTotal = len(inventoryRepos)
+
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	projects = skipProtectedProjects(client, cfg, projects)
+	projects = skipNativeRetentionProjects(client, cfg, projects)
+	reportRepoFilter(client, cfg, projects)
+	for _, project := range projects {
+		log.Printf("  ▶️  Processing Project: %s", project.Name)
+		for repo := range streamRepositories(client, project.Name) {
+			if _, found := inventoryRepos[repo.Name]; !found {
+				continue // Skip repos not covered by the inventory
+			}
+			if result.limitReached(cfg.Harbor.RepoLimit) {
+				return result
+			}
+
+			log.Printf("    ▶️  Processing Repository: %s", repo.Name)
+			start := time.Now()
+			artifacts, err := client.ListArtifacts(project.Name, repo.Name)
+			if err != nil {
+				log.Printf("        ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+				continue
+			}
+
+			referenced := protect.referencedDigests(artifacts)
+			deletes := newRepoDeletes(project.Name, repo.Name, cfg, journal, &result, protect.digests)
+			for _, art := range artifacts {
+				if stopped(ctx) {
+					deletes.flush(ctx, client, &result, cfg.Harbor.DeleteConcurrency)
+					return result
+				}
+				if len(art.Tags) == 0 {
+					continue
+				}
+				tagName := art.Tags[0].Name
+				fullImageName := client.BaseURL + "/" + repo.Name + ":" + tagName
+
+				var status, notes string
+				if listedTag := inventoryTag(inventory, repo.Name, art); listedTag != "" {
+					status, notes = "KEPT", "Listed in inventory as "+repo.Name+":"+listedTag
+					logDecision(cfg, "🟢", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+				} else if art.PushTime.After(graceCutoff) {
+					status, notes = "KEPT", "Not in inventory, but within grace period"
+					logDecision(cfg, "🟢", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+				} else if withinGlobalGrace(art, globalCutoff) {
+					status, notes = "KEPT", globalGraceNote
+					logDecision(cfg, "🟢", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+				} else if protected, note := protect.reason(project, repo.Name, art, referenced); protected != "" {
+					status, notes = "SKIPPED", protected
+					logDecision(cfg, "🔒", status, fullImageName)
+					q.release(project, repo.Name, art, dryRun)
+				} else {
+					status, notes = q.expire(project, repo.Name, art, tagName, dryRun, joinNotes("Not in inventory", note))
+					logDecision(cfg, "🔴", status, fullImageName)
+				}
+				deletes.record(&result, []string{fullImageName, status, notes}, art, tagName)
+			}
+			deletes.flush(ctx, client, &result, cfg.Harbor.DeleteConcurrency)
+			result.ReposProcessed++
+			result.timeRepo(project.Name, repo.Name, start)
+		}
+	}
+	return result
+}
+
+// inventoryTag returns the first tag of the artifact listed in the inventory, or "" if none is.
+func inventoryTag(inventory map[string]struct{}, repoName string, art harbor.Artifact) string {
+	for _, tag := range art.Tags {
+		if _, ok := inventory[repoName+":"+tag.Name]; ok {
+			return tag.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+// File: journal.go
+package cleaner
+
+import (
+	"encoding/csv"
+	"fmt"
+	"harbor-cleaner/internal/storage"
+	"log"
+	"os"
+	"sync"
+)
+
+// AuditJournal keeps a copy of the audit records on local disk while a run is in progress,
+// flushed after every repository, so the results survive a crash or a failed final write
+// of the audit report. A nil *AuditJournal is valid and records nothing.
+type AuditJournal struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *csv.Writer
+	columns []string // audit-columns; empty keeps the strategy's own columns
+	runID   string
+	header  []string // the strategy's own columns, set by start
+	failed  bool
+}
+
+// OpenAuditJournal creates the journal for the audit report at auditPath: next to it for a
+// local report, or in the temporary directory for a remote one.
+func OpenAuditJournal(auditPath string, columns []string, runID string) (*AuditJournal, error) {
+	var file *os.File
+	var err error
+	if storage.IsRemote(auditPath) {
+		file, err = os.CreateTemp("", "harbor-cleaner-audit-*.partial.csv")
+	} else {
+		file, err = os.Create(auditPath + ".partial")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create audit journal: %w", err)
+	}
+	return &AuditJournal{file: file, writer: csv.NewWriter(file), columns: columns, runID: runID}, nil
+}
+
+// Path returns the journal's file name.
+func (j *AuditJournal) Path() string {
+	if j == nil {
+		return ""
+	}
+	return j.file.Name()
+}
+
+// Remove closes and deletes the journal once the audit report has been written.
+func (j *AuditJournal) Remove() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.file.Close()
+	os.Remove(j.file.Name())
+}
+
+// start writes the header row for a strategy's audit records.
+func (j *AuditJournal) start(header []string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.header = header
+	if len(j.columns) > 0 {
+		header = j.columns
+	}
+	j.write([][]string{header})
+}
+
+// append writes the audit rows of one repository and syncs them to disk.
+func (j *AuditJournal) append(rows [][]string, details []auditDetail) {
+	if j == nil || len(rows) == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	records := rows
+	if len(j.columns) > 0 {
+		records = make([][]string, len(rows))
+		for i, row := range rows {
+			records[i] = auditRecord(j.columns, j.header, row, details[i], j.runID)
+		}
+	}
+	j.write(records)
+}
+
+// write must be called with j.mu held. The journal is best effort: after the first
+// failure it is abandoned with a warning rather than failing the run.
+func (j *AuditJournal) write(records [][]string) {
+	if j.failed {
+		return
+	}
+	err := j.writer.WriteAll(records)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		j.failed = true
+		log.Printf("⚠️  Failed to write audit journal %s, no further records will be journaled: %v", j.file.Name(), err)
+	}
+}
@@ -0,0 +1,269 @@
+// File: native_retention.go
+package cleaner
+
+import (
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"strings"
+)
+
+// maxNativeRetentionRules is the number of rules Harbor accepts in one retention policy.
+const maxNativeRetentionRules = 15
+
+// snapshotTagPattern selects snapshot tags. Harbor patterns are case-sensitive.
+const snapshotTagPattern = "**SNAPSHOT**"
+
+// EnsureNativeRetention translates the harbor strategy's keep-last / max-snapshots settings
+// and per-repository rules into each project's native Harbor retention policy, creating or
+// updating the policy only when it differs. Harbor then enforces the retention itself.
+func EnsureNativeRetention(client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}) error {
+	for _, setting := range unsupportedNativeSettings(cfg) {
+		log.Printf("⚠️  %s cannot be expressed as a Harbor retention policy and is ignored.", setting)
+	}
+
+	log.Println("⚪️ Ensuring native Harbor retention policies.")
+	var failed []string
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	for _, listed := range skipProtectedProjects(client, cfg, projects) {
+		log.Printf("  ▶️  Processing Project: %s", listed.Name)
+		// The project list does not include metadata, so fetch the project itself.
+		project, err := client.GetProject(listed.Name)
+		if err == nil {
+			err = ensureProjectRetention(client, cfg, project)
+		}
+		if err != nil {
+			log.Printf("    ❌ Failed to ensure retention policy for project %s: %v", listed.Name, err)
+			failed = append(failed, listed.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("retention policy not applied for %d project(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// unsupportedNativeSettings returns the configured settings that have no equivalent in a
+// Harbor retention policy.
+func unsupportedNativeSettings(cfg *config.Config) []string {
+	var settings []string
+	add := func(set bool, name string) {
+		if set {
+			settings = append(settings, name)
+		}
+	}
+	add(cfg.Harbor.MajorVersion.Enabled, "major-version retention")
+	add(cfg.Harbor.PolicyExpression != "", "policy-expression")
+	add(cfg.Harbor.KeepSinceRelease > 0, "keep-since-release")
+	add(cfg.Harbor.KeepLatestPerMinor, "keep-latest-per-minor")
+	add(cfg.Harbor.PrereleaseWindow, "prerelease-window")
+	add(cfg.GraceHours > 0, "grace-hours")
+	add(cfg.Harbor.Quarantine.Enabled, "quarantine")
+	return settings
+}
+
+// skipNativeRetentionProjects drops the projects whose native Harbor retention policy is
+// active, so the two mechanisms don't fight over them, unless
+// harbor.process-native-retention-projects is set. A project whose policy can't be read is
+// kept.
+func skipNativeRetentionProjects(client *harbor.HarborClient, cfg *config.Config, projects []harbor.Project) []harbor.Project {
+	if cfg.Harbor.ProcessNativeRetentionProjects {
+		return projects
+	}
+	var selected []harbor.Project
+	for _, listed := range projects {
+		// The project list does not include metadata, so fetch the project itself.
+		project, err := client.GetProject(listed.Name)
+		managed := false
+		if err == nil {
+			managed, err = nativeRetentionActive(client, project)
+		}
+		if err != nil {
+			log.Printf("    ⚠️  Could not check the retention policy of project %s, processing it: %v", listed.Name, err)
+		}
+		if managed {
+			log.Printf("    ⏭️  Skipping project %s (Managed by native Harbor retention).", listed.Name)
+			continue
+		}
+		selected = append(selected, listed)
+	}
+	return selected
+}
+
+// nativeRetentionActive reports whether the project, fetched with its metadata, has a
+// retention policy with at least one enabled rule.
+func nativeRetentionActive(client *harbor.HarborClient, project harbor.Project) (bool, error) {
+	id, ok := project.RetentionID()
+	if !ok {
+		return false, nil
+	}
+	policy, err := client.GetRetentionPolicy(id)
+	if err != nil {
+		return false, err
+	}
+	for _, rule := range policy.Rules {
+		if !rule.Disabled {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ensureProjectRetention creates or updates one project's retention policy.
+func ensureProjectRetention(client *harbor.HarborClient, cfg *config.Config, project harbor.Project) error {
+	desired, err := nativeRetentionPolicy(&cfg.Harbor, project)
+	if err != nil {
+		return err
+	}
+	for _, r := range desired.Rules {
+		count := "latest " + fmt.Sprint(r.Params["latestPushedK"])
+		if r.Template == "always" {
+			count = "all"
+		}
+		log.Printf("    📐 Retain %s of tags %s %s in repositories %s %s", count,
+			r.TagSelectors[0].Decoration, r.TagSelectors[0].Pattern,
+			r.ScopeSelectors["repository"][0].Decoration, r.ScopeSelectors["repository"][0].Pattern)
+	}
+
+	id, exists := project.RetentionID()
+	if !exists {
+		if cfg.DryRun {
+			log.Printf("    📝 Would create retention policy with %d rule(s).", len(desired.Rules))
+			return nil
+		}
+		if err := client.CreateRetentionPolicy(desired); err != nil {
+			return err
+		}
+		log.Printf("    ✅ Created retention policy with %d rule(s).", len(desired.Rules))
+		return nil
+	}
+
+	current, err := client.GetRetentionPolicy(id)
+	if err != nil {
+		return err
+	}
+	if current.SameAs(desired) {
+		log.Printf("    🟢 Retention policy %d is already up to date.", id)
+		return nil
+	}
+	if cfg.DryRun {
+		log.Printf("    📝 Would update retention policy %d with %d rule(s).", id, len(desired.Rules))
+		return nil
+	}
+	if err := client.UpdateRetentionPolicy(id, desired); err != nil {
+		return err
+	}
+	log.Printf("    ✅ Updated retention policy %d with %d rule(s).", id, len(desired.Rules))
+	return nil
+}
+
+// nativeRetentionPolicy builds the desired policy for a project. Every per-repository rule
+// whose pattern matches the project becomes a rule for its repositories, and the global
+// settings apply to all other repositories. Releases and snapshots are retained separately,
+// as with snapshot-window "independent". Alias and snapshot-exclude tags are always retained
+// in every repository. Harbor keeps a tag if any rule retains it, so overlapping
+// per-repository rules combine and the larger count wins.
+func nativeRetentionPolicy(h *config.HarborConfig, project harbor.Project) (harbor.RetentionPolicy, error) {
+	var rules []harbor.RetentionRule
+	if len(h.AliasTags) > 0 || len(h.SnapshotExcludeTags) > 0 {
+		// Alias tags are exact names, snapshot-exclude tags wildcards.
+		tags := append(append([]string(nil), h.AliasTags...), h.SnapshotExcludeTags...)
+		rules = append(rules, protectedTagsRule(tags, harbor.RuleSelector{Kind: "doublestar", Decoration: "repoMatches", Pattern: "**"}))
+	}
+	var rulePatterns []string
+	for _, rule := range h.Rules {
+		projectPattern, repoPattern, ok := strings.Cut(rule.Pattern, "/")
+		if !ok || !config.MatchWildcard(projectPattern, project.Name) {
+			continue
+		}
+		keepLastN, maxSnapshots := h.KeepLastN, h.MaxSnapshots
+		if rule.KeepLastN != nil {
+			keepLastN = *rule.KeepLastN
+		}
+		if rule.MaxSnapshots != nil {
+			maxSnapshots = *rule.MaxSnapshots
+		}
+		pattern := doublestarPattern(repoPattern)
+		rulePatterns = append(rulePatterns, pattern)
+		scope := harbor.RuleSelector{Kind: "doublestar", Decoration: "repoMatches", Pattern: pattern}
+		if len(rule.ProtectedTags) > 0 {
+			rules = append(rules, protectedTagsRule(rule.ProtectedTags, scope))
+			continue
+		}
+		rules = append(rules, retainRules(keepLastN, maxSnapshots, scope)...)
+	}
+
+	defaultScope := harbor.RuleSelector{Kind: "doublestar", Decoration: "repoMatches", Pattern: "**"}
+	if len(rulePatterns) > 0 {
+		defaultScope = harbor.RuleSelector{Kind: "doublestar", Decoration: "repoExcludes", Pattern: "{" + strings.Join(rulePatterns, ",") + "}"}
+	}
+	rules = append(rules, retainRules(h.KeepLastN, h.MaxSnapshots, defaultScope)...)
+
+	if len(rules) > maxNativeRetentionRules {
+		return harbor.RetentionPolicy{}, fmt.Errorf("%d retention rules needed, but Harbor allows at most %d per project", len(rules), maxNativeRetentionRules)
+	}
+	return harbor.RetentionPolicy{
+		Algorithm: "or",
+		Rules:     rules,
+		Trigger: &harbor.RetentionTrigger{
+			Kind:     "Schedule",
+			Settings: map[string]interface{}{"cron": h.NativeRetention.Schedule},
+		},
+		Scope: &harbor.RetentionScope{Level: "project", Ref: project.ProjectID},
+	}, nil
+}
+
+// retainRules returns the Harbor rules keeping the newest keepLastN releases and the newest
+// maxSnapshots snapshots of the repositories selected by scope. A count of 0 needs no rule.
+func retainRules(keepLastN, maxSnapshots int, scope harbor.RuleSelector) []harbor.RetentionRule {
+	var rules []harbor.RetentionRule
+	add := func(count int, decoration string) {
+		if count <= 0 {
+			return
+		}
+		rules = append(rules, harbor.RetentionRule{
+			Action:         "retain",
+			Template:       "latestPushedK",
+			Params:         map[string]interface{}{"latestPushedK": count},
+			TagSelectors:   []harbor.RuleSelector{{Kind: "doublestar", Decoration: decoration, Pattern: snapshotTagPattern}},
+			ScopeSelectors: map[string][]harbor.RuleSelector{"repository": {scope}},
+		})
+	}
+	add(keepLastN, "excludes")
+	add(maxSnapshots, "matches")
+	return rules
+}
+
+// protectedTagsRule returns the Harbor rule that always retains the given tags in the
+// repositories selected by scope; with no other rule matching, everything else expires.
+func protectedTagsRule(tags []string, scope harbor.RuleSelector) harbor.RetentionRule {
+	patterns := make([]string, len(tags))
+	for i, t := range tags {
+		patterns[i] = doublestarPattern(t)
+	}
+	return harbor.RetentionRule{
+		Action:         "retain",
+		Template:       "always",
+		Params:         map[string]interface{}{},
+		TagSelectors:   []harbor.RuleSelector{{Kind: "doublestar", Decoration: "matches", Pattern: "{" + strings.Join(patterns, ",") + "}"}},
+		ScopeSelectors: map[string][]harbor.RuleSelector{"repository": {scope}},
+	}
+}
+
+// doublestarPattern converts a wildcard pattern to Harbor's doublestar syntax, where only
+// "**" matches across '/' like the tool's '*' does.
+func doublestarPattern(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '*' {
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+			b.WriteString("**")
+			continue
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
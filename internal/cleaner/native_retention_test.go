@@ -0,0 +1,113 @@
+package cleaner
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+)
+
+func TestDoublestarPattern(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"api":        "api",
+		"*":          "**",
+		"**":         "**",
+		"team/*":     "team/**",
+		"*-SNAPSHOT": "**-SNAPSHOT",
+		"a***b?c":    "a**b?c",
+		"1.*.*":      "1.**.**",
+		"":           "",
+	} {
+		if got := doublestarPattern(pattern); got != want {
+			t.Errorf("doublestarPattern(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+// describeRule summarises a Harbor rule as "<count> <tag selector> in <repository selector>".
+func describeRule(r harbor.RetentionRule) string {
+	count := "always"
+	if r.Template == "latestPushedK" {
+		count = fmt.Sprintf("latest %v", r.Params["latestPushedK"])
+	}
+	tag, repo := r.TagSelectors[0], r.ScopeSelectors["repository"][0]
+	return fmt.Sprintf("%s %s %s in %s %s", count, tag.Decoration, tag.Pattern, repo.Decoration, repo.Pattern)
+}
+
+func TestNativeRetentionPolicy(t *testing.T) {
+	five, zero := 5, 0
+	h := &config.HarborConfig{
+		KeepLastN:           10,
+		MaxSnapshots:        3,
+		AliasTags:           []string{"latest", "stable"},
+		SnapshotExcludeTags: []string{"dev-*"},
+		Rules: []config.RetentionRule{
+			{Pattern: "app/api*", KeepLastN: &five, MaxSnapshots: &zero},
+			{Pattern: "app/base", ProtectedTags: []string{"lts", "1.*"}},
+			{Pattern: "other/*", KeepLastN: &five},
+			{Pattern: "*/web"},
+		},
+		NativeRetention: config.NativeRetentionConfig{Schedule: "0 0 2 * * *"},
+	}
+
+	policy, err := nativeRetentionPolicy(h, harbor.Project{ProjectID: 7, Name: "app"})
+	if err != nil {
+		t.Fatalf("nativeRetentionPolicy: %v", err)
+	}
+	var got []string
+	for _, r := range policy.Rules {
+		got = append(got, describeRule(r))
+	}
+	want := []string{
+		"always matches {latest,stable,dev-**} in repoMatches **",
+		"latest 5 excludes **SNAPSHOT** in repoMatches api**",
+		"always matches {lts,1.**} in repoMatches base",
+		"latest 10 excludes **SNAPSHOT** in repoMatches web",
+		"latest 3 matches **SNAPSHOT** in repoMatches web",
+		"latest 10 excludes **SNAPSHOT** in repoExcludes {api**,base,web}",
+		"latest 3 matches **SNAPSHOT** in repoExcludes {api**,base,web}",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rules:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if policy.Algorithm != "or" || policy.Scope.Ref != 7 || policy.Trigger.Settings["cron"] != "0 0 2 * * *" {
+		t.Errorf("policy = %+v, want algorithm or, scope 7 and the configured schedule", policy)
+	}
+
+	// Without alias or snapshot-exclude tags, no always rule is added.
+	policy, err = nativeRetentionPolicy(&config.HarborConfig{KeepLastN: 10}, harbor.Project{ProjectID: 7, Name: "app"})
+	if err != nil || len(policy.Rules) != 1 || describeRule(policy.Rules[0]) != "latest 10 excludes **SNAPSHOT** in repoMatches **" {
+		t.Errorf("policy without rules = %+v, %v", policy.Rules, err)
+	}
+
+	// More rules than Harbor accepts is an error.
+	many := &config.HarborConfig{KeepLastN: 10, MaxSnapshots: 3}
+	for i := 0; i < maxNativeRetentionRules/2; i++ {
+		many.Rules = append(many.Rules, config.RetentionRule{Pattern: fmt.Sprintf("app/r%d", i)})
+	}
+	if _, err := nativeRetentionPolicy(many, harbor.Project{Name: "app"}); err == nil {
+		t.Errorf("nativeRetentionPolicy with %d rules succeeded, want an error", 2*len(many.Rules)+2)
+	}
+}
+
+func TestUnsupportedNativeSettings(t *testing.T) {
+	if got := unsupportedNativeSettings(&config.Config{Harbor: config.HarborConfig{KeepLastN: 10, AliasTags: []string{"latest"}}}); len(got) != 0 {
+		t.Errorf("unsupportedNativeSettings = %v, want none", got)
+	}
+
+	cfg := &config.Config{GraceHours: 6, Harbor: config.HarborConfig{
+		MajorVersion:       config.MajorVersionConfig{Enabled: true},
+		PolicyExpression:   "true",
+		KeepSinceRelease:   2,
+		KeepLatestPerMinor: true,
+		PrereleaseWindow:   true,
+		Quarantine:         config.QuarantineConfig{Enabled: true},
+	}}
+	want := []string{"major-version retention", "policy-expression", "keep-since-release", "keep-latest-per-minor", "prerelease-window", "grace-hours", "quarantine"}
+	if got := unsupportedNativeSettings(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("unsupportedNativeSettings = %v, want %v", got, want)
+	}
+}
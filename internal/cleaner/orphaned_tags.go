@@ -0,0 +1,92 @@
+// File: orphaned_tags.go
+package cleaner
+
+import (
+	"context"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"time"
+)
+
+// RunOrphanedTagsStrategy finds tags whose artifact Harbor still lists but the registry can
+// no longer serve, which makes pulls of those tags fail, and removes the tags. Only the
+// tags are removed; the artifact records stay for Harbor's own cleanup. Healthy artifacts
+// are not audited, so the report lists only the orphaned tags. The run aborts if the
+// registry API does not answer under the Harbor URL, as every manifest would look missing.
+// If ctx is cancelled the current repository is finished and the partial results are returned.
+func RunOrphanedTagsStrategy(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}) Result {
+	result := Result{Audit: [][]string{{"Image", "Status", "Notes"}}}
+
+	log.Println("⚪️ Checking tagged artifacts against the registry for orphaned tags.")
+	if err := client.CheckRegistry(); err != nil {
+		log.Fatalf("❌ The registry API is not reachable under %s, no tag is removed: %v", client.BaseURL, err)
+	}
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	projects = skipProtectedProjects(client, cfg, projects)
+	for _, project := range projects {
+		result.ReposTotal += project.RepoCount
+	}
+	if matched := reportRepoFilter(client, cfg, projects); matched >= 0 {
+		result.ReposTotal = matched
+	}
+	for _, project := range projects {
+		log.Printf("  ▶️  Processing Project: %s", project.Name)
+		for repo := range streamRepositories(client, project.Name) {
+			if !repoSelected(cfg.Harbor.OnlyReposMatching, repo.Name) {
+				continue
+			}
+			if stopped(ctx) || result.limitReached(cfg.Harbor.RepoLimit) {
+				return result
+			}
+			log.Printf("    ▶️  Processing Repository: %s", repo.Name)
+			start := time.Now()
+			// Each tag is checked on its own, so the artifacts are processed page by page.
+			err := client.EachArtifactPage(project.Name, repo.Name, func(artifacts []harbor.Artifact) error {
+				for _, art := range artifacts {
+					if len(art.Tags) == 0 {
+						continue
+					}
+					exists, err := client.ManifestExists(repo.Name, art.Digest)
+					if err != nil {
+						log.Printf("        ⚠️  Could not check artifact %s in the registry, leaving its tags: %v", art.Digest, err)
+						continue
+					}
+					if !exists {
+						removeOrphanedTags(client, project.Name, repo.Name, art, cfg.DryRun, &result)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("        ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+				continue
+			}
+			result.ReposProcessed++
+			result.timeRepo(project.Name, repo.Name, start)
+		}
+	}
+	return result
+}
+
+// removeOrphanedTags removes (or, in dry-run mode, reports) every tag of an artifact whose
+// manifest is missing from the registry, recording each tag in result.
+func removeOrphanedTags(client *harbor.HarborClient, projectName, repoName string, art harbor.Artifact, dryRun bool, result *Result) {
+	notes := "Orphaned tag: manifest " + art.Digest + " is missing from the registry"
+	for _, tag := range art.Tags {
+		image := client.BaseURL + "/" + repoName + ":" + tag.Name
+		status := "TO BE DELETED"
+		if !dryRun {
+			if err := client.DeleteTag(projectName, repoName, art.Digest, tag.Name); err != nil {
+				log.Printf("            ❌ FAILED to remove orphaned tag %s: %v", tag.Name, err)
+				status = "DELETE_FAILED"
+			} else {
+				status = "DELETED"
+			}
+		}
+		log.Printf("        👻 %s: %s (manifest %s missing)", status, image, art.Digest)
+		result.Audit = append(result.Audit, []string{image, status, notes})
+		result.details = append(result.details, newAuditDetail(art))
+		result.count(status)
+	}
+}
@@ -0,0 +1,52 @@
+// File: project_metadata.go
+package cleaner
+
+import (
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"sort"
+	"strings"
+)
+
+// skipProtectedProjects drops the projects whose Harbor metadata has one of the key/value
+// pairs of harbor.protected-project-metadata, so the protection travels with the project.
+// A project whose metadata can't be read is skipped too, as it might be protected.
+func skipProtectedProjects(client *harbor.HarborClient, cfg *config.Config, projects []harbor.Project) []harbor.Project {
+	if len(cfg.Harbor.ProtectedProjectMetadata) == 0 {
+		return projects
+	}
+	var selected []harbor.Project
+	for _, listed := range projects {
+		// The project list does not include metadata, so fetch the project itself.
+		project, err := client.GetProject(listed.Name)
+		if err != nil {
+			log.Printf("    ⚠️  Skipping project %s: could not read its metadata to check protected-project-metadata: %v", listed.Name, err)
+			continue
+		}
+		if reason := protectedByMetadata(cfg, project); reason != "" {
+			log.Printf("    ⏭️  Skipping project %s (%s).", listed.Name, reason)
+			continue
+		}
+		selected = append(selected, listed)
+	}
+	return selected
+}
+
+// protectedByMetadata returns the skip reason if the project's metadata matches a pair of
+// harbor.protected-project-metadata, or "". Values are compared case-insensitively, so
+// "true" matches "True".
+func protectedByMetadata(cfg *config.Config, project harbor.Project) string {
+	keys := make([]string, 0, len(cfg.Harbor.ProtectedProjectMetadata))
+	for key := range cfg.Harbor.ProtectedProjectMetadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		want := cfg.Harbor.ProtectedProjectMetadata[key]
+		if value, ok := project.Metadata[key]; ok && strings.EqualFold(value, want) {
+			return "Protected by project metadata " + key + "=" + value
+		}
+	}
+	return ""
+}
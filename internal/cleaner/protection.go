@@ -0,0 +1,189 @@
+// File: protection.go
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// signatureTagPattern matches the tags cosign uses for signatures, attestations and SBOMs
+// stored next to the image they belong to.
+var signatureTagPattern = regexp.MustCompile(`^sha256-[0-9a-f]{64}\.(sig|att|sbom)$`)
+
+// protection decides whether an expired artifact must be left alone. Immutable tags and
+// min-size-bytes are always honored; with the preflight enabled, signatures, artifacts referenced by an image
+// index and tags an "always retain" rule of the project's Harbor retention policy selects
+// are skipped as well, instead of attempting deletes Harbor would refuse or undo.
+// It is safe for concurrent use.
+type protection struct {
+	client    *harbor.HarborClient
+	im        *immutability
+	preflight bool
+	minSize   int64
+	digests   *sharedDigests // Set by indexDigests with cross-repo-digests
+	own       []ownImage
+	mu        sync.Mutex
+	retention map[string][]harbor.RetentionRule // Keyed by project name
+}
+
+func newProtection(client *harbor.HarborClient, cfg *config.HarborConfig) *protection {
+	p := &protection{
+		client:    client,
+		im:        newImmutability(client),
+		preflight: cfg.ProtectionPreflight,
+		minSize:   cfg.MinSizeBytes,
+		retention: make(map[string][]harbor.RetentionRule),
+	}
+	for _, ref := range cfg.OwnImages {
+		p.own = append(p.own, parseOwnImage(ref))
+	}
+	return p
+}
+
+// ownImage is a reference to the cleaner's own image, without the registry host.
+type ownImage struct {
+	repo, tag, digest string
+}
+
+// parseOwnImage splits an image reference such as "harbor.example.com/tools/cleaner:1.2" or
+// ".../tools/cleaner@sha256:..." into repository, tag and digest.
+func parseOwnImage(ref string) ownImage {
+	var img ownImage
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		ref, img.digest = name, digest
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, img.tag = ref[:i], ref[i+1:]
+	}
+	if host, rest, ok := strings.Cut(ref, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		ref = rest
+	}
+	img.repo = ref
+	return img
+}
+
+// isOwnImage reports whether art is one of the cleaner's own images.
+func (p *protection) isOwnImage(repoName string, art harbor.Artifact) bool {
+	for _, img := range p.own {
+		if img.repo != repoName {
+			continue
+		}
+		if img.digest == art.Digest {
+			return true
+		}
+		for _, tag := range art.Tags {
+			if img.tag != "" && tag.Name == img.tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// indexDigests enables the cross-repo-digests check if configured. It lists every
+// repository of the registry, so it is done once per run before cleaning starts.
+func (p *protection) indexDigests(ctx context.Context, client *harbor.HarborClient, cfg *config.Config) {
+	if cfg.Harbor.CrossRepoDigests {
+		p.digests = indexSharedDigests(ctx, client, cfg)
+	}
+}
+
+// referencedDigests returns the digests of the artifacts referenced by an image index in the
+// repository, or nil when the preflight is disabled.
+func (p *protection) referencedDigests(artifacts []harbor.Artifact) map[string]struct{} {
+	if !p.preflight {
+		return nil
+	}
+	referenced := make(map[string]struct{})
+	for _, art := range artifacts {
+		for _, ref := range art.References {
+			referenced[ref.ChildDigest] = struct{}{}
+		}
+	}
+	return referenced
+}
+
+// reason is called for an expired artifact before it is deleted. It returns the audit note
+// explaining why the artifact is protected, or "" if it is not, and for an artifact that
+// may be deleted, a note to add to its audit record. referenced is the repository's
+// referencedDigests. With cross-repo-digests, an artifact holding the surviving copy of a
+// digest shared with other repositories, the one pushed last, is protected.
+func (p *protection) reason(project harbor.Project, repoName string, art harbor.Artifact, referenced map[string]struct{}) (string, string) {
+	if protected := p.protectedReason(project, repoName, art, referenced); protected != "" || p.digests == nil {
+		return protected, ""
+	}
+	shared, others := p.digests.holders(repoName, art.Digest)
+	switch {
+	case !shared:
+		return "", ""
+	case p.digests.survivor(repoName, art.Digest):
+		return "Skipped: newest copy of a digest shared with other repositories", ""
+	default:
+		return "", "Digest also present elsewhere (" + describeLocations(others) + ")"
+	}
+}
+
+// protectedReason applies the own image, immutability, size and preflight checks of reason.
+func (p *protection) protectedReason(project harbor.Project, repoName string, art harbor.Artifact, referenced map[string]struct{}) string {
+	if p.isOwnImage(repoName, art) {
+		return "Protected: cleaner's own image"
+	}
+	if p.im.protects(project.Name, repoName, art) {
+		return "Skipped: immutable by project rule"
+	}
+	if art.Size < p.minSize {
+		return fmt.Sprintf("Skipped: Below size threshold (%d < %d bytes)", art.Size, p.minSize)
+	}
+	if !p.preflight {
+		return ""
+	}
+	for _, tag := range art.Tags {
+		if signatureTagPattern.MatchString(tag.Name) {
+			return "Skipped: protected (signature): tag " + tag.Name + " is a signature or attestation"
+		}
+	}
+	if _, ok := referenced[art.Digest]; ok {
+		return "Skipped: protected (referenced): artifact is referenced by an image index"
+	}
+	for _, rule := range p.retentionRules(project) {
+		for _, tag := range art.Tags {
+			if rule.AlwaysRetains(project.Name, repoName, tag.Name) {
+				return "Skipped: protected (retention): tag " + tag.Name + " is always retained by the project's retention policy"
+			}
+		}
+	}
+	return ""
+}
+
+// retentionRules returns the rules of the project's Harbor retention policy, fetched once
+// per project. Projects without a policy have none.
+func (p *protection) retentionRules(project harbor.Project) []harbor.RetentionRule {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rules, ok := p.retention[project.Name]; ok {
+		return rules
+	}
+
+	var rules []harbor.RetentionRule
+	// The project list does not include metadata, so fetch the project itself.
+	full, err := p.client.GetProject(project.Name)
+	if err == nil {
+		if id, ok := full.RetentionID(); ok {
+			var policy harbor.RetentionPolicy
+			if policy, err = p.client.GetRetentionPolicy(id); err == nil {
+				rules = policy.Rules
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("    ⚠️  Could not fetch the retention policy of project %s, assuming none: %v", project.Name, err)
+	}
+	p.retention[project.Name] = rules
+	return rules
+}
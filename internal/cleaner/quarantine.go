@@ -0,0 +1,170 @@
+// File: quarantine.go
+package cleaner
+
+import (
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quarantineDateLayout is the date suffix appended to the quarantine label prefix,
+// so the label name itself records when the artifact was quarantined.
+const quarantineDateLayout = "20060102"
+
+// quarantine decides whether expired artifacts are deleted immediately or labelled first.
+// Artifacts are labelled "<prefix>-YYYYMMDD" with the day they were quarantined, so the
+// date is kept in Harbor and survives runs that keep no local state. The label of the day
+// is created lazily per project and cached for the rest of the run. It is safe for
+// concurrent use.
+type quarantine struct {
+	client *harbor.HarborClient
+	cfg    config.QuarantineConfig
+	now    time.Time
+	mu     sync.Mutex
+	labels map[int]harbor.Label // Today's label, keyed by project ID
+	quiet  bool                 // Leave out the per-artifact success lines
+}
+
+func newQuarantine(client *harbor.HarborClient, cfg config.QuarantineConfig, quiet bool) *quarantine {
+	return &quarantine{
+		client: client,
+		cfg:    cfg,
+		quiet:  quiet,
+		now:    time.Now(),
+		labels: make(map[int]harbor.Label),
+	}
+}
+
+// labelDate returns the date a quarantine label encodes, or false if l is not one.
+func (q *quarantine) labelDate(l harbor.Label) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(l.Name, q.cfg.LabelPrefix+"-")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(quarantineDateLayout, suffix, time.Local)
+	return t, err == nil
+}
+
+// quarantinedSince returns the date of the earliest quarantine label on the artifact.
+func (q *quarantine) quarantinedSince(art harbor.Artifact) (time.Time, bool) {
+	var since time.Time
+	for _, l := range art.Labels {
+		t, ok := q.labelDate(l)
+		if ok && (since.IsZero() || t.Before(since)) {
+			since = t
+		}
+	}
+	return since, !since.IsZero()
+}
+
+// expire handles an artifact that the retention rules marked for removal. With quarantine
+// disabled it is due for deletion right away; otherwise it labels the artifact on the first
+// run and it is due once it has been quarantined for longer than the grace period.
+// It returns the audit status and the audit notes; "TO BE DELETED" means the caller deletes it.
+func (q *quarantine) expire(project harbor.Project, repoName string, art harbor.Artifact, tagName string, dryRun bool, reason string) (string, string) {
+	if !q.cfg.Enabled {
+		return "TO BE DELETED", reason
+	}
+
+	if since, ok := q.quarantinedSince(art); ok {
+		deadline := since.AddDate(0, 0, q.cfg.GraceDays)
+		if !q.now.Before(deadline) {
+			return "TO BE DELETED", fmt.Sprintf("%s; quarantined since %s, grace period elapsed", reason, since.Format("2006-01-02"))
+		}
+		return "QUARANTINED", fmt.Sprintf("%s; quarantined since %s, deletion after %s", reason, since.Format("2006-01-02"), deadline.Format("2006-01-02"))
+	}
+
+	if dryRun {
+		return "TO BE QUARANTINED", reason
+	}
+	label, err := q.todayLabel(project)
+	if err == nil {
+		err = q.client.AddArtifactLabel(project.Name, repoName, art.Digest, label.ID)
+	}
+	if err != nil {
+		log.Printf("            ❌ FAILED to quarantine artifact %s: %v", tagName, err)
+		return "QUARANTINE_FAILED", reason
+	}
+	if !q.quiet {
+		log.Printf("            🏷️  Quarantined artifact %s with label %s.", tagName, label.Name)
+	}
+	return "QUARANTINED", fmt.Sprintf("%s; quarantined, deletion after %s", reason, q.now.AddDate(0, 0, q.cfg.GraceDays).Format("2006-01-02"))
+}
+
+// release removes the quarantine labels from an artifact that is kept, for whatever
+// reason, undoing an earlier quarantine.
+func (q *quarantine) release(project harbor.Project, repoName string, art harbor.Artifact, dryRun bool) {
+	if !q.cfg.Enabled {
+		return
+	}
+	for _, l := range art.Labels {
+		if _, ok := q.labelDate(l); !ok {
+			continue
+		}
+		if dryRun {
+			if !q.quiet {
+				log.Printf("            🏷️  Would release artifact %s from quarantine (label %s).", art.Digest, l.Name)
+			}
+			continue
+		}
+		if err := q.client.RemoveArtifactLabel(project.Name, repoName, art.Digest, l.ID); err != nil {
+			log.Printf("            ❌ FAILED to release artifact %s from quarantine: %v", art.Digest, err)
+			continue
+		}
+		if !q.quiet {
+			log.Printf("            🏷️  Released artifact %s from quarantine (label %s).", art.Digest, l.Name)
+		}
+	}
+}
+
+// todayLabel returns the project's quarantine label for the current run date, creating it
+// if needed. Creating it also deletes the project's quarantine labels dated more than twice
+// the grace period ago, so they don't pile up: any artifact still carrying one has been due
+// for a whole grace period without a run processing it, and only loses its quarantine.
+func (q *quarantine) todayLabel(project harbor.Project) (harbor.Label, error) {
+	name := q.cfg.LabelPrefix + "-" + q.now.Format(quarantineDateLayout)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if l, ok := q.labels[project.ProjectID]; ok {
+		return l, nil
+	}
+
+	labels, err := q.client.ListProjectLabels(project.ProjectID)
+	if err != nil {
+		return harbor.Label{}, fmt.Errorf("failed to list labels for project %s: %w", project.Name, err)
+	}
+	for _, l := range labels {
+		if l.Name == name {
+			q.labels[project.ProjectID] = l
+			return l, nil
+		}
+	}
+
+	l, err := q.client.CreateProjectLabel(project.ProjectID, name, "Artifacts quarantined by harbor-cleaner on "+q.now.Format("2006-01-02"))
+	if err != nil {
+		return harbor.Label{}, fmt.Errorf("failed to create label %s in project %s: %w", name, project.Name, err)
+	}
+	q.labels[project.ProjectID] = l
+	q.prune(project, labels)
+	return l, nil
+}
+
+// prune deletes the quarantine labels among labels, those of project, that are dated more
+// than twice the grace period ago.
+func (q *quarantine) prune(project harbor.Project, labels []harbor.Label) {
+	cutoff := q.now.AddDate(0, 0, -2*q.cfg.GraceDays)
+	for _, l := range labels {
+		if t, ok := q.labelDate(l); !ok || !t.Before(cutoff) {
+			continue
+		}
+		if err := q.client.DeleteLabel(l.ID); err != nil {
+			log.Printf("            ⚠️  Could not delete old quarantine label %s in project %s: %v", l.Name, project.Name, err)
+			continue
+		}
+		log.Printf("            🏷️  Deleted old quarantine label %s in project %s.", l.Name, project.Name)
+	}
+}
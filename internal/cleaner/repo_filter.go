@@ -0,0 +1,67 @@
+// File: repo_filter.go
+package cleaner
+
+import (
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"strings"
+)
+
+// onlyMatching returns the repositories whose full name matches one of patterns, or all of
+// them when there are no patterns.
+func onlyMatching(repos []harbor.Repository, patterns []string) []harbor.Repository {
+	if len(patterns) == 0 {
+		return repos
+	}
+	var matched []harbor.Repository
+	for _, repo := range repos {
+		if repoSelected(patterns, repo.Name) {
+			matched = append(matched, repo)
+		}
+	}
+	return matched
+}
+
+// repoSelected reports whether repoName matches one of patterns, or true when there are none.
+func repoSelected(patterns []string, repoName string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if config.MatchWildcard(pattern, repoName) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportRepoFilter logs the only-repos-matching patterns. In dry-run mode it also lists the
+// repositories of projects up front and names those that match, so the patterns can be
+// checked before any decision is shown; other runs stream the repositories as usual. It
+// returns the number of matching repositories, or -1 when they weren't listed.
+func reportRepoFilter(client *harbor.HarborClient, cfg *config.Config, projects []harbor.Project) int {
+	patterns := cfg.Harbor.OnlyReposMatching
+	if len(patterns) == 0 {
+		return -1
+	}
+	if !cfg.DryRun {
+		log.Printf("🎯 Only processing repositories matching %s.", strings.Join(patterns, ", "))
+		return -1
+	}
+	var names []string
+	for _, project := range projects {
+		repos, ok := listRepositories(client, project.Name, cfg.ContinueOnError)
+		if !ok {
+			continue
+		}
+		for _, repo := range onlyMatching(repos, patterns) {
+			names = append(names, repo.Name)
+		}
+	}
+	log.Printf("🎯 only-repos-matching %s matched %d repositories.", strings.Join(patterns, ", "), len(names))
+	for _, name := range names {
+		log.Printf("    - %s", name)
+	}
+	return len(names)
+}
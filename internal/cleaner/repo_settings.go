@@ -0,0 +1,64 @@
+// File: repo_settings.go
+package cleaner
+
+import (
+	"fmt"
+	"harbor-cleaner/internal/harbor"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// repoSettingPattern matches a retention setting the owners of a repository wrote into its
+// description or a label name, such as "keep-last=15" or "max-snapshots: 2".
+var repoSettingPattern = regexp.MustCompile(`(?i)\b(keep-last|max-snapshots)\s*[=:]\s*(\d+)\b`)
+
+// applyRepoSettings replaces keep-last and max-snapshots with the values set on the
+// repository for harbor.repo-settings: in its description, or else in the labels of its
+// newest artifact that has any. Artifacts must be newest first. It returns the source for
+// logRules, which is unchanged if the repository sets nothing.
+func (r *repoRetention) applyRepoSettings(repo harbor.Repository, artifacts []harbor.Artifact, source string) string {
+	settings := repoSettings(repo.Description)
+	from := "repository description"
+	if len(settings) == 0 {
+		for _, art := range artifacts {
+			var names []string
+			for _, l := range art.Labels {
+				names = append(names, l.Name)
+			}
+			if settings = repoSettings(strings.Join(names, "\n")); len(settings) > 0 {
+				from = "labels of artifact " + shortDigest(art.Digest)
+				break
+			}
+		}
+	}
+	if len(settings) == 0 {
+		return source
+	}
+
+	var applied []string
+	if n, ok := settings["keep-last"]; ok {
+		r.keepLastN = n
+		applied = append(applied, fmt.Sprintf("keep-last=%d", n))
+	}
+	if n, ok := settings["max-snapshots"]; ok {
+		r.maxSnapshots = n
+		applied = append(applied, fmt.Sprintf("max-snapshots=%d", n))
+	}
+	return fmt.Sprintf("%s: %s", from, strings.Join(applied, ", "))
+}
+
+// repoSettings returns the settings found in text; the first value of each setting wins.
+func repoSettings(text string) map[string]int {
+	settings := make(map[string]int)
+	for _, m := range repoSettingPattern.FindAllStringSubmatch(text, -1) {
+		key := strings.ToLower(m[1])
+		if _, ok := settings[key]; ok {
+			continue
+		}
+		if n, err := strconv.Atoi(m[2]); err == nil {
+			settings[key] = n
+		}
+	}
+	return settings
+}
@@ -0,0 +1,393 @@
+// File: retention.go
+package cleaner
+
+import (
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// retentionPolicy holds the run-wide retention settings of the harbor strategy,
+// with any patterns compiled once up front.
+type retentionPolicy struct {
+	cfg          *config.HarborConfig
+	majorPattern *regexp.Regexp
+	expression   *vm.Program
+	sizeTiers    map[string]repoSizeTier // By repository name, filled by rankRepoSizes
+}
+
+// policyEnv is the artifact data available to policy-expression.
+type policyEnv struct {
+	Project    string        `expr:"project"`
+	Repo       string        `expr:"repo"`
+	Tag        string        `expr:"tag"`
+	Tags       []string      `expr:"tags"`
+	Labels     []string      `expr:"labels"`
+	PushTime   time.Time     `expr:"pushTime"`
+	CreateTime time.Time     `expr:"createTime"` // Image build time, or pushTime if unknown
+	Age        time.Duration `expr:"age"`        // Since the sort-key time
+	AgeDays    float64       `expr:"ageDays"`
+	Size       int64         `expr:"size"`
+	Index      int           `expr:"index"` // Position in the repository, newest first
+	Snapshot   bool          `expr:"snapshot"`
+}
+
+// sortTime returns the time the artifacts of a repository are ordered by, per sort-key.
+func (p *retentionPolicy) sortTime(art harbor.Artifact) time.Time {
+	if p.cfg.SortKey == "create_time" {
+		return art.CreateTime()
+	}
+	return art.PushTime
+}
+
+func newRetentionPolicy(cfg *config.HarborConfig) (*retentionPolicy, error) {
+	p := &retentionPolicy{cfg: cfg}
+	switch cfg.SnapshotWindow {
+	case "", "keep-last", "independent":
+	default:
+		return nil, fmt.Errorf("invalid snapshot-window %q, expected \"keep-last\" or \"independent\"", cfg.SnapshotWindow)
+	}
+	switch cfg.SortKey {
+	case "", "create_time", "push_time":
+	default:
+		return nil, fmt.Errorf("invalid sort-key %q, expected \"create_time\" or \"push_time\"", cfg.SortKey)
+	}
+	if cfg.PolicyExpression != "" {
+		program, err := expr.Compile(cfg.PolicyExpression, expr.Env(policyEnv{}), expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy-expression: %w", err)
+		}
+		p.expression = program
+	}
+	if cfg.MajorVersion.Enabled {
+		re, err := regexp.Compile(cfg.MajorVersion.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid major-version pattern %q: %w", cfg.MajorVersion.Pattern, err)
+		}
+		if re.NumSubexp() < 1 {
+			return nil, fmt.Errorf("major-version pattern %q must contain a capture group for the major version", cfg.MajorVersion.Pattern)
+		}
+		p.majorPattern = re
+	}
+	return p, nil
+}
+
+// repoRetention applies the retention rules to the artifacts of a single repository.
+// Artifacts must be passed to decide newest first.
+type repoRetention struct {
+	policy        *retentionPolicy
+	repoName      string
+	keepLastN     int
+	maxSnapshots  int
+	keptSnapshots int
+	keptReleases  int
+	majorCounts   map[string]int
+	protectedTags []string        // From the matching rule; when set, only these tags are kept
+	architectures []string        // When set, only artifacts of these architectures are considered
+	released      map[string]bool // Base versions with a final release, for the pre-release window
+	releaseCutoff time.Time       // Sort-key time of the Nth-newest release, for keep-since-release
+	hasCutoff     bool
+	// minorLines maps the digest of the newest release of each major.minor line to the line,
+	// for keep-latest-per-minor.
+	minorLines map[string]string
+}
+
+// forRepo resolves the per-repository settings and returns fresh counting state.
+func (p *retentionPolicy) forRepo(repoName string) (*repoRetention, string) {
+	keepLastN, maxSnapshots, source := p.cfg.RetentionFor(repoName)
+	r := &repoRetention{
+		policy:       p,
+		repoName:     repoName,
+		keepLastN:    keepLastN,
+		maxSnapshots: maxSnapshots,
+		majorCounts:  make(map[string]int),
+	}
+	if rule := p.cfg.MatchRetentionRule(repoName); rule != nil {
+		r.protectedTags = rule.ProtectedTags
+	}
+	r.architectures = p.cfg.ArchitecturesFor(repoName)
+	return r, p.applySizeTier(r, source)
+}
+
+// logRules logs the rules that apply to the repository; source is from forRepo.
+func (r *repoRetention) logRules(source string) {
+	if len(r.architectures) > 0 {
+		log.Printf("        📐 Retention limited to architectures %s", strings.Join(r.architectures, ", "))
+	}
+	switch {
+	case len(r.protectedTags) > 0:
+		log.Printf("        📐 Retention (%s): only protected tags %s", source, strings.Join(r.protectedTags, ", "))
+	case r.policy.expression != nil:
+		log.Println("        📐 Retention: policy-expression")
+	default:
+		log.Printf("        📐 Retention (%s): keep-last=%d, max-snapshots=%d", source, r.keepLastN, r.maxSnapshots)
+	}
+}
+
+// inScope returns the artifacts built only for the repository's architectures, or all of
+// them when retention is not limited to architectures, and the others, which are left
+// alone. Artifacts are kept in order.
+func (r *repoRetention) inScope(artifacts []harbor.Artifact) (scoped, others []harbor.Artifact) {
+	if len(r.architectures) == 0 {
+		return artifacts, nil
+	}
+	for _, art := range artifacts {
+		archs := art.Architectures()
+		if len(archs) > 0 && !slices.ContainsFunc(archs, func(arch string) bool { return !slices.Contains(r.architectures, arch) }) {
+			scoped = append(scoped, art)
+		} else {
+			others = append(others, art)
+		}
+	}
+	if len(others) > 0 {
+		log.Printf("        ⏭️  Skipping %d artifacts of other or unknown architectures.", len(others))
+	}
+	return scoped, others
+}
+
+// observe records what decide needs to know about the whole repository: the push time of
+// the Nth-newest release (by sort-key) for keep-since-release, and which base versions have a final
+// release for the pre-release window. Artifacts must be newest first; call it before decide.
+func (r *repoRetention) observe(artifacts []harbor.Artifact) {
+	if n := r.policy.cfg.KeepSinceRelease; n > 0 {
+		releases := 0
+		for _, art := range artifacts {
+			if len(art.Tags) == 0 || strings.Contains(strings.ToUpper(art.Tags[0].Name), "SNAPSHOT") {
+				continue
+			}
+			if releases++; releases == n {
+				r.releaseCutoff, r.hasCutoff = r.policy.sortTime(art), true
+				break
+			}
+		}
+	}
+	if r.policy.cfg.KeepLatestPerMinor {
+		r.minorLines = latestPerMinor(artifacts)
+	}
+	if !r.policy.cfg.PrereleaseWindow {
+		return
+	}
+	r.released = make(map[string]bool)
+	for _, art := range artifacts {
+		for _, tag := range art.Tags {
+			if m := semverPattern.FindStringSubmatch(tag.Name); m != nil && m[4] == "" {
+				r.released[semverBase(m)] = true
+			}
+		}
+	}
+}
+
+// semverPattern matches a semantic version tag; group 4 is the pre-release part.
+var semverPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// semverBase returns the normalized "major.minor.patch" of a semverPattern match.
+func semverBase(m []string) string {
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	return fmt.Sprintf("%d.%d.%d", major, minor, patch)
+}
+
+// latestPerMinor maps the digest of the newest artifact of each major.minor line to the
+// line, e.g. "1.4". Only final semver release tags count; artifacts must be newest first.
+func latestPerMinor(artifacts []harbor.Artifact) map[string]string {
+	lines := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, art := range artifacts {
+		for _, tag := range art.Tags {
+			m := semverPattern.FindStringSubmatch(tag.Name)
+			if m == nil || m[4] != "" {
+				continue
+			}
+			major, _ := strconv.Atoi(m[1])
+			minor, _ := strconv.Atoi(m[2])
+			line := fmt.Sprintf("%d.%d", major, minor)
+			if seen[line] {
+				continue
+			}
+			seen[line] = true
+			if _, ok := lines[art.Digest]; !ok {
+				lines[art.Digest] = line
+			}
+		}
+	}
+	return lines
+}
+
+// prereleaseBase returns the base version if every tag of the artifact is a semver
+// pre-release of it. Snapshots are left to the snapshot rules, and an artifact that also
+// carries a final release tag is never treated as a pre-release.
+func prereleaseBase(art harbor.Artifact) (string, bool) {
+	base := ""
+	for _, tag := range art.Tags {
+		m := semverPattern.FindStringSubmatch(tag.Name)
+		if m == nil || m[4] == "" || strings.Contains(strings.ToUpper(m[4]), "SNAPSHOT") {
+			return "", false
+		}
+		if b := semverBase(m); base == "" {
+			base = b
+		} else if b != base {
+			return "", false
+		}
+	}
+	return base, base != ""
+}
+
+// decide reports whether the artifact at position i (newest first) is kept, and the audit note.
+// An artifact with a snapshot-exclude tag is kept without being counted by the rules. An
+// artifact an alias tag points to, or the newest release of a minor line with
+// keep-latest-per-minor, is kept even if the rules expire it; it still takes its place in
+// the counts.
+func (r *repoRetention) decide(i int, art harbor.Artifact, tagName string) (bool, string) {
+	for _, tag := range art.Tags {
+		for _, pattern := range r.policy.cfg.SnapshotExcludeTags {
+			if config.MatchWildcard(pattern, tag.Name) {
+				return true, fmt.Sprintf("Kept: tag %s is excluded from snapshot counting", tag.Name)
+			}
+		}
+	}
+	keep, reason := r.decideRules(i, art, tagName)
+	if keep {
+		return keep, reason
+	}
+	if line, ok := r.minorLines[art.Digest]; ok {
+		return true, fmt.Sprintf("Kept: last of minor %s (%s)", line, reason)
+	}
+	for _, tag := range art.Tags {
+		for _, alias := range r.policy.cfg.AliasTags {
+			if tag.Name == alias {
+				return true, fmt.Sprintf("Kept: alias tag %s points to this artifact (%s)", alias, reason)
+			}
+		}
+	}
+	return false, reason
+}
+
+// decideRules applies the retention rules without the alias tag override.
+func (r *repoRetention) decideRules(i int, art harbor.Artifact, tagName string) (bool, string) {
+	if len(r.protectedTags) > 0 {
+		return r.decideProtected(art)
+	}
+	if r.policy.expression != nil {
+		return r.decideExpression(i, art, tagName)
+	}
+	if r.released != nil {
+		if base, ok := prereleaseBase(art); ok {
+			if r.released[base] {
+				return false, fmt.Sprintf("Expired pre-release: version %s has been released", base)
+			}
+			return true, fmt.Sprintf("Kept pre-release: version %s is not released yet", base)
+		}
+	}
+	if n := r.policy.cfg.KeepSinceRelease; n > 0 {
+		if !r.hasCutoff {
+			return true, fmt.Sprintf("Kept: fewer than %d releases in the repository", n)
+		}
+		if r.policy.sortTime(art).Before(r.releaseCutoff) {
+			return false, fmt.Sprintf("Expired: pushed before the oldest of the newest %d releases (%s)", n, r.releaseCutoff.Format(time.RFC3339))
+		}
+		return true, fmt.Sprintf("Kept: pushed since the oldest of the newest %d releases (%s)", n, r.releaseCutoff.Format(time.RFC3339))
+	}
+	if re := r.policy.majorPattern; re != nil {
+		if m := re.FindStringSubmatch(tagName); m != nil {
+			major := m[1]
+			r.majorCounts[major]++
+			pos, limit := r.majorCounts[major], r.policy.cfg.MajorVersion.KeepPerMajor
+			if pos <= limit {
+				return true, fmt.Sprintf("Kept as #%d of the newest %d in major version group %s", pos, limit, major)
+			}
+			return false, fmt.Sprintf("Expired artifact (#%d in major version group %s, keeping %d)", pos, major, limit)
+		}
+	}
+
+	isSnapshot := strings.Contains(strings.ToUpper(tagName), "SNAPSHOT")
+	if r.policy.cfg.SnapshotWindow == "independent" {
+		return r.decideIndependent(isSnapshot)
+	}
+
+	keep := false
+	if i < r.keepLastN {
+		if isSnapshot {
+			if r.keptSnapshots < r.maxSnapshots {
+				keep = true
+				r.keptSnapshots++
+			}
+		} else {
+			keep = true
+		}
+	}
+	if keep {
+		return true, fmt.Sprintf("Kept as part of the newest %d artifacts (snapshot count: %d/%d)", r.keepLastN, r.keptSnapshots, r.maxSnapshots)
+	}
+	return false, "Expired artifact"
+}
+
+// decideIndependent keeps the newest maxSnapshots snapshots and the newest keepLastN
+// releases, counting each kind across the whole repository.
+func (r *repoRetention) decideIndependent(isSnapshot bool) (bool, string) {
+	if isSnapshot {
+		if r.keptSnapshots < r.maxSnapshots {
+			r.keptSnapshots++
+			return true, fmt.Sprintf("Kept as snapshot #%d of the newest %d snapshots", r.keptSnapshots, r.maxSnapshots)
+		}
+		return false, "Expired snapshot"
+	}
+	if r.keptReleases < r.keepLastN {
+		r.keptReleases++
+		return true, fmt.Sprintf("Kept as release #%d of the newest %d releases", r.keptReleases, r.keepLastN)
+	}
+	return false, "Expired artifact"
+}
+
+// decideProtected keeps the artifact only if one of its tags is in the protected set.
+func (r *repoRetention) decideProtected(art harbor.Artifact) (bool, string) {
+	for _, tag := range art.Tags {
+		for _, pattern := range r.protectedTags {
+			if config.MatchWildcard(pattern, tag.Name) {
+				return true, fmt.Sprintf("Kept: tag %s is protected", tag.Name)
+			}
+		}
+	}
+	return false, "Expired: no protected tag"
+}
+
+// decideExpression evaluates policy-expression for the artifact; true keeps it. An
+// evaluation error keeps the artifact, since deleting on a broken rule is not safe.
+func (r *repoRetention) decideExpression(i int, art harbor.Artifact, tagName string) (bool, string) {
+	env := policyEnv{
+		Repo:       r.repoName,
+		Tag:        tagName,
+		PushTime:   art.PushTime,
+		CreateTime: art.CreateTime(),
+		Age:        time.Since(r.policy.sortTime(art)),
+		Size:       art.Size,
+		Index:      i,
+		Snapshot:   strings.Contains(strings.ToUpper(tagName), "SNAPSHOT"),
+	}
+	env.Project, _, _ = strings.Cut(r.repoName, "/")
+	env.AgeDays = env.Age.Hours() / 24
+	for _, t := range art.Tags {
+		env.Tags = append(env.Tags, t.Name)
+	}
+	for _, l := range art.Labels {
+		env.Labels = append(env.Labels, l.Name)
+	}
+
+	out, err := expr.Run(r.policy.expression, env)
+	if err != nil {
+		return true, fmt.Sprintf("Kept: policy-expression failed: %v", err)
+	}
+	if out.(bool) {
+		return true, "Kept by policy-expression"
+	}
+	return false, "Expired by policy-expression"
+}
@@ -0,0 +1,154 @@
+// File: scheduler.go
+package cleaner
+
+import (
+	"context"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"sync"
+)
+
+// repoJob is a repository waiting to be cleaned.
+type repoJob struct {
+	project harbor.Project
+	repo    harbor.Repository
+}
+
+// repoScheduler hands repositories to concurrent workers without running more than
+// perProject repositories of one project at a time (0 means no per-project limit).
+// Jobs are queued round-robin across projects so a project with many repositories
+// cannot hold back the others.
+type repoScheduler struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queue      []repoJob
+	active     map[string]int
+	perProject int
+}
+
+func newRepoScheduler(byProject [][]repoJob, perProject int) *repoScheduler {
+	s := &repoScheduler{active: make(map[string]int), perProject: perProject}
+	s.cond = sync.NewCond(&s.mu)
+	for i := 0; ; i++ {
+		added := false
+		for _, jobs := range byProject {
+			if i < len(jobs) {
+				s.queue = append(s.queue, jobs[i])
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	return s
+}
+
+// next returns the first queued job whose project has a free slot, waiting for one if
+// necessary. It returns false once the queue is empty.
+func (s *repoScheduler) next() (repoJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) > 0 {
+		for i, job := range s.queue {
+			if s.perProject > 0 && s.active[job.project.Name] >= s.perProject {
+				continue
+			}
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.active[job.project.Name]++
+			return job, true
+		}
+		s.cond.Wait()
+	}
+	return repoJob{}, false
+}
+
+// done releases the project slot held by job.
+func (s *repoScheduler) done(job repoJob) {
+	s.mu.Lock()
+	s.active[job.project.Name]--
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// stop drops all queued jobs so idle workers exit.
+func (s *repoScheduler) stop() {
+	s.mu.Lock()
+	s.queue = nil
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// cleanConcurrently cleans the repositories of all projects with repo-concurrency workers.
+// Each repository's audit records stay together, but repositories finish in any order.
+// Base image repositories are only started once all other repositories are done.
+func (run *harborRun) cleanConcurrently(ctx context.Context, projects []harbor.Project) {
+	var byProject, baseByProject [][]repoJob
+	for _, project := range projects {
+		log.Printf("  ▶️  Listing Project: %s", project.Name)
+		repos, ok := listRepositories(run.client, project.Name, run.cfg.ContinueOnError)
+		if !ok {
+			continue
+		}
+		run.policy.rankRepoSizes(run.client, project, repos)
+		repos = onlyMatching(repos, run.cfg.Harbor.OnlyReposMatching)
+		var jobs, baseJobs []repoJob
+		for _, repo := range repos {
+			if run.base.isBase(repo.Name) {
+				baseJobs = append(baseJobs, repoJob{project: project, repo: repo})
+			} else {
+				jobs = append(jobs, repoJob{project: project, repo: repo})
+			}
+		}
+		byProject = append(byProject, jobs)
+		baseByProject = append(baseByProject, baseJobs)
+	}
+
+	sched := newRepoScheduler(byProject, run.cfg.Harbor.MaxConcurrencyPerProject)
+	baseSched := newRepoScheduler(baseByProject, run.cfg.Harbor.MaxConcurrencyPerProject)
+	if limit := run.cfg.Harbor.RepoLimit; limit > 0 && len(sched.queue)+len(baseSched.queue) > limit {
+		sched.queue = sched.queue[:min(limit, len(sched.queue))]
+		baseSched.queue = baseSched.queue[:limit-len(sched.queue)]
+		run.result.Limited = true
+	}
+	if run.work(ctx, sched) && len(baseSched.queue) > 0 {
+		log.Printf("  ▶️  Processing %d base image repositories.", len(baseSched.queue))
+		run.work(ctx, baseSched)
+	}
+}
+
+// work cleans the repositories of sched with repo-concurrency workers until it is empty.
+// It returns false if ctx was cancelled.
+func (run *harborRun) work(ctx context.Context, sched *repoScheduler) bool {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	completed := true
+	for w := 0; w < run.cfg.Harbor.RepoConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, ok := sched.next()
+				if !ok {
+					return
+				}
+				var result Result
+				more := run.cleanRepository(ctx, job.project, job.repo, &result)
+				sched.done(job)
+
+				mu.Lock()
+				run.result.merge(result)
+				if !more {
+					completed = false
+				}
+				mu.Unlock()
+				if !more {
+					sched.stop()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return completed
+}
@@ -0,0 +1,136 @@
+// File: shared_digests.go
+package cleaner
+
+import (
+	"context"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sharedDigests tracks the repositories holding each digest that is present in more than
+// one repository, so the last copy of a promoted image is never deleted. The copy pushed
+// last, usually the promoted one, is the survivor and is never deleted; the others may be.
+// A repository stops holding a digest once its delete succeeded, or would in a dry run. It
+// is safe for concurrent use.
+type sharedDigests struct {
+	mu sync.Mutex
+	// repos maps a digest to the repositories still holding it, with the push time of the
+	// digest in each.
+	repos map[string]map[string]time.Time
+	// survivors maps each digest to the repository whose copy is kept.
+	survivors map[string]string
+}
+
+// indexSharedDigests lists the artifacts of every project, regardless of the project
+// whitelist, and indexes the digests found in more than one repository. A repository that
+// can't be listed is left out, which can only make its digests look less shared.
+func indexSharedDigests(ctx context.Context, client *harbor.HarborClient, cfg *config.Config) *sharedDigests {
+	log.Println("🔗 Indexing digests shared across repositories.")
+	s := &sharedDigests{repos: make(map[string]map[string]time.Time), survivors: make(map[string]string)}
+	for _, project := range filterProjects(client, nil, 0, cfg.ContinueOnError) {
+		for repo := range streamRepositories(client, project.Name) {
+			if stopped(ctx) {
+				return s
+			}
+			err := client.EachArtifactPage(project.Name, repo.Name, func(artifacts []harbor.Artifact) error {
+				for _, art := range artifacts {
+					copies := s.repos[art.Digest]
+					if copies == nil {
+						copies = make(map[string]time.Time)
+						s.repos[art.Digest] = copies
+					}
+					if pushed, ok := copies[repo.Name]; !ok || art.PushTime.After(pushed) {
+						copies[repo.Name] = art.PushTime
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("    ⚠️  Could not index digests of repo %s: %v", repo.Name, err)
+			}
+		}
+	}
+	for digest, repos := range s.repos {
+		if len(repos) < 2 {
+			delete(s.repos, digest)
+			continue
+		}
+		s.survivors[digest] = newestCopy(repos)
+	}
+	log.Printf("🔗 %d digests are present in more than one repository.", len(s.repos))
+	return s
+}
+
+// newestCopy returns the repository whose copy was pushed last, by name on a tie.
+func newestCopy(repos map[string]time.Time) string {
+	survivor := ""
+	for repo, pushed := range repos {
+		if survivor == "" || pushed.After(repos[survivor]) || (pushed.Equal(repos[survivor]) && repo < survivor) {
+			survivor = repo
+		}
+	}
+	return survivor
+}
+
+// survivor reports whether repoName holds the copy of a shared digest that is kept.
+func (s *sharedDigests) survivor(repoName, digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.survivors[digest] == repoName
+}
+
+// holders is called when a digest expires in a repository. If the digest is not shared, it
+// returns false. Otherwise it returns true with the other repositories still holding the
+// digest.
+func (s *sharedDigests) holders(repoName, digest string) (bool, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repos := s.repos[digest]
+	if repos == nil {
+		return false, nil
+	}
+	var others []string
+	for r := range repos {
+		if r != repoName {
+			others = append(others, r)
+		}
+	}
+	sort.Strings(others)
+	return true, others
+}
+
+// reserve is called right before a digest is deleted from a repository, or would be in a
+// dry run. It returns false for the surviving copy, whose delete must be skipped. Every
+// reserve returning true must be followed by settle. A nil *sharedDigests reserves
+// everything.
+func (s *sharedDigests) reserve(repoName, digest string) bool {
+	if s == nil {
+		return true
+	}
+	return !s.survivor(repoName, digest)
+}
+
+// settle records the outcome of a delete reserved with reserve: the repository no longer
+// holds the digest if it was deleted, or would be in a dry run.
+func (s *sharedDigests) settle(repoName, digest string, deleted bool) {
+	if s == nil || !deleted {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.repos[digest], repoName)
+}
+
+// describeLocations formats the other locations of a shared digest for an audit note.
+func describeLocations(repos []string) string {
+	const max = 3
+	if len(repos) > max {
+		return strings.Join(repos[:max], ", ") + ", …"
+	}
+	return strings.Join(repos, ", ")
+}
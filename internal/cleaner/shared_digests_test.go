@@ -0,0 +1,81 @@
+package cleaner
+
+import (
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+)
+
+// newTestSharedDigests returns an index of digest "sha256:d", pushed to staging/app a day
+// before it was promoted to prod/app.
+func newTestSharedDigests() *sharedDigests {
+	now := time.Now()
+	repos := map[string]time.Time{"staging/app": now.Add(-48 * time.Hour), "prod/app": now.Add(-24 * time.Hour)}
+	return &sharedDigests{
+		repos:     map[string]map[string]time.Time{"sha256:d": repos},
+		survivors: map[string]string{"sha256:d": newestCopy(repos)},
+	}
+}
+
+// TestSharedDigestsKeepNewestCopy checks that the copy pushed last survives whatever order
+// the repositories are processed in, and that a repository only stops holding a shared
+// digest once its delete succeeded.
+func TestSharedDigestsKeepNewestCopy(t *testing.T) {
+	s := newTestSharedDigests()
+
+	if s.reserve("prod/app", "sha256:d") {
+		t.Fatal("reserve of the newest copy succeeded")
+	}
+	if shared, others := s.holders("staging/app", "sha256:d"); !shared || len(others) != 1 || others[0] != "prod/app" {
+		t.Fatalf("holders = %v, %v, want true, [prod/app]", shared, others)
+	}
+	if !s.reserve("staging/app", "sha256:d") {
+		t.Fatal("reserve of the older copy failed")
+	}
+	s.settle("staging/app", "sha256:d", false)
+	if _, others := s.holders("prod/app", "sha256:d"); len(others) != 1 {
+		t.Fatalf("a failed delete released the digest, holders of prod/app = %v", others)
+	}
+	s.settle("staging/app", "sha256:d", true)
+	if shared, others := s.holders("prod/app", "sha256:d"); !shared || len(others) != 0 {
+		t.Fatalf("holders of prod/app after the delete = %v, %v, want true, []", shared, others)
+	}
+
+	if shared, _ := s.holders("prod/app", "sha256:other"); shared {
+		t.Fatal("an unindexed digest is reported as shared")
+	}
+	if !s.reserve("prod/app", "sha256:other") {
+		t.Fatal("reserve of an unshared digest failed")
+	}
+}
+
+// TestSharedDigestsDryRun checks that a dry run reports what a real run does: when both
+// copies expire, the older one is to be deleted and the newest one is skipped, and the
+// older copy is no longer listed as holding the digest afterwards.
+func TestSharedDigestsDryRun(t *testing.T) {
+	s := newTestSharedDigests()
+	cfg := &config.Config{DryRun: true}
+	art := harbor.Artifact{Digest: "sha256:d"}
+	var result Result
+
+	// prod/app first, so the outcome can't depend on the processing order.
+	prod := newRepoDeletes("prod", "prod/app", cfg, nil, &result, s)
+	prod.record(&result, []string{"prod/app:1.0", "TO BE DELETED", "Expired artifact"}, art, "1.0")
+	staging := newRepoDeletes("staging", "staging/app", cfg, nil, &result, s)
+	staging.record(&result, []string{"staging/app:1.0", "TO BE DELETED", "Expired artifact"}, art, "1.0")
+
+	if got := result.Audit[0][1]; got != "SKIPPED" {
+		t.Errorf("newest copy is %s, want SKIPPED", got)
+	}
+	if got := result.Audit[1][1]; got != "TO BE DELETED" {
+		t.Errorf("older copy is %s, want TO BE DELETED", got)
+	}
+	if result.Deleted != 1 || result.Kept != 1 {
+		t.Errorf("deleted %d, kept %d, want 1, 1", result.Deleted, result.Kept)
+	}
+	if _, others := s.holders("prod/app", "sha256:d"); len(others) != 0 {
+		t.Errorf("holders of prod/app after a dry-run delete of staging/app = %v, want none", others)
+	}
+}
@@ -0,0 +1,84 @@
+// File: size_tiers.go
+package cleaner
+
+import (
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/utils"
+	"log"
+	"sort"
+)
+
+// repoSizeTier is the size tier a repository falls into.
+type repoSizeTier struct {
+	tier       config.SizeTier
+	size       int64
+	percentile float64
+}
+
+// rankRepoSizes sums the artifact sizes of each repository of a project and assigns the
+// repositories to their size tiers for forRepo. It must run before the project's
+// repositories are cleaned, and not concurrently with cleaning.
+func (p *retentionPolicy) rankRepoSizes(client *harbor.HarborClient, project harbor.Project, repos []harbor.Repository) {
+	cfg := p.cfg.SizeTiers
+	if !cfg.Enabled || len(cfg.Tiers) == 0 || len(repos) == 0 {
+		return
+	}
+
+	sizes := make(map[string]int64, len(repos))
+	var total int64
+	for _, repo := range repos {
+		artifacts, err := client.ListArtifacts(project.Name, repo.Name)
+		if err != nil {
+			// Without every size the percentiles would be wrong, so use the default retention.
+			log.Printf("    ⚠️  Could not size repository %s, size tiers are not applied to project %s: %v", repo.Name, project.Name, err)
+			return
+		}
+		for _, art := range artifacts {
+			sizes[repo.Name] += art.Size
+		}
+		total += sizes[repo.Name]
+	}
+
+	quota := int64(cfg.TargetQuotaGB * (1 << 30))
+	if quota > 0 && total <= quota {
+		log.Printf("    📏 Project %s uses %s of its %s target, size tiers are not applied.", project.Name, utils.FormatBytes(total), utils.FormatBytes(quota))
+		return
+	}
+	log.Printf("    📏 Project %s uses %s across %d repositories, applying size tiers.", project.Name, utils.FormatBytes(total), len(repos))
+
+	tiers := append([]config.SizeTier(nil), cfg.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].Percentile > tiers[j].Percentile })
+	if p.sizeTiers == nil {
+		p.sizeTiers = make(map[string]repoSizeTier)
+	}
+	for _, repo := range repos {
+		smaller := 0
+		for _, size := range sizes {
+			if size < sizes[repo.Name] {
+				smaller++
+			}
+		}
+		percentile := 100 * float64(smaller) / float64(len(repos))
+		for _, tier := range tiers {
+			if percentile >= tier.Percentile {
+				p.sizeTiers[repo.Name] = repoSizeTier{tier: tier, size: sizes[repo.Name], percentile: percentile}
+				break
+			}
+		}
+	}
+}
+
+// applySizeTier replaces the default retention of a repository with its size tier, if any.
+func (p *retentionPolicy) applySizeTier(r *repoRetention, source string) string {
+	st, ok := p.sizeTiers[r.repoName]
+	if !ok || source != "default" {
+		return source
+	}
+	r.keepLastN = st.tier.KeepLastN
+	if st.tier.MaxSnapshots > 0 {
+		r.maxSnapshots = st.tier.MaxSnapshots
+	}
+	return fmt.Sprintf("size tier p%g, repository is %s at p%.0f", st.tier.Percentile, utils.FormatBytes(st.size), st.percentile)
+}
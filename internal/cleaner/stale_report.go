@@ -0,0 +1,100 @@
+// File: stale_report.go
+package cleaner
+
+import (
+	"context"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/utils"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// staleArtifact is an artifact of the stale-report that hasn't been pulled for long.
+type staleArtifact struct {
+	image    string // "repo:tag", or "repo@digest" for untagged artifacts
+	art      harbor.Artifact
+	idleDays int // Days since the last pull, or since the push if never pulled
+}
+
+// FindStaleArtifacts lists the artifacts of the scanned projects, tagged or not, that
+// haven't been pulled for more than stale-report.pull-days days, largest first within each
+// repository, as candidates for a human to review. Artifacts never pulled count from their
+// push time. It only reads from Harbor. The first record is the header.
+func FindStaleArtifacts(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}) [][]string {
+	days := cfg.StaleReport.PullDays
+	log.Printf("⚪️ Listing artifacts not pulled for more than %d days.", days)
+	records := [][]string{{"Project", "Repository", "Image", "Digest", "Size Bytes", "Last Pulled", "Pushed", "Days Since Pull"}}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	var total int
+	var totalSize int64
+
+	for _, project := range filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError) {
+		log.Printf("  ▶️  Processing Project: %s", project.Name)
+		for repo := range streamRepositories(client, project.Name) {
+			if !repoSelected(cfg.Harbor.OnlyReposMatching, repo.Name) {
+				continue
+			}
+			if stopped(ctx) {
+				return records
+			}
+			var stale []staleArtifact
+			err := client.EachArtifactPage(project.Name, repo.Name, func(artifacts []harbor.Artifact) error {
+				for _, art := range artifacts {
+					last := art.PullTime
+					if last.IsZero() {
+						last = art.PushTime
+					}
+					if !last.Before(cutoff) {
+						continue
+					}
+					image := repo.Name + "@" + art.Digest
+					if len(art.Tags) > 0 {
+						image = repo.Name + ":" + art.Tags[0].Name
+					}
+					stale = append(stale, staleArtifact{image: image, art: art, idleDays: int(time.Since(last).Hours() / 24)})
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("    ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+				continue
+			}
+			if len(stale) == 0 {
+				continue
+			}
+
+			sort.SliceStable(stale, func(i, j int) bool { return stale[i].art.Size > stale[j].art.Size })
+			var size int64
+			for _, s := range stale {
+				size += s.art.Size
+				records = append(records, []string{
+					project.Name,
+					repo.Name,
+					s.image,
+					s.art.Digest,
+					strconv.FormatInt(s.art.Size, 10),
+					lastPulled(s.art),
+					s.art.PushTime.UTC().Format(time.RFC3339),
+					strconv.Itoa(s.idleDays),
+				})
+			}
+			total += len(stale)
+			totalSize += size
+			log.Printf("    🧊 %s: %d stale artifacts, %s; largest %s (%s, last pulled %s)",
+				repo.Name, len(stale), utils.FormatBytes(size), stale[0].image, utils.FormatBytes(stale[0].art.Size), lastPulled(stale[0].art))
+		}
+	}
+	log.Printf("🧊 Found %d artifacts not pulled for more than %d days, %s in total.", total, days, utils.FormatBytes(totalSize))
+	return records
+}
+
+// lastPulled formats the pull time of art for the report, "never" if it wasn't pulled.
+func lastPulled(art harbor.Artifact) string {
+	if art.PullTime.IsZero() {
+		return "never"
+	}
+	return art.PullTime.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,97 @@
+// File: surplus_tags.go
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"log"
+	"slices"
+	"time"
+)
+
+// RunSurplusTagsStrategy finds artifacts with more than surplus-tags.max-tags tags, as left
+// by CI jobs that tag every build of the same content, and removes the oldest tags (by tag
+// push time) down to max-tags. Only tags are removed; the artifacts stay. Artifacts within
+// the limit are not audited, so the report lists only the surplus tags.
+// If ctx is cancelled the current repository is finished and the partial results are returned.
+func RunSurplusTagsStrategy(ctx context.Context, client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}) Result {
+	result := Result{Audit: [][]string{{"Image", "Status", "Notes"}}}
+	maxTags := cfg.SurplusTags.MaxTags
+
+	log.Printf("⚪️ Looking for artifacts with more than %d tags.", maxTags)
+	projects := filterProjects(client, projectWhitelist, cfg.Harbor.MinReposPerProject, cfg.ContinueOnError)
+	projects = skipProtectedProjects(client, cfg, projects)
+	for _, project := range projects {
+		result.ReposTotal += project.RepoCount
+	}
+	if matched := reportRepoFilter(client, cfg, projects); matched >= 0 {
+		result.ReposTotal = matched
+	}
+	for _, project := range projects {
+		log.Printf("  ▶️  Processing Project: %s", project.Name)
+		for repo := range streamRepositories(client, project.Name) {
+			if !repoSelected(cfg.Harbor.OnlyReposMatching, repo.Name) {
+				continue
+			}
+			if stopped(ctx) || result.limitReached(cfg.Harbor.RepoLimit) {
+				return result
+			}
+			log.Printf("    ▶️  Processing Repository: %s", repo.Name)
+			start := time.Now()
+			err := client.EachArtifactPage(project.Name, repo.Name, func(artifacts []harbor.Artifact) error {
+				for _, art := range artifacts {
+					if len(art.Tags) > maxTags {
+						removeSurplusTags(client, project.Name, repo.Name, art, maxTags, cfg.DryRun, &result)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("        ❌ Failed to list artifacts for repo %s: %v", repo.Name, err)
+				continue
+			}
+			result.ReposProcessed++
+			result.timeRepo(project.Name, repo.Name, start)
+		}
+	}
+	return result
+}
+
+// removeSurplusTags removes (or, in dry-run mode, reports) the oldest tags of an artifact
+// so that maxTags remain, recording each removed tag in result.
+func removeSurplusTags(client *harbor.HarborClient, projectName, repoName string, art harbor.Artifact, maxTags int, dryRun bool, result *Result) {
+	tags := slices.Clone(art.Tags)
+	// Newest first; tags pushed at the same time are ordered by name for stable reports.
+	slices.SortStableFunc(tags, func(a, b harbor.Tag) int {
+		if c := b.PushTime.Compare(a.PushTime); c != 0 {
+			return c
+		}
+		if a.Name < b.Name {
+			return -1
+		}
+		if a.Name > b.Name {
+			return 1
+		}
+		return 0
+	})
+	log.Printf("        🏷️  %s has %d tags, removing the %d oldest", shortDigest(art.Digest), len(tags), len(tags)-maxTags)
+	notes := fmt.Sprintf("Surplus tag: artifact %s has %d tags, more than %d", art.Digest, len(tags), maxTags)
+	for _, tag := range tags[maxTags:] {
+		image := client.BaseURL + "/" + repoName + ":" + tag.Name
+		status := "TO BE DELETED"
+		if !dryRun {
+			if err := client.DeleteTag(projectName, repoName, art.Digest, tag.Name); err != nil {
+				log.Printf("            ❌ FAILED to remove surplus tag %s: %v", tag.Name, err)
+				status = "DELETE_FAILED"
+			} else {
+				status = "DELETED"
+			}
+		}
+		log.Printf("        🏷️  %s: %s (pushed %s)", status, image, tag.PushTime.Format(time.DateOnly))
+		result.Audit = append(result.Audit, []string{image, status, notes})
+		result.details = append(result.details, newAuditDetail(art))
+		result.count(status)
+	}
+}
@@ -0,0 +1,134 @@
+// File: timings.go
+package cleaner
+
+import (
+	"bytes"
+	"fmt"
+	"harbor-cleaner/internal/storage"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RepoTiming is the time spent processing one repository.
+type RepoTiming struct {
+	Project  string
+	Repo     string
+	Duration time.Duration
+}
+
+// durationBuckets are the upper bounds, in seconds, of the repository duration histogram.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}
+
+// timeRepo records and logs the time spent on a repository since start.
+func (r *Result) timeRepo(projectName, repoName string, start time.Time) {
+	d := time.Since(start)
+	r.Timings = append(r.Timings, RepoTiming{Project: projectName, Repo: repoName, Duration: d})
+	log.Printf("        ⏱️  Repository %s took %s", repoName, d.Round(time.Millisecond))
+}
+
+// SlowestRepos returns the n repositories that took longest, slowest first.
+func (r *Result) SlowestRepos(n int) []RepoTiming {
+	timings := append([]RepoTiming(nil), r.Timings...)
+	return slowest(timings, n)
+}
+
+// SlowestProjects returns the n projects whose repositories took longest in total, slowest
+// first. With repo-concurrency the totals can exceed the wall-clock time of the run.
+func (r *Result) SlowestProjects(n int) []RepoTiming {
+	totals := make(map[string]time.Duration)
+	for _, t := range r.Timings {
+		totals[t.Project] += t.Duration
+	}
+	timings := make([]RepoTiming, 0, len(totals))
+	for project, d := range totals {
+		timings = append(timings, RepoTiming{Project: project, Duration: d})
+	}
+	return slowest(timings, n)
+}
+
+func slowest(timings []RepoTiming, n int) []RepoTiming {
+	sort.Slice(timings, func(i, j int) bool {
+		if timings[i].Duration != timings[j].Duration {
+			return timings[i].Duration > timings[j].Duration
+		}
+		return timings[i].Project+"/"+timings[i].Repo < timings[j].Project+"/"+timings[j].Repo
+	})
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	return timings
+}
+
+// metricLabels formats the extra labels of a metric series, sorted by name, each preceded
+// by a comma.
+func metricLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	var extra strings.Builder
+	for _, label := range names {
+		fmt.Fprintf(&extra, ",%s=%q", label, labels[label])
+	}
+	return extra.String()
+}
+
+// WriteDurationMetrics writes the repository durations as a Prometheus histogram labelled by
+// project and the given extra labels, in the text exposition format read by the node_exporter
+// textfile collector, along with a harbor_cleaner_run_info series carrying the run ID. path
+// may be a local file or an s3:// or gs:// object URL.
+func WriteDurationMetrics(timings []RepoTiming, path string, labels map[string]string, runID string) error {
+	type histogram struct {
+		counts []int
+		sum    float64
+		total  int
+	}
+	byProject := make(map[string]*histogram)
+	for _, t := range timings {
+		h, ok := byProject[t.Project]
+		if !ok {
+			h = &histogram{counts: make([]int, len(durationBuckets))}
+			byProject[t.Project] = h
+		}
+		seconds := t.Duration.Seconds()
+		for i, le := range durationBuckets {
+			if seconds <= le {
+				h.counts[i]++
+			}
+		}
+		h.sum += seconds
+		h.total++
+	}
+	projects := make([]string, 0, len(byProject))
+	for project := range byProject {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	extra := metricLabels(labels)
+
+	const name = "harbor_cleaner_repository_duration_seconds"
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# HELP %s Time spent processing one repository.\n", name)
+	fmt.Fprintf(&buf, "# TYPE %s histogram\n", name)
+	for _, project := range projects {
+		h := byProject[project]
+		for i, le := range durationBuckets {
+			fmt.Fprintf(&buf, "%s_bucket{project=%q%s,le=\"%g\"} %d\n", name, project, extra, le, h.counts[i])
+		}
+		fmt.Fprintf(&buf, "%s_bucket{project=%q%s,le=\"+Inf\"} %d\n", name, project, extra, h.total)
+		fmt.Fprintf(&buf, "%s_sum{project=%q%s} %g\n", name, project, extra, h.sum)
+		fmt.Fprintf(&buf, "%s_count{project=%q%s} %d\n", name, project, extra, h.total)
+	}
+	// The run ID changes every run, so it is kept off the histogram to not create new series.
+	fmt.Fprintln(&buf, "# HELP harbor_cleaner_run_info The run that wrote these metrics.")
+	fmt.Fprintln(&buf, "# TYPE harbor_cleaner_run_info gauge")
+	fmt.Fprintf(&buf, "harbor_cleaner_run_info{run_id=%q%s} 1\n", runID, extra)
+	if err := storage.WriteFile(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write metrics file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// File: batch.go
+package config
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// BatchEntry is one repository listed in harbor.batch-file.
+type BatchEntry struct {
+	Project string `mapstructure:"project"`
+	Repo    string `mapstructure:"repo"`
+	// Keep, when set, replaces keep-last for this repository.
+	Keep *int `mapstructure:"keep"`
+}
+
+// Name returns the full repository name, e.g. "library/nginx".
+func (e BatchEntry) Name() string {
+	if strings.HasPrefix(e.Repo, e.Project+"/") {
+		return e.Repo
+	}
+	return e.Project + "/" + e.Repo
+}
+
+// loadBatchFile reads the repositories of a batch file: YAML (.yaml or .yml) with a top-level
+// "repositories" list, or CSV with project,repo[,keep] rows and an optional header row.
+func loadBatchFile(path string) ([]BatchEntry, error) {
+	var entries []BatchEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		v := viper.New()
+		v.SetConfigFile(path)
+		v.SetConfigType("yaml")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read batch file %s: %w", path, err)
+		}
+		var file struct {
+			Repositories []BatchEntry `mapstructure:"repositories"`
+		}
+		if err := v.Unmarshal(&file); err != nil {
+			return nil, fmt.Errorf("failed to parse batch file %s: %w", path, err)
+		}
+		entries = file.Repositories
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch file %s: %w", path, err)
+		}
+		defer f.Close()
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		r.Comment = '#'
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse batch file %s: %w", path, err)
+		}
+		if len(records) > 0 && strings.EqualFold(strings.TrimSpace(records[0][0]), "project") {
+			records = records[1:]
+		}
+		for i, record := range records {
+			if len(record) < 2 || len(record) > 3 {
+				return nil, fmt.Errorf("line %d of batch file %s: expected project,repo[,keep], got %d fields", i+1, path, len(record))
+			}
+			entry := BatchEntry{Project: strings.TrimSpace(record[0]), Repo: strings.TrimSpace(record[1])}
+			if len(record) == 3 && strings.TrimSpace(record[2]) != "" {
+				keep, err := strconv.Atoi(strings.TrimSpace(record[2]))
+				if err != nil {
+					return nil, fmt.Errorf("line %d of batch file %s: invalid keep %q", i+1, path, record[2])
+				}
+				entry.Keep = &keep
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	for i, e := range entries {
+		switch {
+		case e.Project == "" || e.Repo == "":
+			return nil, fmt.Errorf("entry %d in batch file %s needs a project and a repo", i+1, path)
+		case strings.ContainsAny(e.Name(), "*?"):
+			return nil, fmt.Errorf("entry %d in batch file %s: %s must be an exact repository name", i+1, path, e.Name())
+		case e.Keep != nil && *e.Keep < 0:
+			return nil, fmt.Errorf("entry %d in batch file %s: keep must not be negative", i+1, path)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("batch file %s lists no repositories", path)
+	}
+	return entries, nil
+}
+
+// applyBatch limits the run to the batch entries: only-repos-matching becomes their exact
+// names, project-whitelist their projects unless it is set, and each entry with a keep gets
+// an exact-name retention rule ahead of any other rule for the repository.
+func (h *HarborConfig) applyBatch(entries []BatchEntry) error {
+	if len(h.OnlyReposMatching) > 0 {
+		return fmt.Errorf("harbor.batch-file and harbor.only-repos-matching cannot be combined")
+	}
+	var projects []string
+	var rules []RetentionRule
+	for _, e := range entries {
+		h.OnlyReposMatching = append(h.OnlyReposMatching, e.Name())
+		if !slices.Contains(projects, e.Project) {
+			projects = append(projects, e.Project)
+		}
+		if e.Keep != nil {
+			rules = append(rules, RetentionRule{Pattern: e.Name(), KeepLastN: e.Keep})
+		}
+	}
+	if h.ProjectWhitelist == "" {
+		h.ProjectWhitelist = strings.Join(projects, ",")
+	}
+	h.Rules = append(rules, h.Rules...)
+	h.Batch = entries
+	return nil
+}
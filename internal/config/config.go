@@ -1,19 +1,75 @@
 package config
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
+
+	"harbor-cleaner/internal/harbor"
 
 	"github.com/spf13/viper"
 )
 
 // K8sEnvConfig represents the configuration for a single Kubernetes environment.
 type K8sEnvConfig struct {
-	Name        string   `mapstructure:"name"`
-	Kubeconfig  string   `mapstructure:"kubeconfig"`
-	Namespaces  []string `mapstructure:"namespaces"`
-	Keep        int      `mapstructure:"keep"`
-	PodWhitelist []string `mapstructure:"pod-whitelist"`
-	PodBlacklist []string `mapstructure:"pod-blacklist"`
+	Name       string `mapstructure:"name"`
+	Kubeconfig string `mapstructure:"kubeconfig"`
+	// TokenFile is a bearer token file (e.g. a projected service account token) used instead
+	// of the credentials in the kubeconfig. It is re-read as the token rotates.
+	TokenFile  string   `mapstructure:"token-file"`
+	Namespaces []string `mapstructure:"namespaces"`
+	// NamespaceSelector is a label selector (e.g. "environment=prod") used instead of
+	// Namespaces to discover the namespaces to scan at run time.
+	NamespaceSelector string `mapstructure:"namespace-selector"`
+	Keep              int    `mapstructure:"keep"`
+	// RolloutHistory keeps the images of the revisions a deployment can roll back to
+	// (its own ReplicaSets, up to spec.revisionHistoryLimit) instead of Keep images.
+	RolloutHistory bool     `mapstructure:"rollout-history"`
+	PodWhitelist   []string `mapstructure:"pod-whitelist"`
+	PodBlacklist   []string `mapstructure:"pod-blacklist"`
+	// ImageSources adds images referenced by non-Pod resources to the safe list.
+	ImageSources []ImageSource `mapstructure:"image-sources"`
+	// ConfigImages adds the image references found in ConfigMaps and Helm releases.
+	ConfigImages ConfigImagesConfig `mapstructure:"config-images"`
+	// AllowEmpty lets the environment contribute no images; otherwise an empty environment
+	// fails the scan, as it usually means the scan didn't see what it should have.
+	AllowEmpty bool `mapstructure:"allow-empty"`
+}
+
+// ConfigImagesConfig scans ConfigMaps, and optionally the values and manifests of deployed
+// Helm releases, for references to images in Registries, e.g. sidecar images pinned in an
+// operator's configuration that never appear in a workload's pod template.
+type ConfigImagesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// HelmReleases also reads the release secrets Helm 3 stores, which needs "list" on secrets.
+	HelmReleases bool `mapstructure:"helm-releases"`
+	// Registries are the registry hosts (with port, if any) to look for; empty uses the
+	// host of harbor.url.
+	Registries []string `mapstructure:"registries"`
+}
+
+// ImageSource reads extra image references for the safe list from fields of other
+// resources, e.g. a custom resource referencing an OCI artifact. Path is a kubectl-style
+// JSONPath expression such as "{.spec.image}" or "{.spec.modules[*].image}"; Paths adds
+// more for resources that keep images in several places, such as Tekton PipelineRuns.
+type ImageSource struct {
+	Group         string   `mapstructure:"group"`
+	Version       string   `mapstructure:"version"`
+	Resource      string   `mapstructure:"resource"` // Plural resource name, e.g. "wasmmodules"
+	Path          string   `mapstructure:"path"`
+	Paths         []string `mapstructure:"paths"`
+	ClusterScoped bool     `mapstructure:"cluster-scoped"`
+}
+
+// AllPaths returns Path, if set, followed by Paths.
+func (s ImageSource) AllPaths() []string {
+	if s.Path == "" {
+		return s.Paths
+	}
+	return append([]string{s.Path}, s.Paths...)
 }
 
 // K8sConfig represents the full Kubernetes configuration.
@@ -22,28 +78,287 @@ type K8sConfig struct {
 	Stage        string         `mapstructure:"stage"`
 	ManifestFile string         `mapstructure:"manifest-file"`
 	AuditFile    string         `mapstructure:"audit-file"`
+	AuditAppend  bool           `mapstructure:"audit-append"`
+	// Strict makes the clean stage skip, and fail on, an in-use repository in which no
+	// artifact is in the safe list, instead of deleting everything in it.
+	Strict bool `mapstructure:"strict"`
+	// Explain logs, for every tagged artifact in the clean stage, the manifest key looked up
+	// and, when it is missing, the manifest entries that nearly match it.
+	Explain bool `mapstructure:"explain"`
+	// KeepRetained makes the clean stage also keep the artifacts the harbor strategy's
+	// retention rules (keep-last, max-snapshots, per-repository rules) would keep, so only
+	// artifacts that are both unused and expired are deleted.
+	KeepRetained bool `mapstructure:"keep-retained"`
+}
+
+// QuarantineConfig controls soft-deletion, where expired artifacts are first
+// labelled and only deleted on a later run once the grace period has passed.
+// Artifacts are labelled "<LabelPrefix>-YYYYMMDD" with the day they were quarantined.
+type QuarantineConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	LabelPrefix string `mapstructure:"label-prefix"`
+	GraceDays   int    `mapstructure:"grace-days"`
+}
+
+// RetentionRule overrides the retention settings for repositories matching Pattern.
+// Pattern is matched against the full repository name (e.g. "app/api") and supports * and ?.
+// Fields left unset fall back to the global Harbor settings.
+type RetentionRule struct {
+	Pattern      string `mapstructure:"pattern"`
+	KeepLastN    *int   `mapstructure:"keep-last"`
+	MaxSnapshots *int   `mapstructure:"max-snapshots"`
+	// ProtectedTags, when set, keeps only artifacts with one of these tags (* and ? allowed)
+	// and deletes all others, ignoring counts, ages and the other retention settings.
+	ProtectedTags []string `mapstructure:"protected-tags"`
+	// Architectures replaces harbor.architectures for the matching repositories.
+	Architectures []string `mapstructure:"architectures"`
+}
+
+// MajorVersionConfig keeps the newest artifacts of each major version line. The major
+// version is the first capture group of Pattern applied to the tag; tags that don't
+// match fall back to the keep-last rules.
+type MajorVersionConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Pattern      string `mapstructure:"pattern"`
+	KeepPerMajor int    `mapstructure:"keep-per-major"`
+}
+
+// NativeRetentionConfig configures the Harbor retention policies written by the
+// native-retention strategy.
+type NativeRetentionConfig struct {
+	// Schedule is the 6-field cron expression Harbor runs the policy on; empty means manual only.
+	Schedule string `mapstructure:"schedule"`
 }
 
 // HarborConfig represents the configuration for the Harbor strategy.
 type HarborConfig struct {
-	URL              string `mapstructure:"url"`
-	User             string `mapstructure:"user"`
-	Password         string `mapstructure:"password"`
-	KeepLastN        int    `mapstructure:"keep-last"`
-	MaxSnapshots     int    `mapstructure:"max-snapshots"`
-	PageSize         int    `mapstructure:"page-size"`
-	ProjectWhitelist string `mapstructure:"project-whitelist"`
+	URL          string `mapstructure:"url"`
+	User         string `mapstructure:"user"`
+	Password     string `mapstructure:"password"`
+	KeepLastN    int    `mapstructure:"keep-last"`
+	MaxSnapshots int    `mapstructure:"max-snapshots"`
+	// AliasTags are floating tags (e.g. "latest", "stable"); the artifact each one points to
+	// is always kept, even when its versioned tag falls outside the retention rules.
+	AliasTags []string `mapstructure:"alias-tags"`
+	// SnapshotExcludeTags are snapshot-looking tags (wildcards allowed), such as a permanent
+	// dev-SNAPSHOT pointer, that are always kept and don't use up max-snapshots slots.
+	SnapshotExcludeTags []string `mapstructure:"snapshot-exclude-tags"`
+	// SnapshotWindow is "keep-last" to count snapshots only within the newest keep-last
+	// artifacts, or "independent" to keep the newest max-snapshots snapshots and the newest
+	// keep-last releases separately.
+	SnapshotWindow string `mapstructure:"snapshot-window"`
+	// SortKey orders the artifacts of a repository for retention: "push_time" (the default),
+	// which retagging resets, or "create_time" (the image build time, falling back to the
+	// push time).
+	SortKey  string `mapstructure:"sort-key"`
+	PageSize int    `mapstructure:"page-size"`
+	// RepoConcurrency is the number of repositories the harbor strategy cleans in parallel,
+	// with at most MaxConcurrencyPerProject of them (0 for no limit) from the same project.
+	RepoConcurrency          int `mapstructure:"repo-concurrency"`
+	MaxConcurrencyPerProject int `mapstructure:"max-concurrency-per-project"`
+	// DeleteConcurrency is the number of artifacts of one repository deleted in parallel.
+	DeleteConcurrency int `mapstructure:"delete-concurrency"`
+	// DeleteOrder is the order the expired artifacts of a repository are deleted in:
+	// "oldest-first", "largest-first" or "least-recently-pulled". Empty keeps the order of
+	// the retention decisions.
+	DeleteOrder string `mapstructure:"delete-order"`
+	// MaxConcurrentDeletes caps the DELETE requests in flight across the whole run,
+	// whatever repo-concurrency and delete-concurrency allow. 0 means no cap.
+	MaxConcurrentDeletes int `mapstructure:"max-concurrent-deletes"`
+	// VerifyBeforeDelete re-fetches each artifact by digest right before deleting it and
+	// skips it if its digest or tags no longer match the listing.
+	VerifyBeforeDelete bool `mapstructure:"verify-before-delete"`
+	// ProtectionPreflight skips artifacts Harbor protects beyond immutable tags: signatures,
+	// children of an image index and tags always retained by the project's retention policy.
+	ProtectionPreflight bool `mapstructure:"protection-preflight"`
+	// ProcessNativeRetentionProjects also cleans projects with an active native Harbor
+	// retention policy, which are otherwise skipped so the two mechanisms don't fight.
+	ProcessNativeRetentionProjects bool `mapstructure:"process-native-retention-projects"`
+	// ProtectedProjectMetadata skips every project whose Harbor metadata has one of these
+	// key/value pairs, e.g. {archive: "true"}.
+	ProtectedProjectMetadata map[string]string `mapstructure:"protected-project-metadata"`
+	// MinSizeBytes skips expired artifacts smaller than this, such as signatures and
+	// attestations, which free little space and may be referenced elsewhere.
+	MinSizeBytes int64 `mapstructure:"min-size-bytes"`
+	// CrossRepoDigests never deletes the newest copy of a digest that is present in more
+	// than one repository, e.g. an image promoted from staging to prod, and notes the other
+	// locations when deleting a copy. Not used by the webhook strategy.
+	CrossRepoDigests bool               `mapstructure:"cross-repo-digests"`
+	TimeoutSeconds   int                `mapstructure:"timeout-seconds"`
+	AdaptiveRate     AdaptiveRateConfig `mapstructure:"adaptive-rate"`
+	LatencyGuard     LatencyGuardConfig `mapstructure:"latency-guard"`
+	ProjectWhitelist string             `mapstructure:"project-whitelist"`
+	// MinReposPerProject skips projects with fewer repositories, e.g. for quick triage runs.
+	MinReposPerProject int `mapstructure:"min-repos-per-project"`
+	// OnlyReposMatching limits the cleanup to repositories whose full name matches one of
+	// these patterns (* and ? allowed); empty processes all repositories.
+	OnlyReposMatching []string           `mapstructure:"only-repos-matching"`
+	Quarantine        QuarantineConfig   `mapstructure:"quarantine"`
+	RulesFile         string             `mapstructure:"rules-file"`
+	Rules             []RetentionRule    `mapstructure:"rules"`
+	MajorVersion      MajorVersionConfig `mapstructure:"major-version"`
+	SizeTiers         SizeTiersConfig    `mapstructure:"size-tiers"`
+	// RepoSettings lets repository owners set keep-last and max-snapshots themselves, as
+	// "keep-last=15" in the repository description or a label name on its artifacts. These
+	// replace the configured values, rules included.
+	RepoSettings bool `mapstructure:"repo-settings"`
+	// BaseImageRepos are the repositories (* and ? allowed) holding base images. An expired
+	// artifact of one is kept while all its layers belong to an artifact the run keeps; they
+	// are cleaned after all other repositories, and skipped by the webhook strategy.
+	BaseImageRepos []string `mapstructure:"base-image-repos"`
+	// BatchFile lists the only repositories to process, with an optional keep-last for
+	// each; see BatchEntry.
+	BatchFile string       `mapstructure:"batch-file"`
+	Batch     []BatchEntry `mapstructure:"-"` // Read from BatchFile at startup
+	// Architectures, when set, limits retention to artifacts built only for these CPU
+	// architectures (e.g. "arm64"); others, including multi-arch indexes with another
+	// architecture and artifacts without one, are skipped. Harbor strategy only.
+	Architectures []string `mapstructure:"architectures"`
+	// IncludeUntaggedInCount decides untagged artifacts like tagged ones, so those beyond
+	// keep-last are deleted; otherwise they are skipped, though they still take their place
+	// in keep-last. Harbor strategy only.
+	IncludeUntaggedInCount bool `mapstructure:"include-untagged-in-count"`
+	// PrereleaseWindow keeps semver pre-releases (e.g. 2.0.0-rc.1) of versions without a
+	// final release and expires those whose version has been released.
+	PrereleaseWindow bool `mapstructure:"prerelease-window"`
+	// KeepLatestPerMinor always keeps the newest release (by sort-key) of each major.minor
+	// version found in semver tags, so every minor line can still be rolled back to.
+	KeepLatestPerMinor bool `mapstructure:"keep-latest-per-minor"`
+	// KeepSinceRelease, when above 0, keeps every artifact pushed since the Nth-newest
+	// release (a tag without "SNAPSHOT") and expires everything older, snapshots included.
+	KeepSinceRelease int `mapstructure:"keep-since-release"`
+	// PolicyExpression, when set, replaces the built-in retention rules with an expr-lang
+	// expression evaluated per artifact; it returns true to keep the artifact.
+	PolicyExpression string                `mapstructure:"policy-expression"`
+	NativeRetention  NativeRetentionConfig `mapstructure:"native-retention"`
+	// Since skips repositories without pushes after this time. It is either an RFC 3339
+	// timestamp or "last" to use the time of the previous successful run from LastRunFile.
+	Since       string `mapstructure:"since"`
+	LastRunFile string `mapstructure:"last-run-file"`
+	// CheckpointFile records the repositories completed by the current run, for --resume.
+	CheckpointFile string    `mapstructure:"checkpoint-file"`
+	SinceTime      time.Time `mapstructure:"-"` // Resolved from Since at startup
+	// OwnImages are the image references of the cleaner's own pod, found at startup; the
+	// artifacts they point to are never deleted.
+	OwnImages []string `mapstructure:"-"`
+	// RepoLimit stops the run after this many repositories, from --limit; 0 means no limit.
+	RepoLimit int `mapstructure:"-"`
+}
+
+// WebhookConfig configures the webhook strategy, which cleans a repository whenever
+// Harbor reports a push to it.
+type WebhookConfig struct {
+	Listen string `mapstructure:"listen"`
+	Path   string `mapstructure:"path"`
+	Secret string `mapstructure:"secret"` // Must match the "Auth Header" of the Harbor webhook policy
+	// Insecure lets the server start without a secret, accepting unauthenticated requests
+	// that trigger deletions. Without it, a missing secret is an error.
+	Insecure bool `mapstructure:"insecure"`
+}
+
+// InventoryConfig configures the inventory strategy, which deletes tagged artifacts that
+// are not listed in a desired-state inventory file.
+type InventoryConfig struct {
+	File       string `mapstructure:"file"`
+	GraceHours int    `mapstructure:"grace-hours"`
+}
+
+// SurplusTagsConfig configures the surplus-tags strategy, which removes the oldest tags of
+// artifacts with more than MaxTags tags.
+type SurplusTagsConfig struct {
+	MaxTags int `mapstructure:"max-tags"`
+}
+
+// AgeReportConfig configures the age-report strategy, which counts the artifacts and their
+// size per age bucket. BucketsDays are the upper bounds of the buckets in days, ascending;
+// a last bucket holds everything older.
+type AgeReportConfig struct {
+	BucketsDays []int `mapstructure:"buckets-days"`
+}
+
+// StaleReportConfig configures the stale-report strategy, which lists the artifacts not
+// pulled for more than PullDays days; artifacts never pulled count from their push time.
+type StaleReportConfig struct {
+	PullDays int `mapstructure:"pull-days"`
+}
+
+// ImpactPattern names a category of tags for the deletion breakdown in the run summary.
+// Pattern is matched against the tag and supports * and ?; patterns sharing a Name are
+// counted together.
+type ImpactPattern struct {
+	Name    string `mapstructure:"name"`
+	Pattern string `mapstructure:"pattern"`
 }
 
 // Config stores all configuration of the application.
 // The values are read by viper from a config file or environment variables.
 type Config struct {
-	Strategy string       `mapstructure:"strategy"`
-	K8s      K8sConfig    `mapstructure:"k8s"`
-	Harbor   HarborConfig `mapstructure:"harbor"`
-	DryRun   bool         `mapstructure:"dry-run"`
-	LogLevel string       `mapstructure:"log.level"`
-	LogFile  string       `mapstructure:"log.file"`
+	Strategy    string            `mapstructure:"strategy"`
+	K8s         K8sConfig         `mapstructure:"k8s"`
+	Harbor      HarborConfig      `mapstructure:"harbor"`
+	Webhook     WebhookConfig     `mapstructure:"webhook"`
+	Inventory   InventoryConfig   `mapstructure:"inventory"`
+	SurplusTags SurplusTagsConfig `mapstructure:"surplus-tags"`
+	DryRun      bool              `mapstructure:"dry-run"`
+	AgeReport   AgeReportConfig   `mapstructure:"age-report"`
+	StaleReport StaleReportConfig `mapstructure:"stale-report"`
+	// GraceHours keeps every artifact pushed in the last GraceHours hours, whatever the
+	// strategy's rules decide, e.g. to avoid racing in-flight CI pushes. 0 disables it.
+	GraceHours int `mapstructure:"grace-hours"`
+	// ContinueOnError processes the projects and repositories listed before a list request
+	// failed part-way, instead of aborting (projects) or skipping the project (repositories).
+	ContinueOnError bool `mapstructure:"continue-on-error"`
+	// ImpactPatterns classifies deleted tags in the summary; the first matching pattern wins.
+	ImpactPatterns []ImpactPattern `mapstructure:"impact-patterns"`
+	// AuditColumns selects and orders the audit report columns (see AuditColumnNames); empty
+	// keeps each strategy's own columns.
+	AuditColumns []string `mapstructure:"audit-columns"`
+	// AuditGroupByStatus groups the audit report by status, then image, instead of ordering
+	// it by repository, so a dry-run's deletions can be reviewed together.
+	AuditGroupByStatus bool `mapstructure:"audit-group-by-status"`
+	// AuditPerProject writes the audit records of each project to its own
+	// audit-<project>-<timestamp>.csv next to the audit report: "also" in addition to the
+	// combined report, "only" instead of it. Empty writes only the combined report.
+	AuditPerProject string `mapstructure:"audit-per-project"`
+	// AuditDB, when set, is a local SQLite database each run appends its audit records to,
+	// next to the CSV report, for historical queries.
+	AuditDB string `mapstructure:"audit-db"`
+	// InventoryExportFile, when set, receives a snapshot of every artifact before a run that
+	// can delete anything. Unrelated to the inventory strategy's input file.
+	InventoryExportFile string `mapstructure:"inventory-export-file"`
+	// SlowestRepos is the number of slowest repositories and projects listed in the summary.
+	SlowestRepos int `mapstructure:"slowest-repos"`
+	// MetricsFile, when set, receives the repository durations as a Prometheus histogram.
+	MetricsFile string `mapstructure:"metrics-file"`
+	// AlertThreshold, when above 0, makes a dry run alert if it finds more delete candidates.
+	// The alert is posted to AlertWebhookURL, or only logged if that is empty.
+	AlertThreshold  int    `mapstructure:"alert-threshold"`
+	AlertWebhookURL string `mapstructure:"alert-webhook-url"`
+	// ExpectDeletions, when set, makes a dry run fail if its deletions fall outside these
+	// bounds, to catch retention regressions in CI.
+	ExpectDeletions ExpectDeletionsConfig `mapstructure:"expect-deletions"`
+	// PostRunCommand runs a command with the run summary in its environment once a
+	// deleting strategy has finished, e.g. to start garbage collection.
+	PostRunCommand PostRunCommandConfig `mapstructure:"post-run-command"`
+	// Labels are attached to the alert payload and to every metric series, so one shared
+	// deployment can emit signals that route per team. Keys are lower-cased when loaded.
+	Labels map[string]string `mapstructure:"labels"`
+	// MaxRunDuration stops the run gracefully once exceeded (e.g. "50m"), so a scheduler's
+	// hard deadline never kills it mid-delete. Zero means no limit.
+	MaxRunDuration time.Duration `mapstructure:"max-run-duration"`
+	// LogLevel is "debug", "info" (the default), "warn" or "error". At "warn" and above the
+	// per-artifact lines are left out of the log; the audit report still has every artifact.
+	LogLevel string `mapstructure:"log.level"`
+	LogFile  string `mapstructure:"log.file"`
+	// LogFileFormat is "text" (the default) or "json" for JSON lines in the log file, e.g.
+	// for Loki; stdout stays text.
+	LogFileFormat string `mapstructure:"log.file-format"`
+	// LogRetentionDays deletes the cleaner's default-named log files older than this many
+	// days from the log directory at startup. 0 keeps them all.
+	LogRetentionDays int `mapstructure:"log.retention-days"`
+	// RunID identifies the run in the logs, audit reports, manifest, metrics and alerts; set
+	// at startup.
+	RunID string `mapstructure:"-"`
 }
 
 // LoadConfig reads configuration from file or environment variables.
@@ -54,14 +369,360 @@ func LoadConfig(path string) (config Config, err error) {
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
 
+	v.SetDefault("harbor.quarantine.label-prefix", "quarantine")
+	v.SetDefault("harbor.quarantine.grace-days", 7)
+	v.SetDefault("inventory.grace-hours", 24)
+	v.SetDefault("surplus-tags.max-tags", 10)
+	v.SetDefault("age-report.buckets-days", []int{1, 7, 30, 90})
+	v.SetDefault("stale-report.pull-days", 90)
+	v.SetDefault("post-run-command.timeout-seconds", 300)
+	v.SetDefault("webhook.listen", ":8080")
+	v.SetDefault("webhook.path", "/webhook")
+	v.SetDefault("harbor.timeout-seconds", 30)
+	v.SetDefault("harbor.adaptive-rate.min-requests-per-second", 1)
+	v.SetDefault("harbor.adaptive-rate.max-requests-per-second", 50)
+	v.SetDefault("harbor.adaptive-rate.retries", 5)
+	v.SetDefault("harbor.latency-guard.window", 20)
+	v.SetDefault("harbor.latency-guard.factor", 3)
+	v.SetDefault("harbor.latency-guard.pause-seconds", 30)
+	v.SetDefault("harbor.delete-concurrency", 1)
+	v.SetDefault("harbor.repo-concurrency", 1)
+	v.SetDefault("harbor.snapshot-window", "keep-last")
+	v.SetDefault("harbor.sort-key", "push_time")
+	v.SetDefault("harbor.native-retention.schedule", "0 0 0 * * *")
+	v.SetDefault("harbor.last-run-file", ".harbor-cleaner-last-run")
+	v.SetDefault("harbor.checkpoint-file", ".harbor-cleaner-checkpoint")
+	v.SetDefault("harbor.major-version.pattern", `^v?(\d+)\.`)
+	v.SetDefault("harbor.major-version.keep-per-major", 3)
+	v.SetDefault("slowest-repos", 5)
+	v.SetDefault("impact-patterns", []map[string]string{
+		{"name": "snapshot", "pattern": "*SNAPSHOT*"},
+		{"name": "release-candidate", "pattern": "*-rc*"},
+		{"name": "release-candidate", "pattern": "rc-*"},
+		{"name": "release", "pattern": "*.*"},
+	})
+
 	if err = v.ReadInConfig(); err != nil {
 		return
 	}
 
-	err = v.Unmarshal(&config)
+	if err = v.Unmarshal(&config); err != nil {
+		return
+	}
+	// Unmarshal only sees nested keys, so the dotted log settings, whether written as
+	// "log.level: ..." or nested under "log:", are read by path.
+	config.LogLevel = v.GetString("log.level")
+	config.LogFile = v.GetString("log.file")
+	config.LogFileFormat = v.GetString("log.file-format")
+	config.LogRetentionDays = v.GetInt("log.retention-days")
+
+	if config.Harbor.RulesFile != "" {
+		var fileRules []RetentionRule
+		if fileRules, err = loadRetentionRules(config.Harbor.RulesFile); err != nil {
+			return
+		}
+		config.Harbor.Rules = append(config.Harbor.Rules, fileRules...)
+	}
+	if config.Harbor.BatchFile != "" {
+		var entries []BatchEntry
+		if entries, err = loadBatchFile(config.Harbor.BatchFile); err != nil {
+			return
+		}
+		if err = config.Harbor.applyBatch(entries); err != nil {
+			return
+		}
+	}
+	sortRetentionRules(config.Harbor.Rules)
+	if config.Harbor.URL != "" {
+		if config.Harbor.URL, err = harbor.NormalizeURL(config.Harbor.URL); err != nil {
+			return
+		}
+	}
+	for i := range config.K8s.Environments {
+		images := &config.K8s.Environments[i].ConfigImages
+		if images.Enabled && len(images.Registries) == 0 && config.Harbor.URL != "" {
+			images.Registries = []string{RegistryHost(config.Harbor.URL)}
+		}
+	}
+
+	err = config.Validate()
 	return
 }
 
+// Strategies lists the valid values of strategy.
+var Strategies = []string{"harbor", "k8s", "webhook", "inventory", "native-retention", "duplicates", "orphaned-tags", "surplus-tags", "age-report", "stale-report"}
+
+// Validate checks settings that only make sense together, so a misconfiguration fails at
+// startup instead of being silently ignored.
+func (c *Config) Validate() error {
+	if !slices.Contains(Strategies, c.Strategy) {
+		return fmt.Errorf("unknown strategy %q, expected one of %s", c.Strategy, strings.Join(Strategies, ", "))
+	}
+	switch {
+	case c.Strategy == "k8s" && c.K8s.Stage != "scan" && c.K8s.Stage != "clean":
+		return fmt.Errorf("the k8s strategy needs k8s.stage \"scan\" or \"clean\", got %q", c.K8s.Stage)
+	case c.Strategy != "k8s" && c.K8s.Stage != "":
+		return fmt.Errorf("k8s.stage %q is only used by the k8s strategy, but strategy is %q; remove it or use strategy \"k8s\"", c.K8s.Stage, c.Strategy)
+	case c.Strategy == "k8s" && c.K8s.Stage == "scan" && len(c.K8s.Environments) == 0:
+		return fmt.Errorf("the k8s scan stage needs at least one entry in k8s.environments; without one the safe list is empty and the clean stage would delete every image")
+	}
+	if c.LogRetentionDays < 0 {
+		return fmt.Errorf("log.retention-days must not be negative, got %d", c.LogRetentionDays)
+	}
+	if c.GraceHours < 0 {
+		return fmt.Errorf("grace-hours must not be negative, got %d", c.GraceHours)
+	}
+	if guard := c.Harbor.LatencyGuard; guard.Enabled && (guard.Window < 1 || guard.Factor <= 1 || guard.PauseSeconds < 1) {
+		return fmt.Errorf("harbor.latency-guard needs a window and pause-seconds of at least 1 and a factor above 1, got %d, %d and %g", guard.Window, guard.PauseSeconds, guard.Factor)
+	}
+	if e := c.ExpectDeletions; e.Max != nil && *e.Max < e.Min {
+		return fmt.Errorf("expect-deletions.max (%d) is below expect-deletions.min (%d)", *e.Max, e.Min)
+	}
+	for i, b := range c.ExpectDeletions.Repos {
+		if b.Pattern == "" {
+			return fmt.Errorf("expect-deletions.repos entry %d has an empty pattern", i+1)
+		}
+		if b.Max != nil && *b.Max < b.Min {
+			return fmt.Errorf("expect-deletions.repos entry %q has max (%d) below min (%d)", b.Pattern, *b.Max, b.Min)
+		}
+	}
+	if len(c.PostRunCommand.Command) > 0 && c.PostRunCommand.TimeoutSeconds < 1 {
+		return fmt.Errorf("post-run-command.timeout-seconds must be at least 1, got %d", c.PostRunCommand.TimeoutSeconds)
+	}
+	if c.Strategy == "surplus-tags" && c.SurplusTags.MaxTags < 1 {
+		return fmt.Errorf("surplus-tags.max-tags must be at least 1, got %d", c.SurplusTags.MaxTags)
+	}
+	if c.Strategy == "age-report" {
+		buckets := c.AgeReport.BucketsDays
+		if len(buckets) == 0 || buckets[0] < 1 || !slices.IsSorted(buckets) || len(slices.Compact(slices.Clone(buckets))) != len(buckets) {
+			return fmt.Errorf("age-report.buckets-days must list days of at least 1 in ascending order, got %v", buckets)
+		}
+	}
+	if c.Strategy == "stale-report" && c.StaleReport.PullDays < 1 {
+		return fmt.Errorf("stale-report.pull-days must be at least 1, got %d", c.StaleReport.PullDays)
+	}
+	for _, env := range c.K8s.Environments {
+		for _, s := range env.ImageSources {
+			if s.Resource == "" || s.Version == "" || len(s.AllPaths()) == 0 {
+				return fmt.Errorf("image source %q in environment %q needs a version, a resource and a path or paths", s.Resource, env.Name)
+			}
+		}
+		if env.ConfigImages.Enabled && len(env.ConfigImages.Registries) == 0 {
+			return fmt.Errorf("environment %q enables config-images, but neither config-images.registries nor harbor.url is set", env.Name)
+		}
+	}
+	switch c.AuditPerProject {
+	case "", "also", "only":
+	default:
+		return fmt.Errorf("invalid audit-per-project %q, expected \"also\" or \"only\"", c.AuditPerProject)
+	}
+	switch c.Harbor.DeleteOrder {
+	case "", "oldest-first", "largest-first", "least-recently-pulled":
+	default:
+		return fmt.Errorf("invalid harbor.delete-order %q, expected \"oldest-first\", \"largest-first\" or \"least-recently-pulled\"", c.Harbor.DeleteOrder)
+	}
+	for name := range c.Labels {
+		if !labelName.MatchString(name) || name == "project" || name == "le" || name == "age" {
+			return fmt.Errorf("invalid label name %q: use letters, digits and underscores, not starting with a digit, and not \"project\", \"le\" or \"age\"", name)
+		}
+	}
+	if strings.Contains(c.AuditDB, "://") {
+		return fmt.Errorf("audit-db must be a local file, got %q", c.AuditDB)
+	}
+	switch c.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid log.level %q, expected \"debug\", \"info\", \"warn\" or \"error\"", c.LogLevel)
+	}
+	switch c.LogFileFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid log.file-format %q, expected \"text\" or \"json\"", c.LogFileFormat)
+	}
+	for _, column := range c.AuditColumns {
+		if !isAuditColumn(column) {
+			return fmt.Errorf("unknown audit column %q, expected one of %s", column, strings.Join(AuditColumnNames, ", "))
+		}
+	}
+	return nil
+}
+
+// labelName matches a valid Prometheus label name.
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Quiet reports whether per-artifact log lines are suppressed, leaving the per-repository
+// summaries and the final report.
+func (c *Config) Quiet() bool {
+	return c.LogLevel == "warn" || c.LogLevel == "error"
+}
+
+// RegistryHost returns the registry host of a Harbor URL, with its port, as it appears in
+// image references.
+func RegistryHost(harborURL string) string {
+	host := strings.TrimPrefix(harborURL, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	return strings.TrimSuffix(host, "/")
+}
+
+// AuditColumnNames are the columns audit-columns can select.
+var AuditColumnNames = []string{"image", "tags", "digest", "status", "notes", "size", "pushTime", "pullTime", "envs", "namespaces", "runId"}
+
+func isAuditColumn(name string) bool {
+	for _, c := range AuditColumnNames {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRetentionRules reads a YAML rules file with a top-level "rules" list.
+func loadRetentionRules(path string) ([]RetentionRule, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read rules file %s: %w", path, err)
+	}
+
+	var file struct {
+		Rules []RetentionRule `mapstructure:"rules"`
+	}
+	if err := v.Unmarshal(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+	for i, r := range file.Rules {
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("rule %d in %s has an empty pattern", i+1, path)
+		}
+	}
+	return file.Rules, nil
+}
+
+// sortRetentionRules orders rules most-specific-first: exact names before wildcard patterns,
+// then by the number of literal characters. Rules of equal specificity keep their file order.
+func sortRetentionRules(rules []RetentionRule) {
+	specificity := func(pattern string) int {
+		literal := len(pattern) - strings.Count(pattern, "*") - strings.Count(pattern, "?")
+		if literal == len(pattern) {
+			return literal + 1<<16 // Exact names always win
+		}
+		return literal
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return specificity(rules[i].Pattern) > specificity(rules[j].Pattern)
+	})
+}
+
+// MatchRetentionRule returns the most specific rule matching repoName, or nil if none matches.
+func (h *HarborConfig) MatchRetentionRule(repoName string) *RetentionRule {
+	for i := range h.Rules {
+		if MatchWildcard(h.Rules[i].Pattern, repoName) {
+			return &h.Rules[i]
+		}
+	}
+	return nil
+}
+
+// SizeTiersConfig applies stricter retention to the largest repositories of a project. A
+// repository's size is the sum of its artifact sizes; its percentile is the share of the
+// project's repositories that are smaller. The tier with the highest Percentile at or below
+// the repository's percentile replaces the default keep-last and max-snapshots; per-repository
+// rules still take precedence. Tiers only apply while the project's total size is above
+// TargetQuotaGB (0 to always apply them).
+type SizeTiersConfig struct {
+	Enabled       bool       `mapstructure:"enabled"`
+	TargetQuotaGB float64    `mapstructure:"target-quota-gb"`
+	Tiers         []SizeTier `mapstructure:"tiers"`
+}
+
+// SizeTier is the retention for repositories at or above a size percentile. MaxSnapshots
+// of 0 keeps the default max-snapshots.
+type SizeTier struct {
+	Percentile   float64 `mapstructure:"percentile"`
+	KeepLastN    int     `mapstructure:"keep-last"`
+	MaxSnapshots int     `mapstructure:"max-snapshots"`
+}
+
+// AdaptiveRateConfig paces all Harbor API requests of a run at a shared rate that halves
+// whenever Harbor answers 429 or 503 and recovers while requests succeed.
+type AdaptiveRateConfig struct {
+	Enabled bool    `mapstructure:"enabled"`
+	Min     float64 `mapstructure:"min-requests-per-second"`
+	Max     float64 `mapstructure:"max-requests-per-second"`
+	Retries int     `mapstructure:"retries"` // Attempts after a throttled response before giving up
+}
+
+// LatencyGuardConfig pauses deletions when the average response time of the last Window
+// requests exceeds Factor times the lowest such average of the run.
+type LatencyGuardConfig struct {
+	Enabled      bool    `mapstructure:"enabled"`
+	Window       int     `mapstructure:"window"`
+	Factor       float64 `mapstructure:"factor"`
+	PauseSeconds int     `mapstructure:"pause-seconds"`
+}
+
+// ExpectDeletionsConfig bounds the deletions of a dry run: Min and Max apply to the total,
+// Repos to every repository matching a pattern. A nil Max means no upper bound.
+type ExpectDeletionsConfig struct {
+	Min   int                  `mapstructure:"min"`
+	Max   *int                 `mapstructure:"max"`
+	Repos []RepoDeletionBounds `mapstructure:"repos"`
+}
+
+// PostRunCommandConfig is a command run after the run. Command is the program and its
+// arguments, run without a shell; wrap it in ["sh", "-c", "..."] to use one.
+type PostRunCommandConfig struct {
+	Command        []string `mapstructure:"command"`
+	OnDryRun       bool     `mapstructure:"on-dry-run"` // Also run after dry runs
+	TimeoutSeconds int      `mapstructure:"timeout-seconds"`
+}
+
+// RepoDeletionBounds bounds the deletions of each repository matching Pattern (* and ?
+// allowed). A repository is checked against the first matching entry only.
+type RepoDeletionBounds struct {
+	Pattern string `mapstructure:"pattern"`
+	Min     int    `mapstructure:"min"`
+	Max     *int   `mapstructure:"max"`
+}
+
+// Enabled reports whether any bound is set.
+func (e *ExpectDeletionsConfig) Enabled() bool {
+	return e.Min > 0 || e.Max != nil || len(e.Repos) > 0
+}
+
+// Timeout returns the per-request HTTP timeout for the Harbor API.
+func (h *HarborConfig) Timeout() time.Duration {
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// RetentionFor resolves the keep-last and max-snapshots settings for a repository and
+// describes where they came from, for logging.
+func (h *HarborConfig) RetentionFor(repoName string) (keepLastN, maxSnapshots int, source string) {
+	keepLastN, maxSnapshots, source = h.KeepLastN, h.MaxSnapshots, "default"
+	rule := h.MatchRetentionRule(repoName)
+	if rule == nil {
+		return
+	}
+	if rule.KeepLastN != nil {
+		keepLastN = *rule.KeepLastN
+	}
+	if rule.MaxSnapshots != nil {
+		maxSnapshots = *rule.MaxSnapshots
+	}
+	source = fmt.Sprintf("rule '%s'", rule.Pattern)
+	return
+}
+
+// ArchitecturesFor returns the architectures retention is limited to for a repository,
+// from its most specific rule or harbor.architectures; empty means all.
+func (h *HarborConfig) ArchitecturesFor(repoName string) []string {
+	if rule := h.MatchRetentionRule(repoName); rule != nil && len(rule.Architectures) > 0 {
+		return rule.Architectures
+	}
+	return h.Architectures
+}
+
 // MatchWildcard checks if a string matches a pattern with wildcards (* and ?)
 func MatchWildcard(pattern, str string) bool {
 	return matchWildcardHelper(pattern, str, 0, 0)
@@ -69,7 +730,7 @@ func MatchWildcard(pattern, str string) bool {
 
 func matchWildcardHelper(pattern, str string, pIdx, sIdx int) bool {
 	pLen, sLen := len(pattern), len(str)
-	
+
 	for pIdx < pLen {
 		if sIdx >= sLen {
 			// String exhausted, check if remaining pattern is all *
@@ -78,7 +739,7 @@ func matchWildcardHelper(pattern, str string, pIdx, sIdx int) bool {
 			}
 			return pIdx == pLen
 		}
-		
+
 		if pattern[pIdx] == '*' {
 			// Try matching 0 or more characters
 			for pIdx < pLen && pattern[pIdx] == '*' {
@@ -95,7 +756,7 @@ func matchWildcardHelper(pattern, str string, pIdx, sIdx int) bool {
 			}
 			return false
 		}
-		
+
 		if pattern[pIdx] == '?' || pattern[pIdx] == str[sIdx] {
 			pIdx++
 			sIdx++
@@ -103,7 +764,7 @@ func matchWildcardHelper(pattern, str string, pIdx, sIdx int) bool {
 			return false
 		}
 	}
-	
+
 	return sIdx == sLen
 }
 
@@ -118,7 +779,7 @@ func ShouldProcessWorkload(workloadName string, whitelist, blacklist []string) b
 			}
 		}
 	}
-	
+
 	// If whitelist is provided, only process if workload matches
 	if len(whitelist) > 0 {
 		for _, pattern := range whitelist {
@@ -128,7 +789,7 @@ func ShouldProcessWorkload(workloadName string, whitelist, blacklist []string) b
 		}
 		return false
 	}
-	
+
 	// No filters, process all
 	return true
 }
@@ -5,19 +5,26 @@
 package harbor
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"harbor-cleaner/internal/redact"
 )
 
 const (
 	// apiBase defines the base path for the Harbor v2.0 API.
 	apiBase = "/api/v2.0"
+	// defaultTimeout is the per-request timeout used when none is configured.
+	defaultTimeout = 30 * time.Second
 )
 
 // --- Harbor API Response Structs ---
@@ -26,23 +33,98 @@ const (
 type Project struct {
 	ProjectID int    `json:"project_id"`
 	Name      string `json:"name"`
+	RepoCount int    `json:"repo_count"`
+	// Metadata holds project settings as strings, e.g. "retention_id".
+	Metadata map[string]string `json:"metadata"`
 }
 
 // Repository represents a repository within a project.
 type Repository struct {
-	Name string `json:"name"` // Full name like 'library/ubuntu'
+	Name       string    `json:"name"` // Full name like 'library/ubuntu'
+	UpdateTime time.Time `json:"update_time"`
+	// Description is the free text set on the repository in Harbor.
+	Description string `json:"description"`
 }
 
 // Artifact represents an image or other artifact in Harbor.
 type Artifact struct {
 	Digest   string    `json:"digest"`
 	PushTime time.Time `json:"push_time"`
+	PullTime time.Time `json:"pull_time"` // Zero if never pulled
+	Size     int64     `json:"size"`      // Bytes
 	Tags     []Tag     `json:"tags"`
+	Labels   []Label   `json:"labels"`
+	// References lists the children of an image index; Harbor refuses to delete a child
+	// while an index references it.
+	References []Reference `json:"references"`
+	// ExtraAttrs holds type-specific metadata; for images it includes "created", the build
+	// time from the image config, which retagging doesn't change.
+	ExtraAttrs map[string]any `json:"extra_attrs"`
+}
+
+// minCreateTime is the earliest image creation time taken at face value. Reproducible
+// builds set the creation time to the Unix epoch (or zero), which says nothing about age.
+var minCreateTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// CreateTime returns when the image was built according to its config, or the push time if
+// the artifact has no usable creation time (not an image, or a reproducible build).
+func (a Artifact) CreateTime() time.Time {
+	created, _ := a.ExtraAttrs["created"].(string)
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil || t.Before(minCreateTime) {
+		return a.PushTime
+	}
+	return t
+}
+
+// Reference links an image index to one of its child artifacts.
+type Reference struct {
+	ChildDigest string    `json:"child_digest"`
+	Platform    *Platform `json:"platform"` // Nil if the index doesn't say
+}
+
+// Platform is the platform an image is built for.
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// Architectures returns the CPU architectures of the artifact: those of the children of an
+// image index, or the one in an image's config. It is empty for other artifacts, such as
+// Helm charts, and for images that don't record one. Children with the platform
+// "unknown", which BuildKit uses for attestations, are left out.
+func (a Artifact) Architectures() []string {
+	var archs []string
+	for _, ref := range a.References {
+		if ref.Platform == nil || ref.Platform.Architecture == "" || ref.Platform.Architecture == "unknown" {
+			continue
+		}
+		if !slices.Contains(archs, ref.Platform.Architecture) {
+			archs = append(archs, ref.Platform.Architecture)
+		}
+	}
+	if len(a.References) == 0 {
+		if arch, _ := a.ExtraAttrs["architecture"].(string); arch != "" {
+			archs = append(archs, arch)
+		}
+	}
+	return archs
 }
 
 // Tag represents a tag associated with an artifact.
+// PushTime is tracked per tag, so re-tagging an old artifact gives the new tag a fresh push time.
 type Tag struct {
-	Name string `json:"name"`
+	Name     string    `json:"name"`
+	PushTime time.Time `json:"push_time"`
+}
+
+// Label represents a Harbor label that can be attached to artifacts.
+type Label struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Scope       string `json:"scope"`
+	ProjectID   int    `json:"project_id"`
 }
 
 // --- Harbor Client ---
@@ -54,133 +136,456 @@ type HarborClient struct {
 	Password   string
 	PageSize   int // Page size for paginated API requests.
 	HttpClient *http.Client
+	// Limiter, if set, paces all requests and adapts to throttling; throttled requests
+	// (429/503) are then retried up to Retries times.
+	Limiter *AdaptiveLimiter
+	Retries int
+	// Latency, if set, holds back deletions while Harbor's response times are well above
+	// their baseline.
+	Latency *LatencyGuard
+	// deleteSlots, if set, bounds the DELETE requests in flight across all goroutines.
+	deleteSlots chan struct{}
+}
+
+// LimitConcurrentDeletes caps the DELETE requests in flight at once to n, however the
+// callers fan out. n <= 0 removes the cap. Call it before the client is shared.
+func (c *HarborClient) LimitConcurrentDeletes(n int) {
+	c.deleteSlots = nil
+	if n > 0 {
+		c.deleteSlots = make(chan struct{}, n)
+	}
+}
+
+// NormalizeURL checks a Harbor URL and returns it as BaseURL expects it: an http or https
+// URL with a host, without trailing slashes and without the API path, which is removed if
+// it was included by mistake (e.g. "https://harbor.example.com/api/v2.0/"). A path before
+// the API path, as behind a reverse proxy, is kept, and so is a bare "/api", which may be
+// such a path.
+func NormalizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		suggested := redact.URL("https://" + strings.TrimLeft(raw, "/"))
+		return "", fmt.Errorf("harbor URL %q has no scheme, use e.g. %q", strings.TrimPrefix(suggested, "https://"), suggested)
+	}
+	shown := redact.URL(raw)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid harbor URL %q: %w", shown, redact.Error(err))
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("harbor URL %q must use http or https, not %q", shown, u.Scheme)
+	case u.Host == "":
+		return "", fmt.Errorf("harbor URL %q has no host", shown)
+	case u.User != nil:
+		return "", fmt.Errorf("harbor URL %q must not contain credentials, set harbor.user and harbor.password instead", shown)
+	case u.RawQuery != "" || u.Fragment != "":
+		return "", fmt.Errorf("harbor URL %q must not have a query or fragment", shown)
+	}
+	path := strings.TrimRight(u.Path, "/")
+	path = strings.TrimSuffix(path, apiBase)
+	u.Path, u.RawPath = strings.TrimRight(path, "/"), ""
+	return u.String(), nil
 }
 
 // NewHarborClient creates and configures a new HarborClient.
-func NewHarborClient(url, user, pass string, pageSize int) (*HarborClient, error) {
+//
+// timeout bounds each individual HTTP request (including reading the response body), not the
+// whole run; zero or negative selects the 30s default. It is enforced independently of any
+// context deadline on a request, so whichever of the two is shorter wins. Keep it generous
+// enough for the largest list page and use context deadlines to bound overall run time.
+func NewHarborClient(url, user, pass string, pageSize int, timeout time.Duration) (*HarborClient, error) {
 	if url == "" || user == "" || pass == "" {
 		return nil, fmt.Errorf("harbor URL, username, and password must be provided")
 	}
+	baseURL, err := NormalizeURL(url)
+	if err != nil {
+		return nil, err
+	}
 	if pageSize <= 0 {
 		pageSize = 100 // Use a sensible default if an invalid size is provided.
 	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
 	return &HarborClient{
-		BaseURL:    strings.TrimSuffix(url, "/"),
+		BaseURL:    baseURL,
 		Username:   user,
 		Password:   pass,
 		PageSize:   pageSize,
-		HttpClient: &http.Client{Timeout: 30 * time.Second},
+		HttpClient: &http.Client{Timeout: timeout},
 	}, nil
 }
 
 // doRequest is a helper function to make authenticated requests to the Harbor API.
 func (c *HarborClient) doRequest(method, path string, queryParams url.Values) ([]byte, error) {
+	return c.doRequestWithBody(method, path, queryParams, nil)
+}
+
+// doRequestWithBody is like doRequest but sends payload JSON-encoded as the request body.
+func (c *HarborClient) doRequestWithBody(method, path string, queryParams url.Values, payload interface{}) ([]byte, error) {
+	var data []byte
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+	}
+	body, _, err := c.doRequestURL(method, c.apiURL(path, queryParams), data)
+	return body, err
+}
+
+// apiURL returns the URL of an API path with the given query parameters.
+func (c *HarborClient) apiURL(path string, queryParams url.Values) string {
 	fullURL := fmt.Sprintf("%s%s%s", c.BaseURL, apiBase, path)
-	if queryParams != nil && len(queryParams) > 0 {
+	if len(queryParams) > 0 {
 		fullURL += "?" + queryParams.Encode()
 	}
+	return fullURL
+}
 
-	req, err := http.NewRequest(method, fullURL, nil)
+// doRequestURL sends a request to a full URL, retrying throttled requests when the adaptive
+// rate limiter is enabled, and returns the response body and headers. Errors are redacted,
+// since they contain the URL and may echo the request.
+func (c *HarborClient) doRequestURL(method, fullURL string, data []byte) ([]byte, http.Header, error) {
+	for attempt := 0; ; attempt++ {
+		body, header, status, err := c.send(method, fullURL, data)
+		err = redact.Error(err, c.Password)
+		if c.Limiter == nil {
+			return body, header, err
+		}
+		if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+			if err == nil {
+				c.Limiter.Succeeded()
+			}
+			return body, header, err
+		}
+		c.Limiter.Throttled(status)
+		if attempt >= c.Retries {
+			return nil, nil, err
+		}
+	}
+}
+
+// send performs a single request, waiting for a delete slot and the limiter first if
+// configured. It returns the response headers and status (0 if no response was received)
+// so callers can follow pagination links and react to throttling.
+func (c *HarborClient) send(method, fullURL string, data []byte) ([]byte, http.Header, int, error) {
+	var reqBody io.Reader
+	if data != nil {
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, fullURL, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.SetBasicAuth(c.Username, c.Password)
 	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
+	if method == http.MethodDelete && c.Latency != nil {
+		c.Latency.Wait()
+	}
+	if method == http.MethodDelete && c.deleteSlots != nil {
+		c.deleteSlots <- struct{}{}
+		defer func() { <-c.deleteSlots }()
+	}
+	if c.Limiter != nil {
+		c.Limiter.Wait()
+	}
+	start := time.Now()
 	resp, err := c.HttpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request to %s: %w", fullURL, err)
+		return nil, nil, 0, fmt.Errorf("failed to execute request to %s: %w", fullURL, err)
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if c.Latency != nil {
+		c.Latency.Observe(time.Since(start))
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request to %s failed with status %d: %s", fullURL, resp.StatusCode, string(body))
+		return nil, resp.Header, resp.StatusCode, fmt.Errorf("API request to %s failed with status %d: %s", fullURL, resp.StatusCode, string(body))
 	}
+	return body, resp.Header, resp.StatusCode, err
+}
+
+// PartialListError reports a list request that failed after earlier pages succeeded.
+// The list functions that support partial results return those items along with it.
+type PartialListError struct {
+	Path    string
+	Page    int // The page that failed
+	Fetched int // Items fetched from the earlier pages
+	Err     error
+}
 
-	return io.ReadAll(resp.Body)
+func (e *PartialListError) Error() string {
+	return fmt.Sprintf("failed on page %d for path %s after fetching %d items: %v", e.Page, e.Path, e.Fetched, e.Err)
+}
+
+func (e *PartialListError) Unwrap() error {
+	return e.Err
 }
 
 // fetchAllPages is a generic helper to handle pagination for any list request.
 func (c *HarborClient) fetchAllPages(path string, initialParams url.Values) ([]byte, error) {
 	var allResults []json.RawMessage
-	page := 1
+	err := c.eachPage(path, initialParams, func(pageResults []json.RawMessage) error {
+		allResults = append(allResults, pageResults...)
+		return nil
+	})
+	var partial *PartialListError
+	if errors.As(err, &partial) {
+		body, _ := json.Marshal(allResults)
+		return body, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(allResults)
+}
 
-	for {
-		params := url.Values{}
-		if initialParams != nil {
-			for k, v := range initialParams {
-				params[k] = v
-			}
-		}
-		params.Set("page", strconv.Itoa(page))
-		params.Set("page_size", strconv.Itoa(c.PageSize)) // Use PageSize from the client struct.
+// eachPage requests the pages of a list request one at a time and passes each to fn as it
+// arrives, so callers that don't need the whole list keep only one page in memory. The next
+// page is the one Harbor's Link header names as rel="next", and the list ends on a response
+// with a Link header but no next link. Without a Link header, the page number is
+// incremented until an empty page. It stops at the first error from fn, which is returned
+// as is. A request that fails after earlier pages succeeded is reported as a
+// *PartialListError.
+func (c *HarborClient) eachPage(path string, initialParams url.Values, fn func([]json.RawMessage) error) error {
+	params := url.Values{}
+	for k, v := range initialParams {
+		params[k] = v
+	}
+	params.Set("page", "1")
+	params.Set("page_size", strconv.Itoa(c.PageSize)) // Use PageSize from the client struct.
+	pageURL := c.apiURL(path, params)
 
-		body, err := c.doRequest("GET", path, params)
+	fetched := 0
+	for page := 1; ; page++ {
+		body, header, err := c.doRequestURL("GET", pageURL, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed on page %d for path %s: %w", page, path, err)
+			if fetched > 0 {
+				return &PartialListError{Path: path, Page: page, Fetched: fetched, Err: err}
+			}
+			return fmt.Errorf("failed on page %d for path %s: %w", page, path, err)
 		}
 
 		var pageResults []json.RawMessage
 		if err := json.Unmarshal(body, &pageResults); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal page %d for path %s: %w", page, path, err)
+			return fmt.Errorf("failed to unmarshal page %d for path %s: %w", page, path, err)
 		}
 
 		if len(pageResults) == 0 {
-			// No more results, break the loop.
-			break
+			// No more results.
+			return nil
 		}
+		if err := fn(pageResults); err != nil {
+			return err
+		}
+		fetched += len(pageResults)
 
-		allResults = append(allResults, pageResults...)
-		page++
+		links := header.Values("Link")
+		if len(links) == 0 {
+			params.Set("page", strconv.Itoa(page+1))
+			pageURL = c.apiURL(path, params)
+			continue
+		}
+		next, err := c.nextPageURL(links)
+		if err != nil {
+			return &PartialListError{Path: path, Page: page + 1, Fetched: fetched, Err: err}
+		}
+		if next == "" {
+			return nil
+		}
+		if next == pageURL {
+			return &PartialListError{Path: path, Page: page + 1, Fetched: fetched, Err: fmt.Errorf("next page link repeats the current page %s", next)}
+		}
+		pageURL = next
 	}
+}
 
-	return json.Marshal(allResults)
+// nextPageURL returns the absolute URL of the rel="next" link in RFC 8288 Link header values
+// such as `</api/v2.0/projects?page=2&page_size=10>; rel="next"`, or "" if there is none.
+func (c *HarborClient) nextPageURL(links []string) (string, error) {
+	for _, value := range links {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			isNext := false
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(name, "rel") && slices.Contains(strings.Fields(strings.Trim(value, `"`)), "next") {
+					isNext = true
+				}
+			}
+			if !isNext {
+				continue
+			}
+			base, err := url.Parse(c.BaseURL)
+			if err != nil {
+				return "", fmt.Errorf("invalid Harbor URL: %w", err)
+			}
+			ref, err := url.Parse(target[1 : len(target)-1])
+			if err != nil {
+				return "", fmt.Errorf("invalid next page link %q: %w", target, err)
+			}
+			next := base.ResolveReference(ref)
+			if next.Host != base.Host {
+				// The request carries the credentials, so it must not leave Harbor.
+				return "", fmt.Errorf("next page link %q points away from %s", target, base.Host)
+			}
+			return next.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// decodePage unmarshals the items of one page into a slice of T.
+func decodePage[T any](page []json.RawMessage) ([]T, error) {
+	items := make([]T, len(page))
+	for i, raw := range page {
+		if err := json.Unmarshal(raw, &items[i]); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// SystemInfo identifies the Harbor instance a run talks to.
+type SystemInfo struct {
+	HarborVersion string `json:"harbor_version"`
+	RegistryURL   string `json:"registry_url"`
+	ExternalURL   string `json:"external_url"`
+	AuthMode      string `json:"auth_mode"`
+	// Storage is the registry storage usage; empty unless the user is a system admin.
+	Storage []StorageInfo `json:"storage"`
+}
+
+// StorageInfo is the capacity of one registry storage volume, in bytes.
+type StorageInfo struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+// Ping checks that Harbor is reachable.
+func (c *HarborClient) Ping() error {
+	_, err := c.doRequest("GET", "/ping", nil)
+	return err
 }
 
-// ListProjects fetches all projects from Harbor.
+// GetSystemInfo fetches the Harbor version and, if the user may see it, the storage usage.
+// Failing to read the storage usage is not an error.
+func (c *HarborClient) GetSystemInfo() (SystemInfo, error) {
+	var info SystemInfo
+	body, err := c.doRequest("GET", "/systeminfo", nil)
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return info, fmt.Errorf("failed to unmarshal system info: %w", err)
+	}
+	if body, err := c.doRequest("GET", "/systeminfo/volumes", nil); err == nil {
+		var volumes struct {
+			Storage []StorageInfo `json:"storage"`
+		}
+		if json.Unmarshal(body, &volumes) == nil {
+			info.Storage = volumes.Storage
+		}
+	}
+	return info, nil
+}
+
+// ListProjects fetches all projects from Harbor. If a later page fails, the projects of the
+// earlier pages are returned with a *PartialListError.
 func (c *HarborClient) ListProjects() ([]Project, error) {
 	body, err := c.fetchAllPages("/projects", nil)
-	if err != nil {
+	if body == nil {
 		return nil, err
 	}
 	var projects []Project
 	if err := json.Unmarshal(body, &projects); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal all projects: %w", err)
 	}
-	return projects, nil
+	return projects, err
+}
+
+// GetProject fetches a single project by name.
+func (c *HarborClient) GetProject(projectName string) (Project, error) {
+	body, err := c.doRequest("GET", "/projects/"+url.PathEscape(projectName), nil)
+	if err != nil {
+		return Project{}, err
+	}
+	var project Project
+	if err := json.Unmarshal(body, &project); err != nil {
+		return Project{}, fmt.Errorf("failed to unmarshal project %s: %w", projectName, err)
+	}
+	return project, nil
 }
 
-// ListRepositories fetches all repositories for a given project.
+// ListRepositories fetches all repositories for a given project. If a later page fails, the
+// repositories of the earlier pages are returned with a *PartialListError.
 func (c *HarborClient) ListRepositories(projectName string) ([]Repository, error) {
-	path := fmt.Sprintf("/projects/%s/repositories", projectName)
+	path := fmt.Sprintf("/projects/%s/repositories", url.PathEscape(projectName))
 	body, err := c.fetchAllPages(path, nil)
-	if err != nil {
+	if body == nil {
 		return nil, err
 	}
 	var repos []Repository
 	if err := json.Unmarshal(body, &repos); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal all repositories for project %s: %w", projectName, err)
 	}
-	return repos, nil
+	return repos, err
 }
 
-// ListArtifacts fetches all artifacts for a given repository.
-func (c *HarborClient) ListArtifacts(projectName, repoName string) ([]Artifact, error) {
-	// The repoName from ListRepositories includes the project name (e.g., 'library/ubuntu').
-	// We need to trim the project part for the API call path.
-	repoName = strings.TrimPrefix(repoName, projectName+"/")
-	// URL encode the repository name to handle slashes.
-	encodedRepoName := url.PathEscape(repoName)
-	path := fmt.Sprintf("/projects/%s/repositories/%s/artifacts", projectName, encodedRepoName)
+// EachRepositoryPage calls fn with each page of a project's repositories as it is fetched,
+// without holding the whole list, and stops at the first error fn returns. A listing that
+// fails after earlier pages were passed to fn returns a *PartialListError. Use
+// ListRepositories when the whole list is needed at once.
+func (c *HarborClient) EachRepositoryPage(projectName string, fn func([]Repository) error) error {
+	path := fmt.Sprintf("/projects/%s/repositories", url.PathEscape(projectName))
+	return c.eachPage(path, nil, func(page []json.RawMessage) error {
+		repos, err := decodePage[Repository](page)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal repositories for project %s: %w", projectName, err)
+		}
+		return fn(repos)
+	})
+}
+
+// RelativeRepoName returns the repository name without its project prefix. Harbor lists
+// repositories by full name (e.g. "library/nginx", or "team/app/api" for nested paths),
+// but a name that is already relative (e.g. "nginx") is returned unchanged.
+func RelativeRepoName(projectName, repoName string) string {
+	return strings.TrimPrefix(repoName, projectName+"/")
+}
+
+// repoPath returns the API path of a repository. Harbor requires slashes in the repository
+// name to be URL-encoded twice ("app/api" becomes "app%252Fapi"), otherwise nested
+// repositories resolve to the wrong path.
+func repoPath(projectName, repoName string) string {
+	rel := RelativeRepoName(projectName, repoName)
+	return fmt.Sprintf("/projects/%s/repositories/%s", url.PathEscape(projectName), url.PathEscape(url.PathEscape(rel)))
+}
 
+// artifactListParams are the query parameters of every artifact list request.
+func artifactListParams() url.Values {
 	params := url.Values{}
 	params.Set("with_tag", "true")
 	params.Set("with_scan_overview", "false")
-	params.Set("with_label", "false")
+	params.Set("with_label", "true")
+	return params
+}
 
-	body, err := c.fetchAllPages(path, params)
+// ListArtifacts fetches all artifacts for a given repository.
+func (c *HarborClient) ListArtifacts(projectName, repoName string) ([]Artifact, error) {
+	path := repoPath(projectName, repoName) + "/artifacts"
+	body, err := c.fetchAllPages(path, artifactListParams())
 	if err != nil {
 		return nil, err
 	}
@@ -191,12 +596,247 @@ func (c *HarborClient) ListArtifacts(projectName, repoName string) ([]Artifact,
 	return artifacts, nil
 }
 
+// EachArtifactPage calls fn with each page of a repository's artifacts as it is fetched,
+// without holding the whole list, and stops at the first error fn returns. A listing that
+// fails after earlier pages were passed to fn returns a *PartialListError.
+func (c *HarborClient) EachArtifactPage(projectName, repoName string, fn func([]Artifact) error) error {
+	path := repoPath(projectName, repoName) + "/artifacts"
+	return c.eachPage(path, artifactListParams(), func(page []json.RawMessage) error {
+		artifacts, err := decodePage[Artifact](page)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal artifacts for repo %s/%s: %w", projectName, repoName, err)
+		}
+		return fn(artifacts)
+	})
+}
+
+// GetArtifact fetches a single artifact, with its tags, by reference (a digest or tag).
+func (c *HarborClient) GetArtifact(projectName, repoName, reference string) (Artifact, error) {
+	params := url.Values{}
+	params.Set("with_tag", "true")
+	params.Set("with_label", "true")
+
+	body, err := c.doRequest("GET", repoPath(projectName, repoName)+"/artifacts/"+url.PathEscape(reference), params)
+	if err != nil {
+		return Artifact{}, err
+	}
+	var art Artifact
+	if err := json.Unmarshal(body, &art); err != nil {
+		return Artifact{}, fmt.Errorf("failed to unmarshal artifact %s in repo %s/%s: %w", reference, projectName, repoName, err)
+	}
+	return art, nil
+}
+
+// ListTags fetches all tags of the artifact identified by reference (a digest or tag).
+func (c *HarborClient) ListTags(projectName, repoName, reference string) ([]Tag, error) {
+	path := repoPath(projectName, repoName) + "/artifacts/" + url.PathEscape(reference) + "/tags"
+
+	body, err := c.fetchAllPages(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tags for artifact %s in repo %s/%s: %w", reference, projectName, repoName, err)
+	}
+	return tags, nil
+}
+
 // DeleteArtifact deletes a specific artifact identified by its digest.
 func (c *HarborClient) DeleteArtifact(projectName, repoName, digest string) error {
-	repoName = strings.TrimPrefix(repoName, projectName+"/")
-	encodedRepoName := url.PathEscape(repoName)
-	path := fmt.Sprintf("/projects/%s/repositories/%s/artifacts/%s", projectName, encodedRepoName, digest)
+	return c.DeleteByReference(projectName, repoName, digest)
+}
+
+// DeleteByReference deletes the artifact identified by reference, which may be a tag or a digest.
+// Note that Harbor deletes the whole artifact a tag points to, including all its other tags.
+func (c *HarborClient) DeleteByReference(projectName, repoName, reference string) error {
+	if reference == "" {
+		return fmt.Errorf("an artifact reference (tag or digest) must be provided")
+	}
+	path := repoPath(projectName, repoName) + "/artifacts/" + url.PathEscape(reference)
 
 	_, err := c.doRequest("DELETE", path, nil)
 	return err
-}
\ No newline at end of file
+}
+
+// DeleteTag removes one tag from the artifact identified by reference, leaving the artifact
+// and its other tags in place.
+func (c *HarborClient) DeleteTag(projectName, repoName, reference, tagName string) error {
+	path := repoPath(projectName, repoName) + "/artifacts/" + url.PathEscape(reference) + "/tags/" + url.PathEscape(tagName)
+	_, err := c.doRequest("DELETE", path, nil)
+	return err
+}
+
+// manifestMediaTypes are the manifest types the registry requests accept, so the registry
+// does not reject a request for an index or OCI manifest.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// CheckRegistry verifies that the registry API answers under BaseURL: GET /v2/ must return
+// 200, or 401 from a registry that wants a token. Anything else, such as a 404 from a proxy
+// that only routes the Harbor API, means its answers about manifests can't be trusted.
+func (c *HarborClient) CheckRegistry() error {
+	resp, fullURL, err := c.registryDo(http.MethodGet, c.BaseURL+"/v2/")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("registry API check %s answered with status %d, expected 200 or 401", redact.URL(fullURL), resp.StatusCode)
+	}
+	return nil
+}
+
+// ManifestExists reports whether the registry behind Harbor can serve the manifest of
+// reference (a digest or tag) in repoName, e.g. "library/nginx". Unlike the other calls it
+// uses the registry API, so it sees the stored blobs rather than Harbor's database. Only a
+// 404 with the registry error code MANIFEST_UNKNOWN counts as missing; any other 404, e.g.
+// from a proxy that doesn't route the registry API, is an error.
+func (c *HarborClient) ManifestExists(repoName, reference string) (bool, error) {
+	resp, fullURL, err := c.registryRequest(http.MethodGet, repoName, reference)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		var body struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body)
+		for _, e := range body.Errors {
+			if e.Code == "MANIFEST_UNKNOWN" {
+				return false, nil
+			}
+		}
+		return false, fmt.Errorf("registry request to %s failed with status 404 but no MANIFEST_UNKNOWN error", redact.URL(fullURL))
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	default:
+		return false, fmt.Errorf("registry request to %s failed with status %d", redact.URL(fullURL), resp.StatusCode)
+	}
+}
+
+// ManifestLayers returns the layer digests of the manifest of reference in repoName, base
+// layer first, from the registry API like ManifestExists. An image index has no layers of
+// its own; the layers of its children must be fetched by their digests.
+func (c *HarborClient) ManifestLayers(repoName, reference string) ([]string, error) {
+	resp, fullURL, err := c.registryRequest(http.MethodGet, repoName, reference)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("registry request to %s failed with status %d", redact.URL(fullURL), resp.StatusCode)
+	}
+
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s of repo %s: %w", reference, repoName, err)
+	}
+	layers := make([]string, 0, len(manifest.Layers))
+	for _, l := range manifest.Layers {
+		layers = append(layers, l.Digest)
+	}
+	return layers, nil
+}
+
+// registryRequest sends a request for the manifest of reference in repoName to the registry
+// API, returning the response and the URL for error messages.
+func (c *HarborClient) registryRequest(method, repoName, reference string) (*http.Response, string, error) {
+	return c.registryDo(method, fmt.Sprintf("%s/v2/%s/manifests/%s", c.BaseURL, repoName, reference))
+}
+
+// registryDo sends a request to fullURL on the registry API, returning the response and the
+// URL for error messages.
+func (c *HarborClient) registryDo(method, fullURL string) (*http.Response, string, error) {
+	req, err := http.NewRequest(method, fullURL, nil)
+	if err != nil {
+		return nil, fullURL, redact.Error(fmt.Errorf("failed to create request: %w", err), c.Password)
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if c.Limiter != nil {
+		c.Limiter.Wait()
+	}
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, fullURL, redact.Error(fmt.Errorf("failed to execute request to %s: %w", fullURL, err), c.Password)
+	}
+	return resp, fullURL, nil
+}
+
+// ListProjectLabels fetches all labels scoped to the given project.
+func (c *HarborClient) ListProjectLabels(projectID int) ([]Label, error) {
+	params := url.Values{}
+	params.Set("scope", "p")
+	params.Set("project_id", strconv.Itoa(projectID))
+
+	body, err := c.fetchAllPages("/labels", params)
+	if err != nil {
+		return nil, err
+	}
+	var labels []Label
+	if err := json.Unmarshal(body, &labels); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal labels for project %d: %w", projectID, err)
+	}
+	return labels, nil
+}
+
+// CreateProjectLabel creates a project-scoped label and returns it as stored by Harbor.
+func (c *HarborClient) CreateProjectLabel(projectID int, name, description string) (Label, error) {
+	payload := Label{
+		Name:        name,
+		Description: description,
+		Scope:       "p",
+		ProjectID:   projectID,
+	}
+	if _, err := c.doRequestWithBody("POST", "/labels", nil, payload); err != nil {
+		return Label{}, err
+	}
+
+	// Harbor only returns the new label's location, so look it up to learn its ID.
+	labels, err := c.ListProjectLabels(projectID)
+	if err != nil {
+		return Label{}, err
+	}
+	for _, l := range labels {
+		if l.Name == name {
+			return l, nil
+		}
+	}
+	return Label{}, fmt.Errorf("label %s was created but not found in project %d", name, projectID)
+}
+
+// DeleteLabel deletes a label, detaching it from every artifact that carries it.
+func (c *HarborClient) DeleteLabel(labelID int64) error {
+	_, err := c.doRequest("DELETE", fmt.Sprintf("/labels/%d", labelID), nil)
+	return err
+}
+
+// AddArtifactLabel attaches an existing label to an artifact.
+func (c *HarborClient) AddArtifactLabel(projectName, repoName, digest string, labelID int64) error {
+	path := repoPath(projectName, repoName) + "/artifacts/" + url.PathEscape(digest) + "/labels"
+
+	_, err := c.doRequestWithBody("POST", path, nil, map[string]int64{"id": labelID})
+	return err
+}
+
+// RemoveArtifactLabel detaches a label from an artifact.
+func (c *HarborClient) RemoveArtifactLabel(projectName, repoName, digest string, labelID int64) error {
+	path := fmt.Sprintf("%s/artifacts/%s/labels/%d", repoPath(projectName, repoName), url.PathEscape(digest), labelID)
+
+	_, err := c.doRequest("DELETE", path, nil)
+	return err
+}
@@ -0,0 +1,113 @@
+// File: immutable_rules.go
+// Description: This file contains the Harbor immutable tag rule model and the matching logic
+// used to predict which artifacts Harbor will refuse to delete.
+
+package harbor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ImmutableRule is a project-level rule that makes matching tags immutable.
+type ImmutableRule struct {
+	ID             int64                     `json:"id"`
+	Disabled       bool                      `json:"disabled"`
+	TagSelectors   []RuleSelector            `json:"tag_selectors"`
+	ScopeSelectors map[string][]RuleSelector `json:"scope_selectors"`
+}
+
+// RuleSelector selects tags or repositories by a doublestar pattern. Decoration is one of
+// "matches"/"excludes" for tags and "repoMatches"/"repoExcludes" for repositories.
+type RuleSelector struct {
+	Kind       string `json:"kind"`
+	Decoration string `json:"decoration"`
+	Pattern    string `json:"pattern"`
+}
+
+// ListImmutableRules fetches the immutable tag rules of a project.
+func (c *HarborClient) ListImmutableRules(projectName string) ([]ImmutableRule, error) {
+	path := fmt.Sprintf("/projects/%s/immutabletagrules", url.PathEscape(projectName))
+	body, err := c.fetchAllPages(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ImmutableRule
+	if err := json.Unmarshal(body, &rules); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal immutable tag rules for project %s: %w", projectName, err)
+	}
+	return rules, nil
+}
+
+// Matches reports whether the rule makes tag in the repository immutable. repoName may
+// include the project prefix, which Harbor does not consider when matching.
+func (r ImmutableRule) Matches(projectName, repoName, tag string) bool {
+	if r.Disabled {
+		return false
+	}
+	repoName = RelativeRepoName(projectName, repoName)
+	for _, s := range r.ScopeSelectors["repository"] {
+		if !selectorMatches(s, repoName) {
+			return false
+		}
+	}
+	for _, s := range r.TagSelectors {
+		if !selectorMatches(s, tag) {
+			return false
+		}
+	}
+	return len(r.TagSelectors) > 0
+}
+
+// selectorMatches applies a selector's pattern, inverting the result for exclude decorations.
+func selectorMatches(s RuleSelector, value string) bool {
+	matched := doublestarMatch(s.Pattern, value)
+	switch s.Decoration {
+	case "excludes", "repoExcludes":
+		return !matched
+	default:
+		return matched
+	}
+}
+
+// doublestarMatch matches value against a doublestar pattern as used by Harbor rules:
+// "**" matches anything, "*" and "?" do not cross "/", and "{a,b}" matches alternatives.
+func doublestarMatch(pattern, value string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; {
+		case ch == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case ch == '*':
+			b.WriteString("[^/]*")
+		case ch == '?':
+			b.WriteString("[^/]")
+		case ch == '{':
+			b.WriteString("(?:")
+			depth++
+		case ch == '}' && depth > 0:
+			b.WriteString(")")
+			depth--
+		case ch == ',' && depth > 0:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	for ; depth > 0; depth-- {
+		b.WriteString(")")
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
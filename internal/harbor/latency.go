@@ -0,0 +1,74 @@
+// File: latency.go
+// Description: This file contains the latency guard that pauses deletions when Harbor's
+// response times climb, before it starts rejecting requests.
+
+package harbor
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// LatencyGuard tracks the average response time of the last Window requests and compares it
+// with the lowest such average of the run, the baseline. When the average exceeds Factor
+// times the baseline, deletions are held back for Pause so a struggling Harbor can recover;
+// other requests continue. The samples are then discarded, so the next decision is based on
+// a full window of fresh responses. It is shared by all goroutines using the client.
+type LatencyGuard struct {
+	mu         sync.Mutex
+	factor     float64
+	pause      time.Duration
+	samples    []time.Duration // Ring buffer of the last len(samples) latencies
+	next       int             // Index of the next sample to overwrite
+	count      int             // Samples recorded since the last reset, up to len(samples)
+	baseline   time.Duration   // Lowest full-window average so far; 0 until the first
+	pauseUntil time.Time
+}
+
+// NewLatencyGuard returns a guard averaging window requests that pauses deletions for pause
+// when the average exceeds factor times the baseline.
+func NewLatencyGuard(window int, factor float64, pause time.Duration) *LatencyGuard {
+	if window < 1 {
+		window = 1
+	}
+	return &LatencyGuard{factor: factor, pause: pause, samples: make([]time.Duration, window)}
+}
+
+// Observe records the response time of a request.
+func (g *LatencyGuard) Observe(latency time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.samples[g.next] = latency
+	g.next = (g.next + 1) % len(g.samples)
+	if g.count < len(g.samples) {
+		if g.count++; g.count < len(g.samples) {
+			return
+		}
+	}
+
+	var total time.Duration
+	for _, s := range g.samples {
+		total += s
+	}
+	average := total / time.Duration(len(g.samples))
+	if g.baseline == 0 || average < g.baseline {
+		g.baseline = average
+		return
+	}
+	if float64(average) <= g.factor*float64(g.baseline) || time.Now().Before(g.pauseUntil) {
+		return
+	}
+	g.pauseUntil = time.Now().Add(g.pause)
+	g.count, g.next = 0, 0
+	log.Printf("🐌 Harbor latency rose to %s (%.1fx the baseline of %s), pausing deletions for %s.",
+		average.Round(time.Millisecond), float64(average)/float64(g.baseline), g.baseline.Round(time.Millisecond), g.pause)
+}
+
+// Wait blocks while deletions are paused.
+func (g *LatencyGuard) Wait() {
+	g.mu.Lock()
+	until := g.pauseUntil
+	g.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
@@ -0,0 +1,90 @@
+// File: limiter.go
+// Description: This file contains the adaptive request rate limiter that slows the client
+// down when Harbor signals overload and speeds it back up while requests succeed.
+
+package harbor
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimiter paces requests with an additive-increase/multiplicative-decrease rate:
+// every throttled response (429 or 503) halves the rate, and every rate-many successful
+// requests in a row raise it by one request per second, up to max. It is shared by all
+// goroutines using the client.
+type AdaptiveLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // Current requests per second
+	min, max  float64
+	next      time.Time // Earliest start of the next request
+	successes int       // Successful requests since the last rate change
+	logged    float64   // Rate at the last log message
+	lastCut   time.Time // Time of the last decrease
+}
+
+// NewAdaptiveLimiter returns a limiter starting at max requests per second.
+func NewAdaptiveLimiter(min, max float64) *AdaptiveLimiter {
+	if min <= 0 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AdaptiveLimiter{rate: max, min: min, max: max, logged: max}
+}
+
+// Wait blocks until the next request may start.
+func (l *AdaptiveLimiter) Wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(time.Duration(float64(time.Second) / l.rate))
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// Throttled halves the rate after Harbor rejected a request as overloaded. Rejections of
+// requests already in flight within a second of a decrease don't lower it again.
+func (l *AdaptiveLimiter) Throttled(status int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.successes = 0
+	if l.rate <= l.min || time.Since(l.lastCut) < time.Second {
+		return
+	}
+	l.rate = max(l.rate/2, l.min)
+	l.lastCut = time.Now()
+	l.logged = l.rate
+	log.Printf("🐢 Harbor responded %d, slowing down to %.1f requests/s.", status, l.rate)
+}
+
+// Succeeded counts a successful request and raises the rate after a full window of them.
+func (l *AdaptiveLimiter) Succeeded() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate >= l.max {
+		return
+	}
+	if l.successes++; float64(l.successes) < l.rate {
+		return
+	}
+	l.successes = 0
+	l.rate = min(l.rate+1, l.max)
+	// Log recoveries in steps of a quarter, or when the limit is reached, to keep the log short.
+	if l.rate >= l.max || l.rate >= l.logged*1.25 {
+		l.logged = l.rate
+		log.Printf("🐇 Harbor is keeping up, speeding up to %.1f requests/s.", l.rate)
+	}
+}
+
+// Rate returns the current requests per second.
+func (l *AdaptiveLimiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
@@ -0,0 +1,118 @@
+// File: retention_policy.go
+// Description: This file contains the Harbor tag retention policy model and the API calls
+// used to manage a project's native retention policy.
+
+package harbor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// RetentionPolicy is a project's native Harbor tag retention policy.
+type RetentionPolicy struct {
+	ID        int64             `json:"id,omitempty"`
+	Algorithm string            `json:"algorithm"`
+	Rules     []RetentionRule   `json:"rules"`
+	Trigger   *RetentionTrigger `json:"trigger,omitempty"`
+	Scope     *RetentionScope   `json:"scope,omitempty"`
+}
+
+// RetentionRule retains the tags selected by its selectors according to Template and Params,
+// e.g. template "latestPushedK" with params {"latestPushedK": 10}.
+type RetentionRule struct {
+	ID             int64                     `json:"id,omitempty"`
+	Priority       int                       `json:"priority,omitempty"`
+	Disabled       bool                      `json:"disabled"`
+	Action         string                    `json:"action"`
+	Template       string                    `json:"template"`
+	Params         map[string]interface{}    `json:"params"`
+	TagSelectors   []RuleSelector            `json:"tag_selectors"`
+	ScopeSelectors map[string][]RuleSelector `json:"scope_selectors"`
+}
+
+// AlwaysRetains reports whether the rule is an enabled "always retain" rule selecting tag in
+// the repository. repoName may include the project prefix, which Harbor does not consider.
+func (r RetentionRule) AlwaysRetains(projectName, repoName, tag string) bool {
+	if r.Disabled || r.Template != "always" {
+		return false
+	}
+	repoName = RelativeRepoName(projectName, repoName)
+	for _, s := range r.ScopeSelectors["repository"] {
+		if !selectorMatches(s, repoName) {
+			return false
+		}
+	}
+	for _, s := range r.TagSelectors {
+		if !selectorMatches(s, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// RetentionTrigger controls when Harbor runs the policy.
+type RetentionTrigger struct {
+	Kind     string                 `json:"kind"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// RetentionScope binds a policy to a project.
+type RetentionScope struct {
+	Level string `json:"level"`
+	Ref   int    `json:"ref"`
+}
+
+// RetentionID returns the ID of the project's retention policy, if it has one.
+func (p Project) RetentionID() (int64, bool) {
+	id, err := strconv.ParseInt(p.Metadata["retention_id"], 10, 64)
+	return id, err == nil
+}
+
+// GetRetentionPolicy fetches a retention policy by ID.
+func (c *HarborClient) GetRetentionPolicy(id int64) (RetentionPolicy, error) {
+	body, err := c.doRequest("GET", fmt.Sprintf("/retentions/%d", id), nil)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	var policy RetentionPolicy
+	if err := json.Unmarshal(body, &policy); err != nil {
+		return RetentionPolicy{}, fmt.Errorf("failed to unmarshal retention policy %d: %w", id, err)
+	}
+	return policy, nil
+}
+
+// CreateRetentionPolicy creates a retention policy for the project in policy.Scope.
+func (c *HarborClient) CreateRetentionPolicy(policy RetentionPolicy) error {
+	_, err := c.doRequestWithBody("POST", "/retentions", nil, policy)
+	return err
+}
+
+// UpdateRetentionPolicy replaces the rules and trigger of an existing retention policy.
+func (c *HarborClient) UpdateRetentionPolicy(id int64, policy RetentionPolicy) error {
+	policy.ID = id
+	_, err := c.doRequestWithBody("PUT", fmt.Sprintf("/retentions/%d", id), nil, policy)
+	return err
+}
+
+// SameAs reports whether two policies have the same algorithm, rules and trigger,
+// ignoring the IDs and priorities Harbor assigns.
+func (p RetentionPolicy) SameAs(other RetentionPolicy) bool {
+	return bytes.Equal(p.fingerprint(), other.fingerprint())
+}
+
+func (p RetentionPolicy) fingerprint() []byte {
+	rules := make([]RetentionRule, len(p.Rules))
+	for i, r := range p.Rules {
+		r.ID, r.Priority = 0, 0
+		rules[i] = r
+	}
+	data, _ := json.Marshal(struct {
+		Algorithm string
+		Rules     []RetentionRule
+		Trigger   *RetentionTrigger
+	}{p.Algorithm, rules, p.Trigger})
+	return data
+}
@@ -0,0 +1,54 @@
+package harbor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRetentionPolicySameAs(t *testing.T) {
+	desired := RetentionPolicy{
+		Algorithm: "or",
+		Rules: []RetentionRule{{
+			Action:         "retain",
+			Template:       "latestPushedK",
+			Params:         map[string]interface{}{"latestPushedK": 10},
+			TagSelectors:   []RuleSelector{{Kind: "doublestar", Decoration: "excludes", Pattern: "**SNAPSHOT**"}},
+			ScopeSelectors: map[string][]RuleSelector{"repository": {{Kind: "doublestar", Decoration: "repoMatches", Pattern: "**"}}},
+		}},
+		Trigger: &RetentionTrigger{Kind: "Schedule", Settings: map[string]interface{}{"cron": "0 0 2 * * *"}},
+		Scope:   &RetentionScope{Level: "project", Ref: 7},
+	}
+
+	// The policy as Harbor returns it: with IDs and priorities, and numbers decoded as float64.
+	data, _ := json.Marshal(desired)
+	var stored RetentionPolicy
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	stored.ID = 3
+	stored.Rules[0].ID, stored.Rules[0].Priority = 12, 1
+	if !stored.SameAs(desired) {
+		t.Errorf("stored policy differs from the desired one it was created from")
+	}
+
+	changes := map[string]func(p *RetentionPolicy){
+		"count":    func(p *RetentionPolicy) { p.Rules[0].Params = map[string]interface{}{"latestPushedK": 5} },
+		"disabled": func(p *RetentionPolicy) { p.Rules[0].Disabled = true },
+		"tags": func(p *RetentionPolicy) {
+			p.Rules[0].TagSelectors = []RuleSelector{{Kind: "doublestar", Decoration: "matches", Pattern: "**"}}
+		},
+		"schedule": func(p *RetentionPolicy) {
+			p.Trigger = &RetentionTrigger{Kind: "Schedule", Settings: map[string]interface{}{"cron": ""}}
+		},
+		"algorithm": func(p *RetentionPolicy) { p.Algorithm = "and" },
+		"rules":     func(p *RetentionPolicy) { p.Rules = append(p.Rules, p.Rules[0]) },
+	}
+	for name, change := range changes {
+		changed := stored
+		changed.Rules = append([]RetentionRule(nil), stored.Rules...)
+		change(&changed)
+		if changed.SameAs(desired) {
+			t.Errorf("policy with a different %s is the same as the desired one", name)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// File: config_images.go
+package k8s
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"harbor-cleaner/internal/config"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// helmReleaseSelector selects the release secrets of Helm 3 releases that are deployed.
+const helmReleaseSelector = "owner=helm,status=deployed"
+
+// configSource reads image references from the text of ConfigMaps and, with helm set, from
+// the values and rendered manifests of deployed Helm releases.
+type configSource struct {
+	clientset kubernetes.Interface
+	pattern   *regexp.Regexp
+	helm      bool
+}
+
+func newConfigSource(clientset kubernetes.Interface, cfg *config.ConfigImagesConfig) *configSource {
+	quoted := make([]string, len(cfg.Registries))
+	for i, host := range cfg.Registries {
+		quoted[i] = regexp.QuoteMeta(host)
+	}
+	// A registry host at a word boundary, a repository path and a tag and/or digest.
+	pattern := regexp.MustCompile(`(?:^|[^A-Za-z0-9.-])((?:` + strings.Join(quoted, "|") +
+		`)(?:/[a-z0-9][a-z0-9._-]*)+(?::[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(?:@sha256:[0-9a-f]{64})?)`)
+	return &configSource{clientset: clientset, pattern: pattern, helm: cfg.HelmReleases}
+}
+
+func (s *configSource) describe() string {
+	if s.helm {
+		return "configmaps and helm releases"
+	}
+	return "configmaps"
+}
+
+func (s *configSource) images(ctx context.Context, namespace string) ([]SafeImageInfo, error) {
+	configMaps, err := s.clientset.CoreV1().ConfigMaps(namespace).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	for _, cm := range configMaps.Items {
+		for _, value := range cm.Data {
+			s.scan(value, found)
+		}
+	}
+
+	if s.helm {
+		secrets, err := s.clientset.CoreV1().Secrets(namespace).List(ctx, v1.ListOptions{LabelSelector: helmReleaseSelector})
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range secrets.Items {
+			if secret.Type != "helm.sh/release.v1" {
+				continue
+			}
+			if err := s.scanHelmRelease(secret.Data["release"], found); err != nil {
+				return nil, fmt.Errorf("failed to decode helm release %s: %w", secret.Name, err)
+			}
+		}
+	}
+
+	var infos []SafeImageInfo
+	for image := range found {
+		infos = append(infos, SafeImageInfo{Image: image, Namespace: namespace})
+	}
+	return infos, nil
+}
+
+// scan adds the image references with a tag or digest in text to found.
+func (s *configSource) scan(text string, found map[string]bool) {
+	for _, match := range s.pattern.FindAllStringSubmatch(text, -1) {
+		image := strings.TrimRight(match[1], ".-")
+		if strings.Contains(image[strings.LastIndex(image, "/"):], ":") || strings.Contains(image, "@") {
+			found[image] = true
+		}
+	}
+}
+
+// helmRelease holds the parts of a Helm 3 release that may reference images.
+type helmRelease struct {
+	Config   map[string]interface{} `json:"config"` // User-supplied values
+	Manifest string                 `json:"manifest"`
+	Chart    struct {
+		Values map[string]interface{} `json:"values"` // Chart defaults
+	} `json:"chart"`
+}
+
+// scanHelmRelease decodes a Helm 3 release (base64, usually gzipped JSON) and adds the
+// image references in its rendered manifest and values to found. Besides complete
+// references, values that split an image into "registry", "repository" and "tag" keys, as
+// many charts do, are joined.
+func (s *configSource) scanHelmRelease(data []byte, found map[string]bool) error {
+	raw, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return err
+		}
+		if raw, err = io.ReadAll(zr); err != nil {
+			return err
+		}
+	}
+	var release helmRelease
+	if err := json.Unmarshal(raw, &release); err != nil {
+		return err
+	}
+	s.scan(release.Manifest, found)
+	for _, values := range []map[string]interface{}{release.Config, release.Chart.Values} {
+		s.scanValues(values, found)
+	}
+	return nil
+}
+
+// scanValues walks Helm values, scanning every string and joining split image settings.
+func (s *configSource) scanValues(v interface{}, found map[string]bool) {
+	switch v := v.(type) {
+	case string:
+		s.scan(v, found)
+	case []interface{}:
+		for _, item := range v {
+			s.scanValues(item, found)
+		}
+	case map[string]interface{}:
+		if image := splitImageValues(v); image != "" {
+			s.scan(image, found)
+		}
+		for _, item := range v {
+			s.scanValues(item, found)
+		}
+	}
+}
+
+// splitImageValues joins the "registry", "repository" and "tag" (or "digest") keys of an
+// image setting such as {repository: harbor.local/app/api, tag: 1.2.0}, or returns "".
+func splitImageValues(values map[string]interface{}) string {
+	repository, _ := values["repository"].(string)
+	if repository == "" {
+		return ""
+	}
+	if registry, ok := values["registry"].(string); ok && registry != "" {
+		repository = registry + "/" + repository
+	}
+	if digest, ok := values["digest"].(string); ok && digest != "" {
+		return repository + "@" + digest
+	}
+	switch tag := values["tag"].(type) {
+	case string:
+		if tag != "" {
+			return repository + ":" + tag
+		}
+	case float64:
+		return repository + ":" + fmt.Sprint(tag)
+	}
+	return ""
+}
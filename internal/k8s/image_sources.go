@@ -0,0 +1,131 @@
+// File: image_sources.go
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"harbor-cleaner/internal/config"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// imageSource contributes image references from something other than Deployments and
+// StatefulSets to the safe list of a namespace.
+type imageSource interface {
+	// describe names the source in log messages.
+	describe() string
+	// images returns the images referenced in the namespace, with the namespace of the
+	// referencing resource ("" for cluster-scoped ones). The caller sets Env.
+	images(ctx context.Context, namespace string) ([]SafeImageInfo, error)
+}
+
+// resourceSource reads image references from fields of arbitrary resources, such as a
+// custom resource pointing at a WASM module, selected by GVR and JSONPath expressions.
+type resourceSource struct {
+	client        dynamic.Interface
+	gvr           schema.GroupVersionResource
+	paths         []*jsonpath.JSONPath
+	rawPaths      []string
+	clusterScoped bool // Cluster-scoped resource, listed once regardless of the namespace
+	listed        bool
+}
+
+// newImageSources builds the configured extra image sources of an environment.
+func newImageSources(k8sConfig *rest.Config, clientset kubernetes.Interface, env *config.K8sEnvConfig) ([]imageSource, error) {
+	var sources []imageSource
+	if env.ConfigImages.Enabled {
+		sources = append(sources, newConfigSource(clientset, &env.ConfigImages))
+	}
+	if len(env.ImageSources) == 0 {
+		return sources, nil
+	}
+	client, err := dynamic.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range env.ImageSources {
+		src := &resourceSource{
+			client:        client,
+			gvr:           schema.GroupVersionResource{Group: s.Group, Version: s.Version, Resource: s.Resource},
+			rawPaths:      s.AllPaths(),
+			clusterScoped: s.ClusterScoped,
+		}
+		for _, raw := range src.rawPaths {
+			path := jsonpath.New(s.Resource).AllowMissingKeys(true)
+			if err := path.Parse(raw); err != nil {
+				return nil, fmt.Errorf("invalid path %q for image source %s: %w", raw, s.Resource, err)
+			}
+			src.paths = append(src.paths, path)
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+func (s *resourceSource) describe() string {
+	return fmt.Sprintf("%s %s", s.gvr.String(), strings.Join(s.rawPaths, " "))
+}
+
+func (s *resourceSource) images(ctx context.Context, namespace string) ([]SafeImageInfo, error) {
+	var resources dynamic.ResourceInterface = s.client.Resource(s.gvr).Namespace(namespace)
+	if s.clusterScoped {
+		if s.listed {
+			return nil, nil
+		}
+		s.listed = true
+		resources = s.client.Resource(s.gvr)
+	}
+	list, err := resources.List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SafeImageInfo
+	for _, item := range list.Items {
+		var images []string
+		for i, path := range s.paths {
+			results, err := path.FindResults(item.Object)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate %s on %s: %w", s.rawPaths[i], item.GetName(), err)
+			}
+			for _, values := range results {
+				for _, v := range values {
+					images = appendStrings(images, v)
+				}
+			}
+		}
+		for _, image := range images {
+			infos = append(infos, SafeImageInfo{Image: image, Namespace: item.GetNamespace()})
+		}
+	}
+	return infos, nil
+}
+
+// appendStrings appends v to images if it is a non-empty string, or its string elements if
+// it is a list, so paths may select a single field or a list of images.
+func appendStrings(images []string, v reflect.Value) []string {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return images
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" {
+			images = append(images, v.String())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			images = appendStrings(images, v.Index(i))
+		}
+	}
+	return images
+}
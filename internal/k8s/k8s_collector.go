@@ -3,15 +3,23 @@ package k8s
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math"
+	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"harbor-cleaner/internal/config"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -22,34 +30,48 @@ type SafeImageInfo struct {
 	Namespace string
 }
 
-// getSafeImagesForWorkload now returns a slice of SafeImageInfo.
-func getSafeImagesForWorkload(clientset kubernetes.Interface, envName, namespace string, deployment *appsv1.Deployment, keepN int) []SafeImageInfo {
+// getSafeImagesForWorkload now returns a slice of SafeImageInfo: the env.Keep newest unique
+// images of the deployment's history or, with env.RolloutHistory, every image of the
+// revisions it can roll back to.
+// Failing to list the deployment's ReplicaSets is returned as an error.
+func getSafeImagesForWorkload(ctx context.Context, clientset kubernetes.Interface, env *config.K8sEnvConfig, namespace string, deployment *appsv1.Deployment) ([]SafeImageInfo, error) {
+	envName, keepN := env.Name, env.Keep
 	selector, err := v1.LabelSelectorAsSelector(deployment.Spec.Selector)
 	if err != nil {
 		log.Printf("      WARNING: Could not create selector for deployment %s/%s: %v", namespace, deployment.Name, err)
-		return nil
+		return nil, nil
 	}
-	rsList, err := clientset.AppsV1().ReplicaSets(namespace).List(context.TODO(), v1.ListOptions{LabelSelector: selector.String()})
+	rsList, err := clientset.AppsV1().ReplicaSets(namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
 	if err != nil {
-		log.Printf("      WARNING: Could not list replicasets for deployment %s/%s: %v", namespace, deployment.Name, err)
-		return nil
+		return nil, fmt.Errorf("could not list replicasets for deployment %s/%s: %w", namespace, deployment.Name, err)
+	}
+	if env.RolloutHistory {
+		return rollbackImages(deployment, rsList.Items, envName, namespace), nil
 	}
 
+	// Order by the deployment revision annotation rather than creation time: after a rollback
+	// the active ReplicaSet is an old one that gets the highest revision number again.
 	type revisionInfo struct {
-		Image string
-		Time  time.Time
+		Image    string
+		Revision int64
+		Time     time.Time
 	}
 	var historicalRevisions []revisionInfo
 	for _, c := range deployment.Spec.Template.Spec.Containers {
-		historicalRevisions = append(historicalRevisions, revisionInfo{Image: c.Image, Time: deployment.CreationTimestamp.Time})
+		// The deployment template is the desired state, so it always ranks first.
+		historicalRevisions = append(historicalRevisions, revisionInfo{Image: c.Image, Revision: math.MaxInt64, Time: deployment.CreationTimestamp.Time})
 	}
 	for _, rs := range rsList.Items {
+		revision := replicaSetRevision(&rs)
 		for _, c := range rs.Spec.Template.Spec.Containers {
-			historicalRevisions = append(historicalRevisions, revisionInfo{Image: c.Image, Time: rs.CreationTimestamp.Time})
+			historicalRevisions = append(historicalRevisions, revisionInfo{Image: c.Image, Revision: revision, Time: rs.CreationTimestamp.Time})
 		}
 	}
 
-	sort.Slice(historicalRevisions, func(i, j int) bool {
+	sort.SliceStable(historicalRevisions, func(i, j int) bool {
+		if historicalRevisions[i].Revision != historicalRevisions[j].Revision {
+			return historicalRevisions[i].Revision > historicalRevisions[j].Revision
+		}
 		return historicalRevisions[i].Time.After(historicalRevisions[j].Time)
 	})
 
@@ -67,77 +89,328 @@ func getSafeImagesForWorkload(clientset kubernetes.Interface, envName, namespace
 			}
 		}
 	}
+	return safeImages, nil
+}
+
+// rollbackImages returns every image of the deployment's template and of the ReplicaSets it
+// can roll back to, like "kubectl rollout history": the ReplicaSets it controls, newest
+// revision first, up to spec.revisionHistoryLimit (10 if unset). The controller deletes
+// older ones, so their images can't be rolled back to anyway.
+func rollbackImages(deployment *appsv1.Deployment, replicaSets []appsv1.ReplicaSet, envName, namespace string) []SafeImageInfo {
+	historyLimit := 10
+	if deployment.Spec.RevisionHistoryLimit != nil {
+		historyLimit = int(*deployment.Spec.RevisionHistoryLimit)
+	}
+	var owned []*appsv1.ReplicaSet
+	for i := range replicaSets {
+		if v1.IsControlledBy(&replicaSets[i], deployment) {
+			owned = append(owned, &replicaSets[i])
+		}
+	}
+	sort.SliceStable(owned, func(i, j int) bool {
+		return replicaSetRevision(owned[i]) > replicaSetRevision(owned[j])
+	})
+	// The newest ReplicaSet is the current revision when the template hasn't changed since
+	// the last rollout; the history limit counts only the old ones.
+	if len(owned) > 0 && equalImages(owned[0].Spec.Template.Spec.Containers, deployment.Spec.Template.Spec.Containers) {
+		historyLimit++
+	}
+	if len(owned) > historyLimit {
+		owned = owned[:historyLimit]
+	}
+
+	var safeImages []SafeImageInfo
+	seenImages := make(map[string]struct{})
+	add := func(containers []corev1.Container) {
+		for _, c := range containers {
+			if _, seen := seenImages[c.Image]; !seen {
+				seenImages[c.Image] = struct{}{}
+				safeImages = append(safeImages, SafeImageInfo{Image: c.Image, Env: envName, Namespace: namespace})
+			}
+		}
+	}
+	add(deployment.Spec.Template.Spec.Containers)
+	for _, rs := range owned {
+		add(rs.Spec.Template.Spec.Containers)
+	}
 	return safeImages
 }
 
-// BuildK8sImageSafeList now returns a slice of SafeImageInfo.
-func BuildK8sImageSafeList(cfg *config.K8sConfig) ([]SafeImageInfo, error) {
-	var globalSafeList []SafeImageInfo
+// equalImages reports whether two container lists use the same images in the same order.
+func equalImages(a, b []corev1.Container) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Image != b[i].Image {
+			return false
+		}
+	}
+	return true
+}
+
+// revisionAnnotation is set by the deployment controller on each ReplicaSet it manages.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// replicaSetRevision returns the rollout revision of a ReplicaSet, or 0 if it is missing or invalid,
+// in which case the ReplicaSet sorts after all annotated ones by creation time.
+func replicaSetRevision(rs *appsv1.ReplicaSet) int64 {
+	revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// resolveNamespaces returns the namespaces to scan in an environment, either the explicit
+// list or those matching the namespace selector.
+func resolveNamespaces(ctx context.Context, clientset kubernetes.Interface, env *config.K8sEnvConfig) ([]string, error) {
+	if env.NamespaceSelector == "" {
+		return env.Namespaces, nil
+	}
+	if len(env.Namespaces) > 0 {
+		return nil, fmt.Errorf("both 'namespaces' and 'namespace-selector' are set; use only one")
+	}
+
+	nsList, err := clientset.CoreV1().Namespaces().List(ctx, v1.ListOptions{LabelSelector: env.NamespaceSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces matching '%s': %w", env.NamespaceSelector, err)
+	}
+	var namespaces []string
+	for _, ns := range nsList.Items {
+		namespaces = append(namespaces, ns.Name)
+	}
+	sort.Strings(namespaces)
+	log.Printf("  -> Namespace selector '%s' matched %d namespaces: %s", env.NamespaceSelector, len(namespaces), strings.Join(namespaces, ", "))
+	return namespaces, nil
+}
+
+// restConfig builds the client config for an environment. The kubeconfig is loaded with the
+// standard loading rules, so exec credential plugins (EKS, GKE) and token files it references
+// work. Environment variables in the kubeconfig setting are expanded, and a list of files
+// separated like KUBECONFIG is merged the way kubectl does, the first file winning.
+// A token-file replaces the kubeconfig user's credentials; client-go re-reads it
+// periodically, so rotated projected service account tokens are picked up.
+func restConfig(env *config.K8sEnvConfig) (*rest.Config, error) {
+	paths, err := kubeconfigPaths(env.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: paths[0]}
+	if len(paths) > 1 {
+		rules = &clientcmd.ClientConfigLoadingRules{Precedence: paths}
+	}
+	k8sConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %w", strings.Join(paths, string(filepath.ListSeparator)), err)
+	}
+	if env.TokenFile != "" {
+		k8sConfig.BearerToken = ""
+		k8sConfig.BearerTokenFile = env.TokenFile
+		k8sConfig.Username, k8sConfig.Password = "", ""
+		k8sConfig.ExecProvider, k8sConfig.AuthProvider = nil, nil
+	}
+	return k8sConfig, nil
+}
+
+// kubeconfigPaths expands environment variables such as $KUBECONFIG_PROD in a kubeconfig
+// setting and splits it into absolute file paths.
+func kubeconfigPaths(value string) ([]string, error) {
+	var paths []string
+	for _, path := range filepath.SplitList(os.ExpandEnv(value)) {
+		if path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, abs)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("kubeconfig %q names no file", value)
+	}
+	return paths, nil
+}
+
+// EnvScanResult describes what the scan found in one environment.
+type EnvScanResult struct {
+	Name       string
+	Namespaces int      // Namespaces scanned
+	Workloads  int      // Workloads whose images were collected
+	Skipped    int      // Workloads filtered out by the pod whitelist/blacklist
+	Images     int      // Unique images found in the environment
+	Denied     []string // List requests refused as forbidden or unauthorized
+	Errors     []string // Other failures, each of which skipped part of a namespace
+}
+
+// ScanResult is the outcome of a scan: the merged safe list and a breakdown per environment.
+type ScanResult struct {
+	Images       []SafeImageInfo
+	Environments []EnvScanResult
+}
+
+// BuildK8sImageSafeList scans every environment and returns the safe list with a breakdown
+// per environment. It aborts with ctx's error if ctx is cancelled while scanning, and fails
+// if an environment can't be reached, if any list request was forbidden or unauthorized, or
+// if an environment without allow-empty contributed no images. Other list failures are
+// logged, skipped and counted in the environment's Errors.
+func BuildK8sImageSafeList(ctx context.Context, cfg *config.K8sConfig) (ScanResult, error) {
+	var result ScanResult
 	// Use a map to prevent adding duplicate SafeImageInfo entries if an image is used in multiple workloads.
 	globalSafeListMap := make(map[string]SafeImageInfo)
+	// Forbidden list requests leave images out of the safe list, which would make them
+	// deletable, so they fail the scan once every environment has been scanned.
+	var denied, empty []string
 
+	if len(cfg.Environments) == 0 {
+		return result, fmt.Errorf("no environments are configured in k8s.environments")
+	}
 	for _, env := range cfg.Environments {
 		log.Printf(" K8s: Connecting to env '%s'...", env.Name)
-		// ... K8s connection logic ...
-		kubeconfigPath, err := filepath.Abs(env.Kubeconfig)
+		envResult, images, err := scanEnv(ctx, &env)
 		if err != nil {
-			return nil, err
+			return ScanResult{}, err
 		}
-		k8sConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-		if err != nil {
-			return nil, err
+		for _, msg := range envResult.Denied {
+			denied = append(denied, fmt.Sprintf("env '%s': %s", env.Name, msg))
 		}
-		clientset, err := kubernetes.NewForConfig(k8sConfig)
-		if err != nil {
-			return nil, err
+		if envResult.Images == 0 && !env.AllowEmpty {
+			empty = append(empty, env.Name)
 		}
-
-		for _, ns := range env.Namespaces {
-			log.Printf("  -> Scanning namespace: %s", ns)
-			deployments, err := clientset.AppsV1().Deployments(ns).List(context.TODO(), v1.ListOptions{})
-			if err != nil {
-				log.Printf("    WARNING: Failed to list deployments in ns %s: %v", ns, err)
-				continue
+		for _, imgInfo := range images {
+			if _, exists := globalSafeListMap[imgInfo.Image]; !exists {
+				globalSafeListMap[imgInfo.Image] = imgInfo
 			}
+		}
+		result.Environments = append(result.Environments, envResult)
+		log.Printf(" K8s: Finished scanning env '%s'.", env.Name)
+	}
+	if len(denied) > 0 {
+		return result, fmt.Errorf("the scan is incomplete, %d list request(s) were denied by RBAC:\n  %s", len(denied), strings.Join(denied, "\n  "))
+	}
+	if len(empty) > 0 {
+		return result, fmt.Errorf("no images found in environment(s) %s; set allow-empty on an environment that is expected to be empty", strings.Join(empty, ", "))
+	}
 
-			for _, d := range deployments.Items {
-				// Check if pod should be processed based on whitelist/blacklist
-				if !config.ShouldProcessWorkload(d.Name, env.PodWhitelist, env.PodBlacklist) {
-					log.Printf("      Skipping deployment %s (filtered by whitelist/blacklist)", d.Name)
-					continue
-				}
-				safeImages := getSafeImagesForWorkload(clientset, env.Name, ns, &d, env.Keep)
-				for _, imgInfo := range safeImages {
-					if _, exists := globalSafeListMap[imgInfo.Image]; !exists {
-						globalSafeListMap[imgInfo.Image] = imgInfo
-					}
-				}
-			}
-			
-			statefulsets, err := clientset.AppsV1().StatefulSets(ns).List(context.TODO(), v1.ListOptions{})
-			if err != nil {
-				log.Printf("    WARNING: Failed to list statefulsets in ns %s: %v", ns, err)
+	for _, v := range globalSafeListMap {
+		result.Images = append(result.Images, v)
+	}
+	return result, nil
+}
+
+// scanEnv connects to an environment and scans its namespaces, returning its breakdown and
+// the images found. Failing to connect or to resolve the namespaces is returned as an
+// error, as is ctx's error if ctx is cancelled.
+func scanEnv(ctx context.Context, env *config.K8sEnvConfig) (EnvScanResult, []SafeImageInfo, error) {
+	result := EnvScanResult{Name: env.Name}
+	k8sConfig, err := restConfig(env)
+	if err != nil {
+		return result, nil, fmt.Errorf("env '%s': %w", env.Name, err)
+	}
+	clientset, err := kubernetes.NewForConfig(k8sConfig)
+	if err != nil {
+		return result, nil, fmt.Errorf("env '%s': %w", env.Name, err)
+	}
+
+	sources, err := newImageSources(k8sConfig, clientset, env)
+	if err != nil {
+		return result, nil, fmt.Errorf("env '%s': %w", env.Name, err)
+	}
+
+	namespaces, err := resolveNamespaces(ctx, clientset, env)
+	if err != nil {
+		return result, nil, fmt.Errorf("env '%s': %w", env.Name, err)
+	}
+
+	var images []SafeImageInfo
+	unique := make(map[string]bool)
+	for _, ns := range namespaces {
+		if err := ctx.Err(); err != nil {
+			return result, nil, err
+		}
+		log.Printf("  -> Scanning namespace: %s", ns)
+		found, errs := scanNamespace(ctx, clientset, sources, env, ns, &result)
+		for _, err := range errs {
+			if isAccessDenied(err) {
+				log.Printf("    ❌ ACCESS DENIED: %v", err)
+				result.Denied = append(result.Denied, err.Error())
 				continue
 			}
-			for _, s := range statefulsets.Items {
-				// Check if pod should be processed based on whitelist/blacklist
-				if !config.ShouldProcessWorkload(s.Name, env.PodWhitelist, env.PodBlacklist) {
-					log.Printf("      Skipping statefulset %s (filtered by whitelist/blacklist)", s.Name)
-					continue
-				}
-				for _, c := range s.Spec.Template.Spec.Containers {
-					imgInfo := SafeImageInfo{Image: c.Image, Env: env.Name, Namespace: ns}
-					if _, exists := globalSafeListMap[imgInfo.Image]; !exists {
-						globalSafeListMap[imgInfo.Image] = imgInfo
-					}
-				}
-			}
+			log.Printf("    WARNING: %v", err)
+			result.Errors = append(result.Errors, err.Error())
 		}
-		log.Printf(" K8s: Finished scanning env '%s'.", env.Name)
+		for _, imgInfo := range found {
+			unique[imgInfo.Image] = true
+		}
+		images = append(images, found...)
+		result.Namespaces++
 	}
+	result.Images = len(unique)
+	return result, images, nil
+}
 
-	for _, v := range globalSafeListMap {
-		globalSafeList = append(globalSafeList, v)
+// scanNamespace collects the images of the workloads and extra image sources in one
+// namespace, counting scanned and skipped workloads in result. Failures to list a kind of
+// resource are returned rather than aborting; if the deployments can't be listed, the
+// rest of the namespace is skipped.
+func scanNamespace(ctx context.Context, clientset kubernetes.Interface, sources []imageSource, env *config.K8sEnvConfig, ns string, result *EnvScanResult) ([]SafeImageInfo, []error) {
+	var images []SafeImageInfo
+	var errs []error
+
+	deployments, err := clientset.AppsV1().Deployments(ns).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to list deployments in ns %s: %w", ns, err)}
 	}
-	return globalSafeList, nil
-}
\ No newline at end of file
+	for _, d := range deployments.Items {
+		// Check if pod should be processed based on whitelist/blacklist
+		if !config.ShouldProcessWorkload(d.Name, env.PodWhitelist, env.PodBlacklist) {
+			log.Printf("      Skipping deployment %s (filtered by whitelist/blacklist)", d.Name)
+			result.Skipped++
+			continue
+		}
+		result.Workloads++
+		found, err := getSafeImagesForWorkload(ctx, clientset, env, ns, &d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		images = append(images, found...)
+	}
+
+	for _, src := range sources {
+		found, err := src.images(ctx, ns)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read image source %s in ns %s: %w", src.describe(), ns, err))
+			continue
+		}
+		for _, imgInfo := range found {
+			imgInfo.Env = env.Name
+			images = append(images, imgInfo)
+		}
+	}
+
+	statefulsets, err := clientset.AppsV1().StatefulSets(ns).List(ctx, v1.ListOptions{})
+	if err != nil {
+		return images, append(errs, fmt.Errorf("failed to list statefulsets in ns %s: %w", ns, err))
+	}
+	for _, s := range statefulsets.Items {
+		// Check if pod should be processed based on whitelist/blacklist
+		if !config.ShouldProcessWorkload(s.Name, env.PodWhitelist, env.PodBlacklist) {
+			log.Printf("      Skipping statefulset %s (filtered by whitelist/blacklist)", s.Name)
+			result.Skipped++
+			continue
+		}
+		result.Workloads++
+		for _, c := range s.Spec.Template.Spec.Containers {
+			images = append(images, SafeImageInfo{Image: c.Image, Env: env.Name, Namespace: ns})
+		}
+	}
+	return images, errs
+}
+
+// isAccessDenied reports whether err is a Kubernetes forbidden (403) or unauthorized (401)
+// error, as opposed to a transient failure.
+func isAccessDenied(err error) bool {
+	return apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err)
+}
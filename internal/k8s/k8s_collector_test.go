@@ -0,0 +1,85 @@
+package k8s
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"harbor-cleaner/internal/config"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// replicaSet returns a ReplicaSet of deployment running image, created age ago at revision.
+func replicaSet(deployment *appsv1.Deployment, name, image string, revision int, age time.Duration) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              name,
+			Namespace:         deployment.Namespace,
+			Labels:            deployment.Spec.Selector.MatchLabels,
+			Annotations:       map[string]string{revisionAnnotation: strconv.Itoa(revision)},
+			CreationTimestamp: v1.NewTime(time.Now().Add(-age)),
+			OwnerReferences:   []v1.OwnerReference{*v1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}}},
+		},
+	}
+}
+
+// TestGetSafeImagesForWorkloadRollback covers a deployment released as v1, v2 and v3,
+// then rolled back to v1 and again to v2. Each rollback gave the old ReplicaSet a new,
+// higher revision, so the active v2 must rank first and v1 must rank before v3, although
+// the ReplicaSet of v3 is the newest by creation time.
+func TestGetSafeImagesForWorkloadRollback(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{Name: "api", Namespace: "prod", UID: "api-uid"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "harbor/app/api:v2"}}}},
+		},
+	}
+	clientset := fake.NewSimpleClientset(
+		replicaSet(deployment, "api-v1", "harbor/app/api:v1", 4, 72*time.Hour),
+		replicaSet(deployment, "api-v2", "harbor/app/api:v2", 5, 48*time.Hour),
+		replicaSet(deployment, "api-v3", "harbor/app/api:v3", 3, 24*time.Hour),
+	)
+
+	tests := []struct {
+		name string
+		env  config.K8sEnvConfig
+		want []string
+	}{
+		{"keep one", config.K8sEnvConfig{Name: "prod", Keep: 1}, []string{"harbor/app/api:v2"}},
+		{"keep two", config.K8sEnvConfig{Name: "prod", Keep: 2}, []string{"harbor/app/api:v2", "harbor/app/api:v1"}},
+		{"keep all", config.K8sEnvConfig{Name: "prod", Keep: 5}, []string{"harbor/app/api:v2", "harbor/app/api:v1", "harbor/app/api:v3"}},
+		{"rollout history", config.K8sEnvConfig{Name: "prod", RolloutHistory: true}, []string{"harbor/app/api:v2", "harbor/app/api:v1", "harbor/app/api:v3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images, err := getSafeImagesForWorkload(context.Background(), clientset, &tt.env, "prod", deployment)
+			if err != nil {
+				t.Fatalf("getSafeImagesForWorkload: %v", err)
+			}
+			var got []string
+			for _, img := range images {
+				if img.Env != "prod" || img.Namespace != "prod" {
+					t.Errorf("image %s has env %q and namespace %q, want prod", img.Image, img.Env, img.Namespace)
+				}
+				got = append(got, img.Image)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
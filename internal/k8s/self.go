@@ -0,0 +1,74 @@
+// File: self.go
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// serviceAccountNamespaceFile holds the pod's namespace in every in-cluster container.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// OwnImages returns the image references of the cleaner's own pod, so it never deletes the
+// image it runs from. HARBOR_CLEANER_IMAGE (comma-separated) is used when set; otherwise,
+// inside a cluster, the pod named by POD_NAME (or the hostname) in POD_NAMESPACE (or the
+// service account's namespace) is read, which needs "get" on pods. Both the image of every
+// container and the digest it resolved to are returned. Outside a cluster it returns nil.
+func OwnImages(ctx context.Context) ([]string, error) {
+	if value := os.Getenv("HARBOR_CLEANER_IMAGE"); value != "" {
+		var images []string
+		for _, image := range strings.Split(value, ",") {
+			if image = strings.TrimSpace(image); image != "" {
+				images = append(images, image)
+			}
+		}
+		return images, nil
+	}
+
+	restCfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, nil // Not running in a cluster
+	}
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to determine the pod name: %w", err)
+		}
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine the pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create in-cluster client: %w", err)
+	}
+	pod, err := clientset.CoreV1().Pods(namespace).Get(ctx, name, v1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read own pod %s/%s: %w", namespace, name, err)
+	}
+
+	var images []string
+	for _, c := range pod.Spec.Containers {
+		images = append(images, c.Image)
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		// imageID is e.g. "docker-pullable://host/project/repo@sha256:..." depending on the runtime.
+		if _, ref, ok := strings.Cut(s.ImageID, "://"); ok {
+			images = append(images, ref)
+		} else if s.ImageID != "" {
+			images = append(images, s.ImageID)
+		}
+	}
+	return images, nil
+}
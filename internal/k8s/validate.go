@@ -0,0 +1,40 @@
+// File: validate.go
+// Description: This file contains the scan stage's validation mode, which checks that every
+// environment can be reached and read without producing a manifest.
+
+package k8s
+
+import (
+	"context"
+	"log"
+
+	"harbor-cleaner/internal/config"
+)
+
+// ValidateK8sAccess connects to every environment and scans it like the scan stage does,
+// but only counts what it finds. Failing to connect to an environment, or an environment
+// without allow-empty contributing no images, is recorded in its Errors instead of aborting,
+// so every problem shows up in one run. It returns ctx's error if ctx is cancelled.
+func ValidateK8sAccess(ctx context.Context, cfg *config.K8sConfig) ([]EnvScanResult, error) {
+	var results []EnvScanResult
+	for _, env := range cfg.Environments {
+		log.Printf(" K8s: Validating env '%s'...", env.Name)
+		result, _, err := scanEnv(ctx, &env)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return results, ctxErr
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		} else if result.Images == 0 && !env.AllowEmpty {
+			result.Errors = append(result.Errors, "no images found")
+		}
+		for _, msg := range result.Denied {
+			log.Printf("    ❌ access denied: %s", msg)
+		}
+		for _, msg := range result.Errors {
+			log.Printf("    ❌ %s", msg)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
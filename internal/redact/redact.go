@@ -0,0 +1,141 @@
+// File: redact.go
+// Description: This file contains the helpers that keep credentials out of logged URLs and
+// errors.
+
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces every redacted value.
+const Mask = "xxxxx"
+
+// sensitiveParams are query parameters whose values are masked, compared case-insensitively.
+// Any parameter containing "token", "secret", "password" or "signature" is masked as well.
+var sensitiveParams = []string{"key", "apikey", "api_key", "auth", "authorization", "credential", "x-amz-credential", "sig", "code"}
+
+var (
+	urlPattern  = regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^\s"'<>]+`)
+	authPattern = regexp.MustCompile(`(?i)\b(basic|bearer)\s+[A-Za-z0-9+/=._~-]+`)
+)
+
+// URL returns raw with the password of its user info and the values of sensitive query
+// parameters masked. A URL that can't be parsed is returned with everything up to "@"
+// masked, if it has user info.
+func URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		if scheme, rest, ok := strings.Cut(raw, "://"); ok {
+			if at := strings.LastIndex(rest, "@"); at >= 0 {
+				return scheme + "://" + Mask + rest[at:]
+			}
+		}
+		return raw
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), Mask)
+		}
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name, values := range query {
+			if sensitiveParam(name) {
+				for i := range values {
+					values[i] = Mask
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func sensitiveParam(name string) bool {
+	lower := strings.ToLower(name)
+	for _, part := range []string{"token", "secret", "password", "passwd", "signature"} {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	for _, p := range sensitiveParams {
+		if lower == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Text redacts every URL in s as URL does, masks basic and bearer credentials, and replaces
+// the given secrets, such as the Harbor password, wherever they stand on their own, i.e. not
+// next to a letter or digit, so a short password doesn't mangle the words that contain it.
+// Empty secrets are ignored.
+func Text(s string, secrets ...string) string {
+	s = urlPattern.ReplaceAllStringFunc(s, func(match string) string {
+		// Punctuation ending a sentence or a "to <url>: <error>" prefix is not part of the URL.
+		trimmed := strings.TrimRight(match, ".,;:)")
+		return URL(trimmed) + match[len(trimmed):]
+	})
+	s = authPattern.ReplaceAllString(s, "$1 "+Mask)
+	for _, secret := range secrets {
+		if secret != "" {
+			s = replaceToken(s, secret)
+			// The password may also appear escaped inside a URL.
+			if escaped := url.QueryEscape(secret); escaped != secret {
+				s = replaceToken(s, escaped)
+			}
+		}
+	}
+	return s
+}
+
+// replaceToken masks the occurrences of token in s that don't continue a word: a token that
+// starts (ends) with a letter or digit must not be preceded (followed) by one.
+func replaceToken(s, token string) string {
+	checkStart, checkEnd := isAlphanumeric(token[0]), isAlphanumeric(token[len(token)-1])
+	var b strings.Builder
+	for {
+		i := strings.Index(s, token)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		end := i + len(token)
+		if (checkStart && i > 0 && isAlphanumeric(s[i-1])) || (checkEnd && end < len(s) && isAlphanumeric(s[end])) {
+			b.WriteString(s[:i+1])
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(s[:i])
+		b.WriteString(Mask)
+		s = s[end:]
+	}
+}
+
+func isAlphanumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// redactedError carries the redacted message of an error while still unwrapping to it, so
+// errors.Is and errors.As keep working.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// Error returns err with its message redacted as by Text, or nil for a nil err.
+func Error(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*redactedError); ok {
+		return err
+	}
+	return &redactedError{msg: Text(err.Error(), secrets...), err: err}
+}
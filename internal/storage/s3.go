@@ -0,0 +1,203 @@
+// File: s3.go
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"harbor-cleaner/internal/redact"
+)
+
+// objectStore is a minimal S3 client signing path-style requests with AWS Signature V4.
+// Credentials come from the standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN variables; for gs:// URLs they are a GCS HMAC key.
+type objectStore struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	httpClient   *http.Client
+}
+
+// openObject parses an s3:// or gs:// URL into its store, bucket and key.
+func openObject(path string) (*objectStore, string, string, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid object storage URL %q: %w", path, err)
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, "", "", fmt.Errorf("invalid object storage URL %q, expected %s://bucket/key", path, u.Scheme)
+	}
+
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	switch u.Scheme {
+	case "gs":
+		region, endpoint = "auto", "https://storage.googleapis.com"
+	case "s3":
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+	}
+	ep, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid object storage endpoint %q: %w", endpoint, err)
+	}
+
+	store := &objectStore{
+		endpoint:     ep,
+		region:       region,
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		httpClient:   &http.Client{Timeout: 60 * time.Second},
+	}
+	if store.accessKey == "" || store.secretKey == "" {
+		return nil, "", "", fmt.Errorf("object storage credentials missing: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return store, bucket, key, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *objectStore) get(bucket, key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object %s/%s: %w", bucket, key, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("object %s/%s: %w", bucket, key, os.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get object %s/%s: status %d, body: %s", bucket, key, resp.StatusCode, string(body))
+	}
+	return body, nil
+}
+
+func (s *objectStore) put(bucket, key string, data []byte) error {
+	resp, err := s.do(http.MethodPut, bucket, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to put object %s/%s: status %d, body: %s", bucket, key, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// do sends a signed path-style request for the object.
+func (s *objectStore) do(method, bucket, key string, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.RawPath = uriEncode(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object storage request: %w", err)
+	}
+	s.sign(req, u.RawPath, body, time.Now().UTC())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, redact.Error(fmt.Errorf("object storage request failed: %w", err), s.secretKey, s.sessionToken)
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature V4 headers to req, signing the host and every header already
+// set on it. canonicalURI is the URI-encoded path.
+func (s *objectStore) sign(req *http.Request, canonicalURI string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.TrimSpace(v)
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, canonicalURI, "", canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// uriEncode percent-encodes every byte of path except unreserved characters and '/'.
+func uriEncode(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,136 @@
+// File: storage.go
+// Package storage reads and writes the tool's output files either on the local filesystem
+// or in an S3-compatible bucket (AWS S3, MinIO, or GCS through its interoperability API).
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IsRemote reports whether path is an object storage URL (s3:// or gs://).
+func IsRemote(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+// Sibling returns the path of the file name in the same directory, or under the same
+// object prefix, as path.
+func Sibling(path, name string) string {
+	if IsRemote(path) {
+		return path[:strings.LastIndex(path, "/")+1] + name
+	}
+	return filepath.Join(filepath.Dir(path), name)
+}
+
+// Resolve returns path, or defaultName inside it when path is a remote prefix ending in "/".
+func Resolve(path, defaultName string) string {
+	if IsRemote(path) && strings.HasSuffix(path, "/") {
+		return path + defaultName
+	}
+	return path
+}
+
+// ReadFile reads a local file or a remote object. A missing remote object is reported
+// as os.ErrNotExist, like a missing local file.
+func ReadFile(path string) ([]byte, error) {
+	if !IsRemote(path) {
+		return os.ReadFile(path)
+	}
+	store, bucket, key, err := openObject(path)
+	if err != nil {
+		return nil, err
+	}
+	return store.get(bucket, key)
+}
+
+// WriteFile writes data to a local file or a remote object, replacing any existing content.
+// Local files are replaced atomically, like with Create.
+func WriteFile(path string, data []byte) error {
+	if !IsRemote(path) {
+		w, err := createLocal(path)
+		if err != nil {
+			return err
+		}
+		w.Write(data)
+		return w.Close()
+	}
+	store, bucket, key, err := openObject(path)
+	if err != nil {
+		return err
+	}
+	return store.put(bucket, key, data)
+}
+
+// Create returns a writer for path. Remote objects are buffered in memory and uploaded on Close.
+// Local files are written to a temporary file next to path that replaces it on Close, so a
+// failed write never leaves a truncated file behind.
+func Create(path string) (io.WriteCloser, error) {
+	if !IsRemote(path) {
+		return createLocal(path)
+	}
+	if _, _, _, err := openObject(path); err != nil {
+		return nil, err
+	}
+	return &objectWriter{path: path}, nil
+}
+
+// objectWriter buffers a remote object until it is closed.
+type objectWriter struct {
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *objectWriter) Close() error {
+	return WriteFile(w.path, w.buf.Bytes())
+}
+
+// localWriter writes a local file through a temporary file that is renamed over the target
+// on Close, unless a write failed.
+type localWriter struct {
+	file *os.File
+	path string
+	err  error
+}
+
+func createLocal(path string) (*localWriter, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{file: file, path: path}, nil
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *localWriter) Close() error {
+	err := w.err
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(w.file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(w.file.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+// File: alert.go
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harbor-cleaner/internal/redact"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Alert is the JSON body posted to alert-webhook-url. Text is a readable summary, so the
+// alert can go straight to chat tools that accept incoming webhooks with a "text" field.
+type Alert struct {
+	Text            string       `json:"text"`
+	Strategy        string       `json:"strategy"`
+	RunID           string       `json:"runId"`
+	Candidates      int          `json:"candidates"`
+	Threshold       int          `json:"threshold"`
+	HarborVersion   string       `json:"harborVersion,omitempty"`
+	TopRepositories []AlertCount `json:"topRepositories"`
+	// Labels are the configured labels, for routing the alert.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// AlertCount is the number of delete candidates of one repository.
+type AlertCount struct {
+	Repository string `json:"repository"`
+	Candidates int    `json:"candidates"`
+}
+
+// PostAlert sends an alert to a webhook URL as JSON. Errors leave out the URL, since
+// incoming webhook URLs usually carry their token in the path.
+func PostAlert(webhookURL string, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return redact.Error(fmt.Errorf("failed to send alert: %w", err), webhookURL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("alert webhook returned status %d: %s", resp.StatusCode, string(msg))
+	}
+	return nil
+}
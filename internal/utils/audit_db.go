@@ -0,0 +1,87 @@
+// File: audit_db.go
+package utils
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the pure-Go "sqlite" driver
+)
+
+// AuditDBColumns are the audit columns (see config.AuditColumnNames) stored in the audit
+// database, in the order AppendAuditDB expects its records.
+var AuditDBColumns = []string{"image", "digest", "status", "size", "notes"}
+
+// AuditRun identifies the run the audit records of AppendAuditDB belong to.
+type AuditRun struct {
+	ID       string // From NewRunID
+	Time     time.Time
+	Strategy string
+}
+
+// NewRunID returns an ID for a run started at start: its timestamp and a random suffix,
+// e.g. "20240501-020000-3fa9c1", so runs started in the same second don't share it.
+func NewRunID(start time.Time) string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return start.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+const auditDBSchema = `
+CREATE TABLE IF NOT EXISTS audit (
+	run_id    TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	strategy  TEXT NOT NULL,
+	image     TEXT NOT NULL,
+	digest    TEXT,
+	status    TEXT NOT NULL,
+	size      INTEGER,
+	notes     TEXT
+);
+CREATE INDEX IF NOT EXISTS audit_image ON audit (image);
+CREATE INDEX IF NOT EXISTS audit_digest ON audit (digest);
+CREATE INDEX IF NOT EXISTS audit_run ON audit (run_id);`
+
+// AppendAuditDB appends audit records with the AuditDBColumns, starting with a header row
+// like the CSV report, to the SQLite database at path, creating it if needed. All records
+// of a run are inserted in one transaction.
+func AppendAuditDB(path string, run AuditRun, records [][]string) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit database %s: %w", path, err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(auditDBSchema); err != nil {
+		return fmt.Errorf("failed to create audit table in %s: %w", path, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start audit database transaction: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO audit (run_id, timestamp, strategy, image, digest, status, size, notes) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare audit insert: %w", err)
+	}
+	defer stmt.Close()
+
+	timestamp := run.Time.UTC().Format(time.RFC3339)
+	for i, record := range records {
+		if i == 0 {
+			continue // Header row
+		}
+		size, _ := strconv.ParseInt(record[3], 10, 64)
+		if _, err := stmt.Exec(run.ID, timestamp, run.Strategy, record[0], record[1], record[2], size, record[4]); err != nil {
+			return fmt.Errorf("failed to insert audit record for %s: %w", record[0], err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit records to %s: %w", path, err)
+	}
+	return nil
+}
@@ -2,11 +2,21 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"harbor-cleaner/internal/k8s"
+	"harbor-cleaner/internal/storage"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // ImageContext holds usage details for an image.
@@ -15,49 +25,82 @@ type ImageContext struct {
 	Namespace string
 }
 
-// writeManifestToCSV writes the collected safe image info to a CSV manifest file.
-func WriteManifestToCSV(records []k8s.SafeImageInfo, path string) error {
-	file, err := os.Create(path)
+// writeManifestToCSV writes the collected safe image info to a CSV manifest file, with the
+// ID of the run that scanned it in every row. path may be a local file or an s3:// or gs://
+// object URL.
+func WriteManifestToCSV(records []k8s.SafeImageInfo, path string, runID string) (err error) {
+	file, err := storage.Create(path)
 	if err != nil {
 		return fmt.Errorf("failed to create manifest file: %w", err)
 	}
-	defer file.Close()
+	defer func() {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to save manifest file: %w", cerr)
+		}
+	}()
 
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
 	// Write header
-	if err := writer.Write([]string{"image", "environment", "namespace"}); err != nil {
+	if err := writer.Write([]string{"image", "environment", "namespace", "run_id"}); err != nil {
 		return fmt.Errorf("failed to write header to manifest: %w", err)
 	}
 
 	// Write records
 	for _, record := range records {
-		if err := writer.Write([]string{record.Image, record.Env, record.Namespace}); err != nil {
+		if err := writer.Write([]string{record.Image, record.Env, record.Namespace, runID}); err != nil {
 			return fmt.Errorf("failed to write record to manifest: %w", err)
 		}
 	}
 	return nil
 }
 
-// ReadManifestFromCSV reads the manifest file and returns both a simple safe list map
-// and a map for looking up context.
-func ReadManifestFromCSV(path string) (map[string]struct{}, map[string][]ImageContext, error) {
-	file, err := os.Open(path)
+// PrintSafeList writes the safe image info as an aligned table, sorted by image, instead of a manifest file.
+func PrintSafeList(records []k8s.SafeImageInfo, w io.Writer) error {
+	sorted := make([]k8s.SafeImageInfo, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Image < sorted[j].Image
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tENVIRONMENT\tNAMESPACE")
+	for _, record := range sorted {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", record.Image, record.Env, record.Namespace)
+	}
+	return tw.Flush()
+}
+
+// ReadManifestFromCSV reads one or more manifest files and returns both a simple safe list map
+// and a map for looking up context. Multiple manifests (e.g. one per cluster) are merged, so an
+// image is safe if any of them lists it.
+func ReadManifestFromCSV(paths ...string) (map[string]struct{}, map[string][]ImageContext, error) {
+	safeImageSet := make(map[string]struct{})
+	contextMap := make(map[string][]ImageContext)
+
+	for _, path := range paths {
+		if err := mergeManifestFromCSV(path, safeImageSet, contextMap); err != nil {
+			return nil, nil, err
+		}
+	}
+	return safeImageSet, contextMap, nil
+}
+
+// mergeManifestFromCSV reads a single manifest file into the given maps, skipping
+// contexts that are already recorded for an image.
+func mergeManifestFromCSV(path string, safeImageSet map[string]struct{}, contextMap map[string][]ImageContext) error {
+	data, err := storage.ReadFile(path)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open manifest file: %w", err)
+		return fmt.Errorf("failed to open manifest file %s: %w", path, err)
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
+	reader := csv.NewReader(bytes.NewReader(data))
 	records, err := reader.ReadAll()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read manifest csv: %w", err)
+		return fmt.Errorf("failed to read manifest csv %s: %w", path, err)
 	}
 
-	safeImageSet := make(map[string]struct{})
-	contextMap := make(map[string][]ImageContext)
-
 	// Skip header row
 	for i, record := range records {
 		if i == 0 {
@@ -65,31 +108,225 @@ func ReadManifestFromCSV(path string) (map[string]struct{}, map[string][]ImageCo
 		}
 		if len(record) >= 3 {
 			image := strings.TrimSpace(record[0])
-			env := strings.TrimSpace(record[1])
-			ns := strings.TrimSpace(record[2])
-			if image != "" {
-				safeImageSet[image] = struct{}{}
-				contextMap[image] = append(contextMap[image], ImageContext{Env: env, Namespace: ns})
+			imgCtx := ImageContext{Env: strings.TrimSpace(record[1]), Namespace: strings.TrimSpace(record[2])}
+			if image == "" {
+				continue
+			}
+			safeImageSet[image] = struct{}{}
+			if !containsContext(contextMap[image], imgCtx) {
+				contextMap[image] = append(contextMap[image], imgCtx)
 			}
 		}
 	}
-	return safeImageSet, contextMap, nil
+	return nil
+}
+
+func containsContext(contexts []ImageContext, c ImageContext) bool {
+	for _, existing := range contexts {
+		if existing == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ManifestDiff describes how the safe list changed between two manifests.
+type ManifestDiff struct {
+	Added    []string             // Images only in the new manifest
+	Removed  []string             // Images only in the old manifest
+	Changed  []string             // Images in both, with different contexts
+	Contexts map[string][2]string // Old and new contexts of every image above, as "env/namespace" lists
+	OldTotal int                  // Images in the old manifest
 }
 
-// WriteAuditReport writes the final audit data to a CSV file.
-func WriteAuditReport(records [][]string, path string) error {
-	file, err := os.Create(path)
+// DiffManifests compares an old manifest against one or more new ones (merged as in the
+// clean stage). All image lists are sorted.
+func DiffManifests(oldPath string, newPaths ...string) (ManifestDiff, error) {
+	oldSet, oldContexts, err := ReadManifestFromCSV(oldPath)
 	if err != nil {
-		return fmt.Errorf("failed to create audit report file: %w", err)
+		return ManifestDiff{}, err
+	}
+	newSet, newContexts, err := ReadManifestFromCSV(newPaths...)
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+
+	diff := ManifestDiff{Contexts: make(map[string][2]string), OldTotal: len(oldSet)}
+	for image := range newSet {
+		newCtx := formatContexts(newContexts[image])
+		if _, ok := oldSet[image]; !ok {
+			diff.Added = append(diff.Added, image)
+			diff.Contexts[image] = [2]string{"", newCtx}
+		} else if oldCtx := formatContexts(oldContexts[image]); oldCtx != newCtx {
+			diff.Changed = append(diff.Changed, image)
+			diff.Contexts[image] = [2]string{oldCtx, newCtx}
+		}
+	}
+	for image := range oldSet {
+		if _, ok := newSet[image]; !ok {
+			diff.Removed = append(diff.Removed, image)
+			diff.Contexts[image] = [2]string{formatContexts(oldContexts[image]), ""}
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff, nil
+}
+
+// formatContexts renders image contexts as a sorted "env/namespace" list.
+func formatContexts(contexts []ImageContext) string {
+	parts := make([]string, len(contexts))
+	for i, c := range contexts {
+		parts[i] = c.Env + "/" + c.Namespace
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// PrintManifestDiff writes a manifest diff as an aligned table: removed images first, as
+// they are the ones the clean stage would no longer protect.
+func PrintManifestDiff(diff ManifestDiff, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tIMAGE\tOLD CONTEXTS\tNEW CONTEXTS")
+	for _, group := range []struct {
+		change string
+		images []string
+	}{{"removed", diff.Removed}, {"added", diff.Added}, {"changed", diff.Changed}} {
+		for _, image := range group.images {
+			ctx := diff.Contexts[image]
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", group.change, image, dashIfEmpty(ctx[0]), dashIfEmpty(ctx[1]))
+		}
+	}
+	return tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// ReadInventory reads a desired-state inventory of "project/repo:tag" entries. YAML files
+// (.yaml/.yml) hold a top-level "images" list; any other file is read as CSV with the
+// entry in the first column and a header row.
+func ReadInventory(path string) (map[string]struct{}, error) {
+	var entries []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err := storage.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory file: %w", err)
+		}
+		var doc struct {
+			Images []string `yaml:"images"`
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse inventory yaml: %w", err)
+		}
+		entries = doc.Images
+	default:
+		data, err := storage.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open inventory file: %w", err)
+		}
+
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory csv: %w", err)
+		}
+		// Skip header row
+		for i, record := range records {
+			if i > 0 && len(record) > 0 {
+				entries = append(entries, record[0])
+			}
+		}
+	}
+
+	inventory := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") || !strings.Contains(entry, ":") {
+			return nil, fmt.Errorf("invalid inventory entry %q, expected project/repo:tag", entry)
+		}
+		inventory[entry] = struct{}{}
+	}
+	return inventory, nil
+}
+
+// WriteAuditReport writes the final audit data to a CSV file. The first record is the header.
+// In append mode the records are added to an existing report and the header is only
+// written when the file is new or empty; otherwise the file is overwritten.
+func WriteAuditReport(records [][]string, path string, appendMode bool) error {
+	if storage.IsRemote(path) {
+		return writeRemoteAuditReport(records, path, appendMode)
+	}
+	if !appendMode {
+		file, err := storage.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create audit report file: %w", err)
+		}
+		if err := csv.NewWriter(file).WriteAll(records); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write audit report: %w", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to save audit report: %w", err)
+		}
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit report file for appending: %w", err)
 	}
 	defer file.Close()
 
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat audit report file: %w", err)
+	}
+	if info.Size() > 0 && len(records) > 0 {
+		records = records[1:] // Header already present
+	}
+
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
 	return writer.WriteAll(records)
 }
 
+// writeRemoteAuditReport writes the audit report to object storage. Objects cannot be appended
+// to, so in append mode the existing report is downloaded and uploaded again with the new records.
+func writeRemoteAuditReport(records [][]string, path string, appendMode bool) error {
+	var buf bytes.Buffer
+	if appendMode {
+		existing, err := storage.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read audit report for appending: %w", err)
+		}
+		buf.Write(existing)
+		if len(existing) > 0 && len(records) > 0 {
+			records = records[1:] // Header already present
+		}
+	}
+
+	writer := csv.NewWriter(&buf)
+	if err := writer.WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write audit report: %w", err)
+	}
+	if err := storage.WriteFile(path, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to upload audit report: %w", err)
+	}
+	return nil
+}
+
 // ParseWhitelist parses a comma-separated string into a map for quick lookups.
 func ParseWhitelist(whitelistCSV string) map[string]struct{} {
 	if whitelistCSV == "" {
@@ -101,4 +338,39 @@ func ParseWhitelist(whitelistCSV string) map[string]struct{} {
 		whitelist[strings.TrimSpace(item)] = struct{}{}
 	}
 	return whitelist
-}
\ No newline at end of file
+}
+
+// ReadLastRun reads the start time of the previous successful run from path.
+func ReadLastRun(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read last run file: %w", err)
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp in last run file %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// WriteLastRun records t as the start time of the latest successful run.
+func WriteLastRun(path string, t time.Time) error {
+	if err := os.WriteFile(path, []byte(t.Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write last run file: %w", err)
+	}
+	return nil
+}
+
+// FormatBytes formats a byte count with a binary unit, e.g. "1.5 GiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,104 @@
+// File: json_log.go
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// logTimePrefix is the date and time the standard logger puts before every entry.
+const logTimePrefix = "2006/01/02 15:04:05 "
+
+var (
+	// decisionLine matches the per-artifact lines such as "🔴 TO BE DELETED: host/repo:tag".
+	decisionLine = regexp.MustCompile(`^\S+ ([A-Z][A-Z_ ]*[A-Z]): (\S+)`)
+	projectLine  = regexp.MustCompile(`Processing Project: (\S+)`)
+	repoLine     = regexp.MustCompile(`Processing Repository: (\S+)`)
+)
+
+// jsonLogEntry is one line of the JSON log.
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	RunID   string `json:"run_id"`
+	Msg     string `json:"msg"`
+	Project string `json:"project,omitempty"`
+	Repo    string `json:"repo,omitempty"`
+	Image   string `json:"image,omitempty"`
+	Action  string `json:"action,omitempty"`
+}
+
+// jsonLogWriter turns the entries of the standard logger into JSON lines.
+type jsonLogWriter struct {
+	w             io.Writer
+	runID         string
+	project, repo string // Last project and repository being processed
+}
+
+// NewJSONLogWriter returns a writer for log.SetOutput that writes each log entry to w as a
+// JSON object on its own line, with the fields time, level, run_id and msg. The level is derived
+// from the entry's icon: "error" for ❌ and 🆘, "warn" for ⚠️, otherwise "info". Per-artifact
+// decisions add image and action (the audit status) and the project and repository of the
+// image; other entries carry the project and repository last logged as being processed,
+// which can be off for interleaved lines when repositories are cleaned concurrently.
+func NewJSONLogWriter(w io.Writer, runID string) io.Writer {
+	return &jsonLogWriter{w: w, runID: runID}
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	entry := jsonLogEntry{Time: time.Now().Format(time.RFC3339), Level: "info", RunID: j.runID}
+	text := string(p)
+	if len(text) >= len(logTimePrefix) {
+		if t, err := time.ParseInLocation(logTimePrefix[:len(logTimePrefix)-1], text[:len(logTimePrefix)-1], time.Local); err == nil {
+			entry.Time = t.Format(time.RFC3339)
+			text = text[len(logTimePrefix):]
+		}
+	}
+	entry.Msg = strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(entry.Msg, "❌"), strings.HasPrefix(entry.Msg, "🆘"):
+		entry.Level = "error"
+	case strings.HasPrefix(entry.Msg, "⚠️"):
+		entry.Level = "warn"
+	}
+
+	if m := projectLine.FindStringSubmatch(entry.Msg); m != nil {
+		j.project, j.repo = m[1], ""
+	} else if m := repoLine.FindStringSubmatch(entry.Msg); m != nil {
+		j.repo = m[1]
+	}
+	entry.Project, entry.Repo = j.project, j.repo
+	if m := decisionLine.FindStringSubmatch(entry.Msg); m != nil {
+		entry.Action, entry.Image = m[1], m[2]
+		entry.Repo = imageRepository(entry.Image)
+		entry.Project, _, _ = strings.Cut(entry.Repo, "/")
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// imageRepository returns the repository of an image reference such as
+// "https://harbor.local/library/nginx:1.25", i.e. "library/nginx".
+func imageRepository(image string) string {
+	if _, rest, ok := strings.Cut(image, "://"); ok {
+		image = rest
+	}
+	image, _, _ = strings.Cut(image, "@")
+	if _, rest, ok := strings.Cut(image, "/"); ok {
+		image = rest
+	}
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image = image[:colon]
+	}
+	return image
+}
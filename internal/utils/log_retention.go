@@ -0,0 +1,41 @@
+// File: log_retention.go
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// runLogName matches the default log file names, "harbor-cleaner-<timestamp>-strategy-
+// <strategy>-stage-<stage>.log", capturing the timestamp.
+var runLogName = regexp.MustCompile(`^harbor-cleaner-(\d{8}-\d{6})-strategy-[a-z0-9-]*-stage-[a-z]*\.log$`)
+
+// PruneRunLogs deletes the default-named log files in dir whose timestamp is more than
+// maxAge before now, and returns the names of the deleted files. Other files are never
+// touched. It stops at the first file that can't be deleted.
+func PruneRunLogs(dir string, maxAge time.Duration, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log directory %s: %w", dir, err)
+	}
+	cutoff := now.Add(-maxAge)
+	var deleted []string
+	for _, entry := range entries {
+		m := runLogName.FindStringSubmatch(entry.Name())
+		if m == nil || !entry.Type().IsRegular() {
+			continue
+		}
+		t, err := time.ParseInLocation("20060102-150405", m[1], time.Local)
+		if err != nil || !t.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return deleted, fmt.Errorf("failed to delete old log file: %w", err)
+		}
+		deleted = append(deleted, entry.Name())
+	}
+	return deleted, nil
+}
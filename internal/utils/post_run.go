@@ -0,0 +1,46 @@
+// File: post_run.go
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// RunPostRunCommand runs command with env added to the cleaner's environment and logs each
+// line of its output. It returns an error if the command can't be started, exits with a
+// non-zero status or is still running after timeout, in which case it is killed. Output of
+// processes it left behind is no longer read a second after it exits.
+func RunPostRunCommand(command []string, env []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.WaitDelay = time.Second
+	r, w := io.Pipe()
+	cmd.Stdout, cmd.Stderr = w, w
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			log.Printf("    🪝 %s", scanner.Text())
+		}
+		io.Copy(io.Discard, r) // Drain a line too long for the scanner
+	}()
+	err := cmd.Run()
+	w.Close()
+	<-done
+	if ctx.Err() != nil {
+		return fmt.Errorf("post-run command did not finish within %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("post-run command failed: %w", err)
+	}
+	return nil
+}
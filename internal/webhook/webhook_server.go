@@ -0,0 +1,207 @@
+// File: webhook_server.go
+// Description: This file contains an HTTP server that receives Harbor webhooks and applies
+// the harbor strategy's retention rules to just the repository that was pushed to.
+
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"harbor-cleaner/internal/cleaner"
+	"harbor-cleaner/internal/config"
+	"harbor-cleaner/internal/harbor"
+	"harbor-cleaner/internal/utils"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pushEventType is the Harbor event type sent after an artifact is pushed.
+	pushEventType = "PUSH_ARTIFACT"
+	// queueSize bounds the number of repositories waiting to be cleaned.
+	queueSize = 100
+	// readHeaderTimeout bounds how long a client may take to send the request headers.
+	readHeaderTimeout = 10 * time.Second
+)
+
+// pushEvent is the subset of Harbor's webhook payload needed to locate the pushed repository.
+type pushEvent struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Repository struct {
+			Namespace    string `json:"namespace"`
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+// repoRef identifies a repository queued for cleanup.
+type repoRef struct {
+	project string
+	repo    string
+}
+
+// Server receives Harbor push webhooks and cleans the affected repositories one at a time.
+// Events for a repository that is already queued are coalesced.
+type Server struct {
+	client           *harbor.HarborClient
+	cfg              *config.Config
+	projectWhitelist map[string]struct{}
+	auditFile        string
+
+	queue   chan repoRef
+	mu      sync.Mutex
+	pending map[repoRef]struct{}
+}
+
+// NewServer creates a webhook server. Audit records of every cleanup are appended to auditFile.
+func NewServer(client *harbor.HarborClient, cfg *config.Config, projectWhitelist map[string]struct{}, auditFile string) *Server {
+	return &Server{
+		client:           client,
+		cfg:              cfg,
+		projectWhitelist: projectWhitelist,
+		auditFile:        auditFile,
+		queue:            make(chan repoRef, queueSize),
+		pending:          make(map[repoRef]struct{}),
+	}
+}
+
+// Run serves webhooks until ctx is cancelled, then shuts the HTTP server down gracefully.
+// Without webhook.secret it refuses to start, unless webhook.insecure is set.
+func (s *Server) Run(ctx context.Context) error {
+	if s.cfg.Webhook.Secret == "" {
+		if !s.cfg.Webhook.Insecure {
+			return errors.New("webhook.secret is not set; set it to the Auth Header of the Harbor webhook policy, or set webhook.insecure to accept unauthenticated requests")
+		}
+		log.Println("⚠️  No webhook secret configured and webhook.insecure is set; requests are not authenticated.")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(s.cfg.Webhook.Path, s)
+	srv := &http.Server{Addr: s.cfg.Webhook.Listen, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.worker(ctx)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("👂 Listening for Harbor webhooks on %s%s", s.cfg.Webhook.Listen, s.cfg.Webhook.Path)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// ServeHTTP validates and queues a single webhook delivery.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Harbor sends the policy's "Auth Header" value verbatim in the Authorization header.
+	if secret := s.cfg.Webhook.Secret; secret != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(secret)) != 1 {
+			log.Printf("🚫 Rejected webhook from %s: invalid secret", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var event pushEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&event); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if event.Type != pushEventType {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ref := repoRef{
+		project: event.EventData.Repository.Namespace,
+		repo:    event.EventData.Repository.RepoFullName,
+	}
+	if ref.project == "" || !strings.HasPrefix(ref.repo, ref.project+"/") {
+		http.Error(w, "payload is missing the repository", http.StatusBadRequest)
+		return
+	}
+	if s.projectWhitelist != nil {
+		if _, ok := s.projectWhitelist[ref.project]; !ok {
+			log.Printf("⏭️  Ignoring push to %s (project not in whitelist).", ref.repo)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	if !s.enqueue(ref) {
+		http.Error(w, "cleanup queue is full", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// enqueue adds ref to the queue unless it is already pending. It returns false if the queue is full.
+func (s *Server) enqueue(ref repoRef) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[ref]; ok {
+		return true
+	}
+	select {
+	case s.queue <- ref:
+		s.pending[ref] = struct{}{}
+		log.Printf("📥 Queued repository %s for cleanup.", ref.repo)
+		return true
+	default:
+		return false
+	}
+}
+
+// worker cleans queued repositories sequentially until ctx is cancelled.
+func (s *Server) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ref := <-s.queue:
+			s.mu.Lock()
+			delete(s.pending, ref)
+			s.mu.Unlock()
+
+			result, err := cleaner.CleanRepository(ctx, s.client, s.cfg, ref.project, ref.repo)
+			if err != nil {
+				log.Printf("❌ Failed to clean repository %s: %v", ref.repo, err)
+				continue
+			}
+			log.Printf("✅ Cleaned repository %s: %d artifacts processed, %d deleted, %d failed, %d kept.", ref.repo, result.Processed(), result.Deleted, result.Failed, result.Kept)
+			if len(result.Audit) > 1 {
+				result.RunID = s.cfg.RunID
+				if err := utils.WriteAuditReport(result.AuditRecords(s.cfg.AuditColumns, s.cfg.AuditGroupByStatus), s.auditFile, true); err != nil {
+					log.Printf("❌ Failed to write audit report: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"harbor-cleaner/internal/config"
+)
+
+// pushPayload returns a webhook payload of the given event type for repository repo of
+// project.
+func pushPayload(eventType, project, repo string) string {
+	return fmt.Sprintf(`{"type":%q,"event_data":{"repository":{"namespace":%q,"repo_full_name":%q}}}`, eventType, project, project+"/"+repo)
+}
+
+// deliver sends payload to s with the given Authorization header and returns the status.
+func deliver(s *Server, auth, payload string) int {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestServeHTTP(t *testing.T) {
+	newServer := func() *Server {
+		cfg := &config.Config{Webhook: config.WebhookConfig{Secret: "s3cret"}}
+		return NewServer(nil, cfg, map[string]struct{}{"app": {}}, "")
+	}
+
+	tests := []struct {
+		name       string
+		auth       string
+		payload    string
+		wantStatus int
+		wantQueued int
+	}{
+		{"no secret", "", pushPayload(pushEventType, "app", "api"), http.StatusUnauthorized, 0},
+		{"wrong secret", "guess", pushPayload(pushEventType, "app", "api"), http.StatusUnauthorized, 0},
+		{"push", "s3cret", pushPayload(pushEventType, "app", "api"), http.StatusAccepted, 1},
+		{"other event", "s3cret", pushPayload("DELETE_ARTIFACT", "app", "api"), http.StatusNoContent, 0},
+		{"project not in whitelist", "s3cret", pushPayload(pushEventType, "other", "api"), http.StatusNoContent, 0},
+		{"missing repository", "s3cret", `{"type":"PUSH_ARTIFACT"}`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer()
+			if got := deliver(s, tt.auth, tt.payload); got != tt.wantStatus {
+				t.Errorf("status %d, want %d", got, tt.wantStatus)
+			}
+			if got := len(s.queue); got != tt.wantQueued {
+				t.Errorf("%d repositories queued, want %d", got, tt.wantQueued)
+			}
+		})
+	}
+}
+
+// TestServeHTTPQueue checks that pushes to a repository already waiting are coalesced and
+// that a full queue answers 503.
+func TestServeHTTPQueue(t *testing.T) {
+	s := NewServer(nil, &config.Config{Webhook: config.WebhookConfig{Secret: "s3cret"}}, nil, "")
+
+	for i := 0; i < 3; i++ {
+		if got := deliver(s, "s3cret", pushPayload(pushEventType, "app", "api")); got != http.StatusAccepted {
+			t.Fatalf("push %d: status %d, want %d", i, got, http.StatusAccepted)
+		}
+	}
+	if got := len(s.queue); got != 1 {
+		t.Fatalf("%d repositories queued after three pushes to one repository, want 1", got)
+	}
+
+	for i := 1; i < queueSize; i++ {
+		if got := deliver(s, "s3cret", pushPayload(pushEventType, "app", fmt.Sprintf("repo-%d", i))); got != http.StatusAccepted {
+			t.Fatalf("push to repo-%d: status %d, want %d", i, got, http.StatusAccepted)
+		}
+	}
+	if got := deliver(s, "s3cret", pushPayload(pushEventType, "app", "one-too-many")); got != http.StatusServiceUnavailable {
+		t.Errorf("push to a full queue: status %d, want %d", got, http.StatusServiceUnavailable)
+	}
+	if got := deliver(s, "s3cret", pushPayload(pushEventType, "app", "api")); got != http.StatusAccepted {
+		t.Errorf("push to a repository already queued in a full queue: status %d, want %d", got, http.StatusAccepted)
+	}
+}
+
+// TestRunRequiresSecret checks that the server doesn't start without a secret unless
+// webhook.insecure is set.
+func TestRunRequiresSecret(t *testing.T) {
+	s := NewServer(nil, &config.Config{Webhook: config.WebhookConfig{Listen: "127.0.0.1:0", Path: "/webhook"}}, nil, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Run(ctx); err == nil || !strings.Contains(err.Error(), "webhook.secret") {
+		t.Errorf("Run without a secret = %v, want an error about webhook.secret", err)
+	}
+
+	s.cfg.Webhook.Insecure = true
+	if err := s.Run(ctx); err != nil {
+		t.Errorf("Run with webhook.insecure = %v, want nil", err)
+	}
+}